@@ -6,6 +6,9 @@
 // comment out to disable PENUP support
 #define ENABLE_PENUP
 
+// comment out to disable limit switch and homing support
+#define ENABLE_HOMING
+
 // Constants and global variables
 // --------------------------------------
 const int LED_PINS_COUNT = 4;
@@ -28,6 +31,15 @@ const long PENUP_ANGLE = 40;
 const long PENDOWN_ANGLE = 140;
 #endif
 
+#ifdef ENABLE_HOMING
+const int LEFT_LIMIT_PIN = 11; // limit switches connect the pin to ground when triggered
+const int RIGHT_LIMIT_PIN = 12;
+const int HOMING_STEP_DELAY_US = 1000; // delay between steps while homing
+const long HOMING_MAX_STEPS = 100000; // homing gives up after this many steps, more than retracting the longest cord takes
+boolean homing; // true while retracting spools to the limit switches
+boolean limitTripped; // true after a limit switch tripped during a move, cleared by RESET_COMMAND
+#endif
+
 const unsigned int TIME_SLICE_US = 2048; // number of microseconds per time step
 const unsigned int TIME_SLICE_US_LOG = 11; // log base 2 of TIME_SLICE_US
 const unsigned int POS_FACTOR = 32; // fixed point factor each position is multiplied by
@@ -36,6 +48,9 @@ const unsigned int POS_FACTOR_LOG = 5; // log base 2 of POS_FACTOR, used after m
 const char RESET_COMMAND = 0x80; // -128, command to reset
 const char PENUP_COMMAND = 0x81; // -127, command to lift pen
 const char PENDOWN_COMMAND = 0x7F; // 127, command to lower pen
+const char HOME_COMMAND = 0x82; // -126, command to retract spools until the limit switches trigger
+const byte HOMED_RESPONSE = 0xFE; // sent after homing has finished
+const byte LIMIT_RESPONSE = 0xFF; // sent when a limit switch trips during a move
 
 const unsigned int MOVE_DATA_CAPACITY = 1024;
 char moveData[MOVE_DATA_CAPACITY]; // buffer of move data, circular buffer
@@ -67,6 +82,11 @@ void setup() {
   pinMode(RIGHT_STEP_PIN, OUTPUT);
   pinMode(RIGHT_DIR_PIN, OUTPUT);	
 
+#ifdef ENABLE_HOMING
+  pinMode(LEFT_LIMIT_PIN, INPUT_PULLUP);
+  pinMode(RIGHT_LIMIT_PIN, INPUT_PULLUP);
+#endif
+
 #ifdef ENABLE_PENUP
   penUpServo.attach(PENUP_SERVO_PIN);
   penUpServo.write(PENUP_ANGLE);
@@ -90,6 +110,11 @@ void ResetMovementVariables()
   leftDelta = rightDelta = leftStartPos = rightStartPos = leftCurPos = rightCurPos = 0;
   sliceStartTime = curTime;
 
+#ifdef ENABLE_HOMING
+  homing = false;
+  limitTripped = false;
+#endif
+
 #ifdef ENABLE_PENUP
   penTransitionDirection = 0;
   penUpServo.write(PENUP_ANGLE);
@@ -106,6 +131,18 @@ void loop() {
 
   long curSliceTime = curTime - sliceStartTime;
 
+#ifdef ENABLE_HOMING
+  if (homing) {
+    Home();
+    sliceStartTime = micros();
+    return;
+  }
+  if (limitTripped) { // stay stopped until the host sends a reset
+    ReadSerialMoveData();
+    return;
+  }
+#endif
+
 #ifdef ENABLE_PENUP
   if (penTransitionDirection) {
     UpdatePenTransition(curSliceTime);
@@ -157,6 +194,15 @@ void UpdateStepperPins(long curSliceTime) {
     rightSteps = -rightSteps;
   }
 
+#ifdef ENABLE_HOMING
+  // positive left and negative right steps retract the cord, stop instead of grinding past a limit switch
+  if ((leftSteps && leftPositiveDir && digitalRead(LEFT_LIMIT_PIN) == LOW) ||
+      (rightSteps && !rightPositiveDir && digitalRead(RIGHT_LIMIT_PIN) == LOW)) {
+    TripLimit();
+    return;
+  }
+#endif
+
   do {
     if (leftSteps) {
       Step(LEFT_STEP_PIN, LEFT_DIR_PIN, leftPositiveDir);
@@ -188,6 +234,53 @@ void UpdateStepperPins(long curSliceTime) {
   } while(true);
 }
 
+#ifdef ENABLE_HOMING
+// Retract both spools until their limit switches trigger
+// Gives up with LIMIT_RESPONSE after HOMING_MAX_STEPS, so a broken switch can't unwind the cord forever,
+// and stops without answering when the host sends a reset
+// --------------------------------------
+void Home() {
+  boolean leftHomed = false;
+  boolean rightHomed = false;
+
+  for (long steps = 0; !leftHomed || !rightHomed; steps++) {
+    if (steps >= HOMING_MAX_STEPS) {
+      homing = false;
+      TripLimit();
+      return;
+    }
+    if (Serial.available() > 0 && (char)Serial.peek() == RESET_COMMAND) { // left for ReadSerialMoveData to handle
+      homing = false;
+      return;
+    }
+
+    leftHomed = leftHomed || digitalRead(LEFT_LIMIT_PIN) == LOW;
+    rightHomed = rightHomed || digitalRead(RIGHT_LIMIT_PIN) == LOW;
+
+    if (!leftHomed) {
+      Step(LEFT_STEP_PIN, LEFT_DIR_PIN, true);
+    }
+    if (!rightHomed) {
+      Step(RIGHT_STEP_PIN, RIGHT_DIR_PIN, false);
+    }
+    delayMicroseconds(HOMING_STEP_DELAY_US);
+  }
+
+  homing = false;
+  leftDelta = rightDelta = leftStartPos = rightStartPos = leftCurPos = rightCurPos = 0;
+  Serial.write(HOMED_RESPONSE);
+}
+
+// Stop all movement and tell the host a limit switch tripped
+// --------------------------------------
+void TripLimit() {
+  limitTripped = true;
+  leftDelta = rightDelta = 0;
+  moveDataLength = 0;
+  Serial.write(LIMIT_RESPONSE);
+}
+#endif
+
 // Update pen position
 // --------------------------------------
 #ifdef ENABLE_PENUP
@@ -263,6 +356,13 @@ void SetSliceVariables() {
        leftDelta = rightDelta = 0;
     }
 #endif    
+
+    if (leftDelta == HOME_COMMAND) {
+      leftDelta = rightDelta = 0;
+#ifdef ENABLE_HOMING
+      homing = true;
+#endif
+    }
   }
 }                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                    
 
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
 	"sort"
 	"strconv"
 	"strings"
@@ -18,22 +22,43 @@ func init() {
 
 // main
 func main() {
-	p.Settings.Read()
+	if err := p.Settings.Read(); err != nil {
+		fmt.Println("ERROR: ", err)
+		os.Exit(1)
+	}
 	p.Config.Read()
 
 	toImageFlag := flag.Bool("toimage", false, "Output result to an image file instead of to the stepper")
 	toChartFlag := flag.Bool("tochart", false, "Output a chart of the movement and velocity")
 	countFlag := flag.Bool("count", false, "Outputs the time it would take to draw")
+	optimizeTimeFlag := flag.Float64("optimizetime", 0, "Seconds spent improving the glyph order when optimizing, overrides OptimizeTime_Seconds from the settings file")
+	strategyFlag := flag.String("strategy", "", "How optimize finds the glyphs to draw, greedy or euler, overrides OptimizeStrategy from the settings file")
+	orderFlag := flag.String("order", "", "How optimize orders the glyphs, nearest or hilbert, overrides OrderStrategy from the settings file")
+	exportFlag := flag.String("export", "", "Save the drawing to a file instead of plotting it, an svg if the name ends in .svg, otherwise a plot file")
+	jsonFlag := flag.Bool("json", false, "Output the analyze report as JSON")
+	noCacheFlag := flag.Bool("nocache", false, "Process the drawing again instead of using cached results")
+	drawSpeedFlag := flag.Float64("drawspeed", 0, "Max speed in mm/s while the pen is down, overrides DrawSpeed_MM_S from the settings file")
 	flag.Parse()
 
+	// flags only apply to this run, commands that save the settings file keep the values read from it
+	err := p.Settings.Override(p.SettingsOverrides{
+		DrawSpeed_MM_S:       *drawSpeedFlag,
+		OptimizeTime_Seconds: *optimizeTimeFlag,
+		OptimizeStrategy:     *strategyFlag,
+		OrderStrategy:        *orderFlag,
+		NoCache:              *noCacheFlag,
+	})
+	if err != nil {
+		fmt.Println("ERROR: ", err)
+		os.Exit(1)
+	}
+
 	args := flag.Args()
 	if len(args) < 1 {
 		PrintGenericHelp()
 		return
 	}
 
-	plotCoords := make(chan p.Coordinate, 1024)
-	var err error
 	var params []float64
 
 	switch args[0] {
@@ -57,66 +82,187 @@ func main() {
 				return
 			}
 
-			p.MoveSpool(leftSpool, params[0])
+			err = p.MoveSpool(leftSpool, params[0])
 		} else {
-			p.InteractiveMoveSpool()
+			err = p.InteractiveMoveSpool()
+		}
+		if err != nil {
+			fmt.Println("ERROR: ", err)
+			os.Exit(1)
 		}
 		return
 
-	case "svg":
-		if len(args) < 2 {
-			fmt.Println("ERROR: ", fmt.Sprint("Expected at least 2 parameters and saw ", len(args)-1))
-			fmt.Println()
-			PrintCommandHelp("svg")
-			return
+	case "calibrate":
+		if err := p.InteractiveCalibrate(); err != nil {
+			fmt.Println("ERROR: ", err)
+			os.Exit(1)
 		}
+		return
 
-		optimize := false
-		if len(args) > 2 {
-			lastFlag := args[2]
-			if lastFlag == "optimize" {
-				optimize = true
-			}
+	case "home":
+		switch err := p.Home(interruptContext()); err {
+		case nil:
+		case context.Canceled:
+			fmt.Println("Stopped before homing finished")
+		default:
+			fmt.Println("ERROR: ", err)
+			os.Exit(1)
 		}
+		return
 
-		fmt.Println("Generating svg path")
-		input, width, height := p.ParseSvgFile(args[1])
-		var data []p.Coordinate
-		if optimize {
-			data = p.OptimizeTravel(input)
-		} else {
-			data = input
+	case "jog":
+		if err := p.InteractiveJog(); err != nil {
+			fmt.Println("ERROR: ", err)
+			os.Exit(1)
 		}
+		return
 
-		go p.GenerateSvgPath(data, plotCoords)
-
-		if *toImageFlag {
-			svgFileName := strings.Replace(args[1], ".svg", ".png", -1)
-			fmt.Println("Outputting to image ", svgFileName)
-			p.DrawToImageExact(svgFileName, width, height, plotCoords)
-			return
+	case "analyze":
+		if err := Analyze(args[1:], *jsonFlag); err != nil {
+			fmt.Println("ERROR: ", err)
+			fmt.Println()
+			PrintCommandHelp("analyze")
+			os.Exit(1)
 		}
+		return
+	}
 
-	default:
+	// every other command is a source of segments, followed by any transforms named after its parameters
+	sourceFactory, ok := p.LookupSource(args[0])
+	if !ok {
 		PrintGenericHelp()
 		return
 	}
 
-	// output the max speed and acceleration
-	fmt.Println()
-	fmt.Printf("MaxSpeed: %.3f mm/s Accel: %.3f mm/s^2", p.Settings.MaxSpeed_MM_S, p.Settings.Acceleration_MM_S2)
-	fmt.Println()
+	var sourceArgs []string
+	var transformFactories []p.TransformFactory
+	for _, arg := range args[1:] {
+		if transformFactory, isTransform := p.LookupTransform(arg); isTransform {
+			transformFactories = append(transformFactories, transformFactory)
+		} else {
+			sourceArgs = append(sourceArgs, arg)
+		}
+	}
+
+	var transforms []p.Transform
+	for _, transformFactory := range transformFactories {
+		transform, err := transformFactory(sourceArgs)
+		if err != nil {
+			fmt.Println("ERROR: ", err)
+			return
+		}
+		transforms = append(transforms, transform)
+	}
 
-	stepData := make(chan int8, 1024)
-	go p.GenerateSteps(plotCoords, stepData)
+	source, err := sourceFactory(sourceArgs)
+	if err != nil {
+		fmt.Println("ERROR: ", err)
+		fmt.Println()
+		PrintCommandHelp(args[0])
+		return
+	}
+
+	sinkName, sinkArgs := "serial", sourceArgs
 	switch {
+	case *exportFlag != "":
+		sinkName, sinkArgs = "export", []string{*exportFlag}
+	case *toImageFlag:
+		sinkName = "image"
 	case *countFlag:
-		p.CountSteps(stepData)
+		sinkName = "count"
 	case *toChartFlag:
-		p.WriteStepsToChart(stepData)
+		sinkName = "chart"
+	}
+	sinkFactory, _ := p.LookupSink(sinkName)
+	sink, err := sinkFactory(sinkArgs)
+	if err != nil {
+		fmt.Println("ERROR: ", err)
+		return
+	}
+
+	if !*toImageFlag && *exportFlag == "" {
+		// output the max speed and acceleration
+		fmt.Println()
+		fmt.Printf("MaxSpeed: %.3f mm/s Accel: %.3f mm/s^2", p.Settings.MaxSpeed_MM_S, p.Settings.Acceleration_MM_S2)
+		fmt.Println()
+		fmt.Printf("DrawSpeed: %.3f mm/s DrawAccel: %.3f mm/s^2", p.Settings.DrawMaxSpeed_MM_S, p.Settings.DrawAcceleration_MM_S2)
+		fmt.Println()
+	}
+
+	// stop cleanly on ctrl-c, the pen is lifted and returned to the starting position
+	switch err := p.NewPipeline(source, sink, transforms...).Run(interruptContext()); err {
+	case nil:
+	case context.Canceled:
+		fmt.Println("Stopped before finishing")
 	default:
-		p.WriteStepsToSerial(stepData)
+		fmt.Println("ERROR: ", err)
+		os.Exit(1)
+	}
+}
+
+// A context that is cancelled by ctrl-c, a second ctrl-c exits right away in case stopping hangs
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		signal.Stop(interrupt)
+		fmt.Println("Interrupted, stopping, press ctrl-c again to exit right away")
+		cancel()
+	}()
+	return ctx
+}
+
+// Report statistics of an svg file as read and after each transform named in args, optimize if none are named
+func Analyze(args []string, asJSON bool) error {
+	var sourceArgs []string
+	var transforms []p.NamedTransform
+	var transformNames []string
+	for _, arg := range args {
+		if _, isTransform := p.LookupTransform(arg); isTransform {
+			transformNames = append(transformNames, arg)
+		} else {
+			sourceArgs = append(sourceArgs, arg)
+		}
+	}
+	if len(transformNames) == 0 {
+		transformNames = []string{"optimize"}
+	}
+
+	for _, name := range transformNames {
+		transformFactory, _ := p.LookupTransform(name)
+		transform, err := transformFactory(sourceArgs)
+		if err != nil {
+			return err
+		}
+		transforms = append(transforms, p.NamedTransform{Name: name, Transform: transform})
+	}
+
+	sourceFactory, _ := p.LookupSource("svg")
+	source, err := sourceFactory(sourceArgs)
+	if err != nil {
+		return err
+	}
+
+	// progress from the stages goes to stderr so only the JSON is on stdout
+	if asJSON {
+		p.Log = os.Stderr
+	}
+
+	stages, err := p.AnalyzeStages(context.Background(), source, transforms)
+	if err != nil {
+		return err
 	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stages)
+	}
+	fmt.Println()
+	p.WriteStatsTable(os.Stdout, stages)
+	return nil
 }
 
 // Parse a series of numbers as floats
@@ -165,7 +311,20 @@ All angles are in radians
 Flags:
 -toimage, outputs data to an image of what the render should look like
 -tochart, outputs a graph of velocity and position
--count, outputs number of steps and render time
+-count, outputs number of steps and render time, split into drawing and travel
+-drawspeed=N, max speed in mm/s while the pen is down
+-optimizetime=N, seconds spent improving the pen up travel after the greedy ordering of optimize, optimize results are not cached when this is set
+-strategy=greedy|euler, euler draws lines that share points as the fewest pen down trails when optimizing
+-order=nearest|hilbert, hilbert finishes each RegionSize_MM square of the canvas before moving on when optimizing
+-export=FILE, save the drawing after its transforms to FILE instead of plotting it, as svg polylines in mm if FILE ends in .svg,
+	otherwise as a plot file (JSON if FILE ends in .json) that the plot command draws without parsing or optimizing again
+-json, output the analyze report as JSON, progress messages go to stderr
+-nocache, ignore the cache of parsed and optimized svg paths in CacheDir
+
+Drawing commands (svg, mouse, plot) can be followed by transforms, which are applied in order before output.
+Ctrl-C stops a drawing as soon as the pen can slow down, then lifts the pen and returns it to the starting position.
+
+Transforms: ` + strings.Join(p.TransformNames(), ", ") + `
 
 Commands:`)
 
@@ -190,13 +349,42 @@ Commands:`)
 }
 
 var CommandHelp = map[string]string{
+	`analyze`: `Report the segments, glyphs, pen lifts, pen down and pen up lengths, bounding box and estimated plot time of an svg file, as read and after each transform. With the -json flag the report is written as JSON so strategies can be compared by scripts.
+
+analyze "path" [transforms...]
+	path - path to svg file
+	transforms - transforms to apply in order, reporting the effect of each, defaults to optimize`,
+
+	`calibrate`: `Guided calibration of the starting position, spool circumference and spool distance. First move the pen to a marked reference point and enter the measured distances from each spool to the pen. Then a test square with a grid is drawn, enter the measured edge and diagonal lengths and the corrected circumference and spool distance are solved for and saved to the settings file.
+
+calibrate`,
+
+	`home`: `Retract both spools until their limit switches trigger, then save HomeLeftDist_MM and HomeRightDist_MM from the settings file as the starting position. Requires limit switches to be connected and ENABLE_HOMING in the arduino code. Homing gives up if the switches haven't triggered after HOMING_MAX_STEPS steps, or after 3 minutes, and ctrl-c stops it.
+
+home`,
+
+	`jog`: `Interactively move the pen with the keyboard. Arrow keys move the pen in X,Y by the selected step size, the number keys select the step size, space raises or lowers the pen. The current position can be made the new origin, and positions can be saved to and recalled from slots. On exit the pen position is saved as the starting position.
+
+jog`,
+
+	`mouse`: `Directly control the pen with a mouse, reads relative movement events from a linux evdev device. The left button raises / lowers the pen, the right or middle button stops.
+
+mouse [scale] [path]
+	scale - mm the pen moves per count of mouse movement, defaults to 0.1
+	path - evdev device or file of recorded events to read, defaults to MousePath from the settings file`,
+
+	`plot`: `Draw a plot file saved with the -export flag, already placed and optimized so it starts right away. Transforms can still follow it.
+
+plot "path"
+	path - path to the plot file`,
+
 	`spool`: `Directly control spool movement, useful for initial setup. If you ommit the L/R d parameters then you enter an interactive mode where you can repeatedly type the options to enter several spool commands in a row.
 
 spool [L|R] d
 	L|R - designing either the left or right spool
 	d - distance to extend line, negative numbers retract`,
 
-	`svg`: `Draw an svg file. Must be made up of only straight lines, curves are not currently supported in the svg parser.
+	`svg`: `Draw an svg file made of straight lines, cubic bezier curves and circles. Curves and circles are drawn as smooth curves rather than being broken into straight lines.
 
 svg "path" [optimize]
 	path - path to svg file
@@ -0,0 +1,174 @@
+package polargraph
+
+// Statistics about a drawing after each stage of processing, used to compare optimizing strategies
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Statistics of a path, with the time it would take to plot estimated from the steps generated for it
+type PathStats struct {
+	Stage    string `json:"stage"`
+	Segments int    `json:"segments"`
+
+	// Glyphs that draw something, and the times the pen is lifted between them
+	Glyphs   int `json:"glyphs"`
+	PenLifts int `json:"pen_lifts"`
+
+	PenDown_MM float64 `json:"pen_down_mm"`
+	PenUp_MM   float64 `json:"pen_up_mm"`
+
+	// Box around everything drawn with the pen down
+	MinX_MM float64 `json:"min_x_mm"`
+	MinY_MM float64 `json:"min_y_mm"`
+	MaxX_MM float64 `json:"max_x_mm"`
+	MaxY_MM float64 `json:"max_y_mm"`
+
+	PlotTime_Seconds   float64 `json:"plot_time_seconds"`
+	DrawTime_Seconds   float64 `json:"draw_time_seconds"`
+	TravelTime_Seconds float64 `json:"travel_time_seconds"`
+
+	// The path after each phase inside the stage, measured without a plot time, for stages that report them like optimize
+	Phases []PathStats `json:"phases,omitempty"`
+}
+
+// A transform with the name it is reported under
+type NamedTransform struct {
+	Name      string
+	Transform Transform
+}
+
+// Measure the path, without the plot time which needs steps to be generated
+// Curves are measured along the curve, and are only flattened to find the box around them
+func MeasurePath(stage string, path Path) (stats PathStats) {
+	stats.Stage = stage
+	stats.Segments = len(path)
+
+	stats.MinX_MM, stats.MinY_MM = math.Inf(1), math.Inf(1)
+	stats.MaxX_MM, stats.MaxY_MM = math.Inf(-1), math.Inf(-1)
+	drawing := false
+	for index := 1; index < len(path); index++ {
+		from, segment := path[index-1].End, path[index]
+		length := newSegmentPath(from, segment).Length()
+		if segment.End.PenUp {
+			stats.PenUp_MM += length
+			if drawing {
+				stats.PenLifts++
+			}
+			drawing = false
+			continue
+		}
+
+		stats.PenDown_MM += length
+		if !drawing {
+			stats.Glyphs++
+			drawing = true
+		}
+		for _, coord := range append([]Coordinate{from}, segment.Flatten(from, Settings.StepSize_MM)...) {
+			stats.MinX_MM, stats.MinY_MM = math.Min(stats.MinX_MM, coord.X), math.Min(stats.MinY_MM, coord.Y)
+			stats.MaxX_MM, stats.MaxY_MM = math.Max(stats.MaxX_MM, coord.X), math.Max(stats.MaxY_MM, coord.Y)
+		}
+	}
+	if stats.Glyphs == 0 {
+		stats.MinX_MM, stats.MinY_MM, stats.MaxX_MM, stats.MaxY_MM = 0, 0, 0, 0
+	}
+	return
+}
+
+// Measure the path and estimate how long it would take to plot, by generating its steps and counting them like CountSteps
+func AnalyzePath(ctx context.Context, stage string, path Path) (PathStats, error) {
+	stats := MeasurePath(stage, path)
+
+	var count StepCount
+	sink := StepsSink(func(stepData <-chan int8) error {
+		count = TallySteps(stepData)
+		return nil
+	})
+	if err := NewPipeline(PathSource(path), sink).Run(ctx); err != nil {
+		return stats, err
+	}
+
+	stats.PlotTime_Seconds = count.Time().Seconds()
+	stats.DrawTime_Seconds = count.DrawTime().Seconds()
+	stats.TravelTime_Seconds = count.TravelTime().Seconds()
+	return stats, nil
+}
+
+// Key of the function OptimizeTransform passes the glyphs after each of its phases to
+type optimizePhasesKey struct{}
+
+// A context that has OptimizeTransform pass the glyphs after each of its phases to record
+func withOptimizePhases(ctx context.Context, record func([]OptimizePhase)) context.Context {
+	return context.WithValue(ctx, optimizePhasesKey{}, record)
+}
+
+// Measure the path drawn by the glyphs after each phase, named after the phase
+func measurePhases(phases []OptimizePhase) (stats []PathStats, err error) {
+	for _, phase := range phases {
+		path, err := MakePath(phase.Glyphs)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, MeasurePath(phase.Name, path))
+	}
+	return stats, nil
+}
+
+// Analyze the path from source, then again after each of the transforms in turn
+// Optimize stages include the path after each of their phases, unless the result was loaded from the cache
+func AnalyzeStages(ctx context.Context, source Source, transforms []NamedTransform) (stages []PathStats, err error) {
+	var path Path
+	collect := func(ctx context.Context, in <-chan Segment) (err error) {
+		path, err = CollectPath(ctx, in)
+		return
+	}
+
+	if err = NewPipeline(source, collect).Run(ctx); err != nil {
+		return nil, err
+	}
+	stats, err := AnalyzePath(ctx, "input", path)
+	if err != nil {
+		return nil, err
+	}
+	stages = append(stages, stats)
+
+	for _, transform := range transforms {
+		var phases []OptimizePhase
+		record := func(recorded []OptimizePhase) { phases = recorded }
+		if err = NewPipeline(PathSource(path), collect, transform.Transform).Run(withOptimizePhases(ctx, record)); err != nil {
+			return nil, err
+		}
+		if stats, err = AnalyzePath(ctx, transform.Name, path); err != nil {
+			return nil, err
+		}
+		if stats.Phases, err = measurePhases(phases); err != nil {
+			return nil, err
+		}
+		stages = append(stages, stats)
+	}
+	return stages, nil
+}
+
+// Write the stages as a table, with the change from the stage before, and the phases of a stage before it
+func WriteStatsTable(w io.Writer, stages []PathStats) {
+	fmt.Fprintf(w, "%-10s %9s %9s %9s %12s %12s %10s\n", "Stage", "Segments", "Glyphs", "Lifts", "PenDown mm", "PenUp mm", "Time s")
+	for index, stats := range stages {
+		for _, phase := range stats.Phases {
+			fmt.Fprintf(w, "%-10s %9d %9d %9d %12.1f %12.1f %10s\n", "  "+phase.Stage, phase.Segments, phase.Glyphs, phase.PenLifts, phase.PenDown_MM, phase.PenUp_MM, "")
+		}
+		fmt.Fprintf(w, "%-10s %9d %9d %9d %12.1f %12.1f %10.1f\n", stats.Stage, stats.Segments, stats.Glyphs, stats.PenLifts, stats.PenDown_MM, stats.PenUp_MM, stats.PlotTime_Seconds)
+		if index > 0 {
+			previous := stages[index-1]
+			fmt.Fprintf(w, "%-10s %+9d %+9d %+9d %+12.1f %+12.1f %+10.1f\n", "", stats.Segments-previous.Segments, stats.Glyphs-previous.Glyphs, stats.PenLifts-previous.PenLifts,
+				stats.PenDown_MM-previous.PenDown_MM, stats.PenUp_MM-previous.PenUp_MM, stats.PlotTime_Seconds-previous.PlotTime_Seconds)
+		}
+	}
+
+	if len(stages) > 0 {
+		last := stages[len(stages)-1]
+		fmt.Fprintf(w, "Bounds: %.1f, %.1f to %.1f, %.1f mm, drawing %.1f s, travel %.1f s\n", last.MinX_MM, last.MinY_MM, last.MaxX_MM, last.MaxY_MM, last.DrawTime_Seconds, last.TravelTime_Seconds)
+	}
+}
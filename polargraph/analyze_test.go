@@ -0,0 +1,122 @@
+package polargraph
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"strings"
+	"testing"
+)
+
+// A 10mm square and a separate 5mm line, starting and finishing at the origin
+func analyzePath() Path {
+	return Path{
+		LineTo(Coordinate{X: 10, Y: 10, PenUp: true}),
+		LineTo(Coordinate{X: 20, Y: 10}),
+		LineTo(Coordinate{X: 20, Y: 20}),
+		LineTo(Coordinate{X: 10, Y: 20}),
+		LineTo(Coordinate{X: 10, Y: 10}),
+		LineTo(Coordinate{X: 30, Y: 10, PenUp: true}),
+		LineTo(Coordinate{X: 30, Y: 15}),
+		LineTo(Coordinate{X: 0, Y: 0, PenUp: true}),
+	}
+}
+
+// Lengths, counts and bounds of a known path
+func TestMeasurePath(t *testing.T) {
+	defer func(settings SettingsData) { Settings = settings }(Settings)
+	Settings = goldenSettings("")
+
+	stats := MeasurePath("input", analyzePath())
+	if stats.Stage != "input" || stats.Segments != 8 || stats.Glyphs != 2 || stats.PenLifts != 2 {
+		t.Error("Expected 8 segments, 2 glyphs and 2 lifts and got", stats)
+	}
+	if math.Abs(stats.PenDown_MM-45) > 0.000001 {
+		t.Error("Expected 45mm drawn and got", stats.PenDown_MM)
+	}
+	// the first move starts from wherever the path starts, so only the moves between glyphs and back home count
+	if expected := 20 + math.Sqrt(30*30+15*15); math.Abs(stats.PenUp_MM-expected) > 0.000001 {
+		t.Error("Expected", expected, "mm of pen up travel and got", stats.PenUp_MM)
+	}
+	if stats.MinX_MM != 10 || stats.MinY_MM != 10 || stats.MaxX_MM != 30 || stats.MaxY_MM != 20 {
+		t.Error("Expected bounds 10, 10 to 30, 20 and got", stats.MinX_MM, stats.MinY_MM, stats.MaxX_MM, stats.MaxY_MM)
+	}
+}
+
+// Curves should be measured along the curve and boxed by the points on them
+func TestMeasurePathCurves(t *testing.T) {
+	defer func(settings SettingsData) { Settings = settings }(Settings)
+	Settings = goldenSettings("")
+
+	circle := Path{
+		LineTo(Coordinate{X: 10, Y: 10, PenUp: true}),
+		ArcAround(Coordinate{X: 10, Y: 10}, Coordinate{X: 5, Y: 10}, 2*math.Pi, false),
+		LineTo(Coordinate{X: 0, Y: 0, PenUp: true}),
+	}
+	stats := MeasurePath("input", circle)
+	if math.Abs(stats.PenDown_MM-10*math.Pi) > 0.000001 {
+		t.Error("Expected", 10*math.Pi, "mm drawn and got", stats.PenDown_MM)
+	}
+	if math.Abs(stats.MinX_MM) > 0.1 || math.Abs(stats.MinY_MM-5) > 0.1 || math.Abs(stats.MaxX_MM-10) > 0.1 || math.Abs(stats.MaxY_MM-15) > 0.1 {
+		t.Error("Expected bounds 0, 5 to 10, 15 and got", stats.MinX_MM, stats.MinY_MM, stats.MaxX_MM, stats.MaxY_MM)
+	}
+}
+
+// Slices are sent for both spools and pen commands are counted as transitions
+func TestTallySteps(t *testing.T) {
+	stepData := make(chan int8, 16)
+	for _, step := range []int8{1, 1, PenDownCommand, PenDownCommand, 1, -1, 2, 0, PenUpCommand, PenUpCommand, 0, 1} {
+		stepData <- step
+	}
+	close(stepData)
+
+	count := TallySteps(stepData)
+	if count.TravelSlices != 2 || count.DrawSlices != 2 || count.PenTransitions != 2 {
+		t.Error("Expected 2 travel slices, 2 draw slices and 2 transitions and got", count)
+	}
+	if count.Time() <= count.DrawTime()+count.TravelTime() {
+		t.Error("Expected the pen transitions to add to the time")
+	}
+}
+
+// Each transform should be reported as its own stage, measured after the stages before it
+func TestAnalyzeStages(t *testing.T) {
+	defer func(settings SettingsData) { Settings = settings }(Settings)
+	Settings = goldenSettings("")
+	Settings.MergeTolerance_MM = 0.1
+
+	stages, err := AnalyzeStages(context.Background(), PathSource(analyzePath()), []NamedTransform{
+		{Name: "optimize", Transform: OptimizeTransform()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stages) != 2 || stages[0].Stage != "input" || stages[1].Stage != "optimize" {
+		t.Fatal("Expected input and optimize stages and got", stages)
+	}
+	if stages[0].PlotTime_Seconds <= 0 || stages[0].DrawTime_Seconds <= 0 {
+		t.Error("Expected the plot time to be estimated and got", stages[0])
+	}
+	if math.Abs(stages[1].PenDown_MM-stages[0].PenDown_MM) > 0.001 {
+		t.Error("Expected optimizing to draw the same length and got", stages[0].PenDown_MM, stages[1].PenDown_MM)
+	}
+
+	// the glyphs are joined then reordered, and the reordered glyphs are what the stage sends on
+	phases := stages[1].Phases
+	if len(phases) != 2 || phases[0].Stage != "join" || phases[1].Stage != "reorder" {
+		t.Fatal("Expected join and reorder phases and got", phases)
+	}
+	// the stage also moves back to the origin at the end
+	if phases[1].PenDown_MM != stages[1].PenDown_MM || phases[1].PenLifts != stages[1].PenLifts || phases[1].PenUp_MM >= stages[1].PenUp_MM {
+		t.Error("Expected the last phase to draw what the stage does and got", phases[1], stages[1])
+	}
+	if len(stages[0].Phases) != 0 {
+		t.Error("Expected no phases for the input and got", stages[0].Phases)
+	}
+
+	var table bytes.Buffer
+	WriteStatsTable(&table, stages)
+	if lines := strings.Split(strings.TrimSpace(table.String()), "\n"); len(lines) != 7 {
+		t.Error("Expected a header, 2 stages, 2 phases, a change and the bounds and got", table.String())
+	}
+}
@@ -0,0 +1,217 @@
+package polargraph
+
+// Keeps processed paths on disk so that parsing and optimizing a large drawing only happens once
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Paths stored in a directory, named by a key made from everything that went into producing them
+type PathCache struct {
+	// Directory the paths are stored in, caching is disabled when blank
+	Dir string
+
+	// Largest total size of the stored paths, the least recently used are removed once it is exceeded, 0 doesn't limit it
+	MaxBytes int64
+}
+
+// Versions of what the cached steps produce, included in their keys so results stored by an older version are never loaded
+// Bump the version of a step whenever a change to its code changes what it produces for the same input
+const (
+	svgCacheVersion      = 2
+	optimizeCacheVersion = 2
+)
+
+// Cache in the directory from the settings
+func (settings *SettingsData) PathCache() PathCache {
+	return PathCache{Dir: settings.CacheDir, MaxBytes: int64(settings.CacheMaxSize_MB * 1024 * 1024)}
+}
+
+// Combine the parts into a key, the parts should include the name of the processing step and every option it used
+func CacheKey(parts ...interface{}) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(hash, "%v|", part)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Hash of the contents of a file
+func HashFile(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Hash of every segment in the path
+func HashPath(path Path) (string, error) {
+	hash := sha256.New()
+	if err := gob.NewEncoder(hash).Encode(path); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// File the path with the given key is stored in
+func (cache PathCache) fileName(key string) string {
+	return filepath.Join(cache.Dir, key+".gob")
+}
+
+// Load the path stored with key, a missing or unreadable entry is a miss
+func (cache PathCache) Load(key string) (Path, bool) {
+	if cache.Dir == "" {
+		return nil, false
+	}
+
+	file, err := os.Open(cache.fileName(key))
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	var path Path
+	if err := gob.NewDecoder(file).Decode(&path); err != nil {
+		fmt.Fprintln(Log, "WARNING: ignoring unreadable cache entry", cache.fileName(key), err)
+		return nil, false
+	}
+
+	// the modification time records when an entry was last used, so the least recently used are removed first
+	now := time.Now()
+	os.Chtimes(cache.fileName(key), now, now)
+	return path, true
+}
+
+// Store the path with key, written to a temporary file first so a partly written entry is never loaded
+func (cache PathCache) Store(key string, path Path) error {
+	if cache.Dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(cache.Dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(cache.Dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := gob.NewEncoder(file).Encode(path); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), cache.fileName(key)); err != nil {
+		return err
+	}
+	return cache.evict()
+}
+
+// Remove the least recently used paths until the ones left fit within MaxBytes
+func (cache PathCache) evict() error {
+	if cache.MaxBytes <= 0 {
+		return nil
+	}
+
+	infos, err := ioutil.ReadDir(cache.Dir)
+	if err != nil {
+		return err
+	}
+
+	var entries []os.FileInfo
+	var total int64
+	for _, info := range infos {
+		if info.Mode().IsRegular() && strings.HasSuffix(info.Name(), ".gob") {
+			entries = append(entries, info)
+			total += info.Size()
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ModTime().Before(entries[j].ModTime()) })
+
+	for _, entry := range entries {
+		if total <= cache.MaxBytes {
+			break
+		}
+		if err := os.Remove(filepath.Join(cache.Dir, entry.Name())); err != nil {
+			return err
+		}
+		total -= entry.Size()
+	}
+	return nil
+}
+
+// Store the path, only warning on failure since the cache is just a speed up
+func (cache PathCache) storeOrWarn(key string, path Path) {
+	if err := cache.Store(key, path); err != nil {
+		fmt.Fprintln(Log, "WARNING: unable to cache path:", err)
+	}
+}
+
+// Wrap a transform so that its output is cached, keyed by its input, name and options
+// The transform has to produce the same output whenever it gets the same input and options
+func CachedTransform(cache PathCache, name string, transform Transform, options ...interface{}) Transform {
+	return func(ctx context.Context, in <-chan Segment, out chan<- Segment) error {
+		input, err := CollectPath(ctx, in)
+		if err != nil {
+			return err
+		}
+
+		inputHash, err := HashPath(input)
+		if err != nil {
+			return err
+		}
+		key := CacheKey(append([]interface{}{name, inputHash}, options...)...)
+		if output, ok := cache.Load(key); ok {
+			fmt.Fprintln(Log, "Using cached", name, "result")
+			return SendPath(ctx, out, output)
+		}
+
+		inputSegments := make(chan Segment, len(input))
+		for _, segment := range input {
+			inputSegments <- segment
+		}
+		close(inputSegments)
+
+		outputSegments := make(chan Segment, pipelineBufferSize)
+		collected := make(chan error, 1)
+		var output Path
+		go func() {
+			var collectErr error
+			output, collectErr = CollectPath(ctx, outputSegments)
+			collected <- collectErr
+		}()
+
+		err = runStage(func() error { return transform(ctx, inputSegments, outputSegments) })
+		close(outputSegments)
+		if collectErr := <-collected; err == nil {
+			err = collectErr
+		}
+		if err != nil {
+			return err
+		}
+
+		cache.storeOrWarn(key, output)
+		return SendPath(ctx, out, output)
+	}
+}
@@ -0,0 +1,128 @@
+package polargraph
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// Stored paths should load back exactly, and nothing should be cached when the cache is disabled
+func TestPathCacheRoundTrip(t *testing.T) {
+	cache := PathCache{Dir: t.TempDir()}
+	path := Path{
+		LineTo(Coordinate{X: 1, Y: 2, PenUp: true}),
+		ArcAround(Coordinate{X: 1, Y: 2}, Coordinate{X: 5, Y: 2}, 1.5, false),
+		CubicTo(Coordinate{X: 3, Y: 9}, Coordinate{X: 8, Y: -4}, Coordinate{X: 10, Y: 3}),
+	}
+
+	hash, err := HashPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := CacheKey("test", hash)
+	if _, ok := cache.Load(key); ok {
+		t.Error("Expected a miss before storing")
+	}
+	if err := cache.Store(key, path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, ok := cache.Load(key)
+	if !ok || len(loaded) != len(path) {
+		t.Fatal("Expected to load", path, "and got", loaded)
+	}
+	for index := range path {
+		if loaded[index] != path[index] {
+			t.Error("Segment", index, "expected", path[index], "and got", loaded[index])
+		}
+	}
+
+	disabled := PathCache{}
+	if err := disabled.Store(key, path); err != nil {
+		t.Error("Unexpected error storing to a disabled cache", err)
+	}
+	if _, ok := disabled.Load(key); ok {
+		t.Error("Expected a disabled cache to always miss")
+	}
+}
+
+// A cached transform should only run once for the same input and options
+func TestCachedTransform(t *testing.T) {
+	cache := PathCache{Dir: t.TempDir()}
+	input := Path{
+		LineTo(Coordinate{X: 0, Y: 0, PenUp: true}),
+		LineTo(Coordinate{X: 10, Y: 0}),
+	}
+
+	runs := 0
+	reverse := func(ctx context.Context, in <-chan Segment, out chan<- Segment) error {
+		runs++
+		path, err := CollectPath(ctx, in)
+		if err != nil {
+			return err
+		}
+		for index := len(path) - 1; index >= 0; index-- {
+			if err := SendSegment(ctx, out, path[index]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	run := func(option float64) Path {
+		var output Path
+		if err := runPipeline(t, context.Background(), NewPipeline(PathSource(input), collectSink(&output), CachedTransform(cache, "reverse", reverse, option))); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		return output
+	}
+
+	first, second := run(1), run(1)
+	if runs != 1 {
+		t.Error("Expected the transform to run once and it ran", runs, "times")
+	}
+	if len(second) != 2 || second[0] != input[1] || second[1] != input[0] {
+		t.Error("Expected the cached output", first, "and got", second)
+	}
+
+	run(2)
+	if runs != 2 {
+		t.Error("Expected different options to run the transform again")
+	}
+}
+
+// Once the cache is bigger than MaxBytes the least recently used paths should be removed
+func TestPathCacheEviction(t *testing.T) {
+	cache := PathCache{Dir: t.TempDir()}
+	path := Path{LineTo(Coordinate{X: 0, Y: 0, PenUp: true}), LineTo(Coordinate{X: 10, Y: 5})}
+
+	if err := cache.Store("a", path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(cache.fileName("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.MaxBytes = 2*info.Size() + info.Size()/2
+
+	if err := cache.Store("b", path); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	os.Chtimes(cache.fileName("a"), now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+	os.Chtimes(cache.fileName("b"), now.Add(-time.Hour), now.Add(-time.Hour))
+
+	// using a makes b the least recently used
+	if _, ok := cache.Load("a"); !ok {
+		t.Fatal("Expected a to be cached")
+	}
+	if err := cache.Store("c", path); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := cache.Load(key); ok != expected {
+			t.Error("Expected", key, "to be cached", expected, "and was", ok)
+		}
+	}
+}
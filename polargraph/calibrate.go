@@ -0,0 +1,256 @@
+package polargraph
+
+// Guided calibration of the spool geometry, solves for spool circumference and separation from measurements of a drawn test pattern
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// A distance measured between two points of the drawn test pattern
+type CalibrationMeasurement struct {
+	// Points in the test pattern, relative to the starting position
+	From, To Coordinate
+
+	// Distance measured on the paper between the two drawn points
+	Distance_MM float64
+}
+
+// Generates a square of the given size split into a grid, starting and ending at 0,0 which is the top left corner
+func CalibrationPattern(size float64, divisions int) (data []Coordinate) {
+	data = append(data, Coordinate{X: 0, Y: 0, PenUp: true})
+
+	// outline of the square
+	data = append(data,
+		Coordinate{X: size, Y: 0},
+		Coordinate{X: size, Y: size},
+		Coordinate{X: 0, Y: size},
+		Coordinate{X: 0, Y: 0})
+
+	// inner grid lines
+	for line := 1; line < divisions; line++ {
+		offset := size * float64(line) / float64(divisions)
+		data = append(data,
+			Coordinate{X: offset, Y: 0, PenUp: true},
+			Coordinate{X: offset, Y: size},
+			Coordinate{X: 0, Y: offset, PenUp: true},
+			Coordinate{X: size, Y: offset})
+	}
+
+	// diagonals
+	data = append(data,
+		Coordinate{X: 0, Y: 0, PenUp: true},
+		Coordinate{X: size, Y: size},
+		Coordinate{X: size, Y: 0, PenUp: true},
+		Coordinate{X: 0, Y: size},
+		Coordinate{X: 0, Y: 0, PenUp: true})
+
+	return
+}
+
+// Turns of the spool, from where it would be with no cord let out, to let out cordDist
+func spoolTurns(spool WoundSpool, cordDist float64) float64 {
+	return spool.SpoolDist(cordDist) / spool.Circumference_MM
+}
+
+// The spool that lets out scale times as much cord per turn, by scaling the wound cord geometry when the model is used
+// and the circumference otherwise, which the wound cord model doesn't depend on
+func (spool WoundSpool) scaled(scale float64) WoundSpool {
+	if spool.Enabled() {
+		spool.CoreDiameter_MM *= scale
+		spool.CordThickness_MM *= scale
+	} else {
+		spool.Circumference_MM *= scale
+	}
+	return spool
+}
+
+// Cord actually let out when spool, which had startDist let out, is turned to where nominal would let out cordDist
+func actualCordDist(nominal, spool WoundSpool, startDist, cordDist float64) float64 {
+	target := spoolTurns(spool, startDist) + spoolTurns(nominal, cordDist) - spoolTurns(nominal, startDist)
+
+	// Newton's method, the turns only change gradually as the layers of cord change
+	const delta = 0.01
+	actual := cordDist
+	for iteration := 0; iteration < 20; iteration++ {
+		rate := (spoolTurns(spool, actual+delta) - spoolTurns(spool, actual-delta)) / (2 * delta)
+		step := (spoolTurns(spool, actual) - target) / rate
+		actual -= step
+		if math.Abs(step) < 1e-9 {
+			break
+		}
+	}
+	return actual
+}
+
+// Distance between two pattern points that would be drawn if the spools let out scale times the cord per turn and were spoolDistance apart
+// system is the PolarSystem the pattern was drawn with, offset so 0,0 is the starting position, and spool the model it was drawn with
+func calibratedDistance(measurement CalibrationMeasurement, system PolarSystem, spool WoundSpool, start PolarCoordinate, scale, spoolDistance float64) float64 {
+	actualSystem := PolarSystem{RightMotorDist: spoolDistance}
+	actualSpool := spool.scaled(scale)
+
+	drawnPosition := func(coord Coordinate) Coordinate {
+		// cord actually let out differs from what was commanded by the error in the spool
+		commanded := coord.ToPolar(system)
+		drawn := PolarCoordinate{
+			LeftDist:  actualCordDist(spool, actualSpool, start.LeftDist, commanded.LeftDist),
+			RightDist: actualCordDist(spool, actualSpool, start.RightDist, commanded.RightDist),
+		}
+		return drawn.ToCoord(actualSystem)
+	}
+
+	return drawnPosition(measurement.From).DistanceTo(drawnPosition(measurement.To))
+}
+
+// Solve for the spool scale factor and the spool separation that best explain the measurements, using Gauss-Newton least squares
+// The spools let out scale times the cord per turn that spool, the model the pattern was drawn with, expects, which ApplyCalibration corrects
+func SolveCalibration(system PolarSystem, spool WoundSpool, start PolarCoordinate, measurements []CalibrationMeasurement) (scale, spoolDistance float64, err error) {
+	if len(measurements) < 2 {
+		return 0, 0, errors.New("At least 2 measurements are needed to solve for 2 unknowns")
+	}
+
+	scale, spoolDistance = 1, system.RightMotorDist
+	const scaleStep, distanceStep = 0.000001, 0.001
+
+	for iteration := 0; iteration < 50; iteration++ {
+
+		// accumulate the normal equations JtJ * delta = Jt * residual
+		var a11, a12, a22, b1, b2 float64
+		for _, measurement := range measurements {
+			predicted := calibratedDistance(measurement, system, spool, start, scale, spoolDistance)
+			residual := predicted - measurement.Distance_MM
+
+			dScale := (calibratedDistance(measurement, system, spool, start, scale+scaleStep, spoolDistance) - predicted) / scaleStep
+			dDistance := (calibratedDistance(measurement, system, spool, start, scale, spoolDistance+distanceStep) - predicted) / distanceStep
+
+			a11 += dScale * dScale
+			a12 += dScale * dDistance
+			a22 += dDistance * dDistance
+			b1 += dScale * residual
+			b2 += dDistance * residual
+		}
+
+		determinant := a11*a22 - a12*a12
+		if determinant == 0 || math.IsNaN(determinant) {
+			return 0, 0, errors.New("Measurements do not constrain both the circumference and the spool distance")
+		}
+
+		deltaScale := (a22*b1 - a12*b2) / determinant
+		deltaDistance := (a11*b2 - a12*b1) / determinant
+
+		scale -= deltaScale
+		spoolDistance -= deltaDistance
+
+		if math.IsNaN(scale) || math.IsNaN(spoolDistance) {
+			return 0, 0, errors.New("Calibration did not converge, check the measurements")
+		}
+		if math.Abs(deltaScale) < 1e-9 && math.Abs(deltaDistance) < 1e-6 {
+			break
+		}
+	}
+
+	return
+}
+
+// Read a single number typed by the user, returns false if nothing valid was entered
+func promptFloat(prompt string) (float64, bool) {
+	var value float64
+	fmt.Print(prompt)
+	if _, err := fmt.Scanln(&value); err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// Correct the settings for spools that let out scale times the cord per turn they were expected to, and are spoolDistance apart
+// With the wound cord model the circumference doesn't change the cord per turn, so the spool core and cord thickness are scaled instead
+func (settings *SettingsData) ApplyCalibration(scale, spoolDistance float64) {
+	if settings.WoundSpool().Enabled() {
+		fmt.Printf("SpoolCoreDiameter_MM %.4f -> %.4f\n", settings.SpoolCoreDiameter_MM, settings.SpoolCoreDiameter_MM*scale)
+		fmt.Printf("CordThickness_MM %.4f -> %.4f\n", settings.CordThickness_MM, settings.CordThickness_MM*scale)
+		settings.SpoolCoreDiameter_MM *= scale
+		settings.CordThickness_MM *= scale
+	} else {
+		fmt.Printf("SpoolCircumference_MM %.4f -> %.4f\n", settings.SpoolCircumference_MM, settings.SpoolCircumference_MM*scale)
+		settings.SpoolCircumference_MM *= scale
+	}
+	fmt.Printf("SpoolHorizontalDistance_MM %.2f -> %.2f\n", settings.SpoolHorizontalDistance_MM, spoolDistance)
+	settings.SpoolHorizontalDistance_MM = spoolDistance
+	settings.CalculateDerivedFields()
+}
+
+// Walks the user through measuring the starting position and drawing a test pattern, then saves the corrected settings
+func InteractiveCalibrate() error {
+
+	fmt.Println("Step 1: move the pen to the reference mark, enter a blank line when it is in place")
+	if err := InteractiveMoveSpool(); err != nil {
+		return err
+	}
+
+	if leftDist, ok := promptFloat(fmt.Sprintf("Measured distance from left spool to pen (%.2f): ", Settings.StartingLeftDist_MM)); ok {
+		Settings.StartingLeftDist_MM = leftDist
+	}
+	if rightDist, ok := promptFloat(fmt.Sprintf("Measured distance from right spool to pen (%.2f): ", Settings.StartingRightDist_MM)); ok {
+		Settings.StartingRightDist_MM = rightDist
+	}
+	if err := Settings.Write(); err != nil {
+		return err
+	}
+
+	size := 200.0
+	if value, ok := promptFloat(fmt.Sprintf("Step 2: size of the test square to draw (%.0f): ", size)); ok {
+		size = value
+	}
+
+	start := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}
+	system := PolarSystemFromSettings()
+	startingLocation := start.ToCoord(system)
+	system.XOffset = startingLocation.X
+	system.YOffset = startingLocation.Y
+
+	var pattern Path
+	for _, coord := range CalibrationPattern(size, 4) {
+		pattern = append(pattern, LineTo(coord))
+	}
+	if err := NewPipeline(PathSource(pattern), StepsSink(WriteStepsToSerial)).Run(context.Background()); err != nil {
+		return err
+	}
+
+	topLeft := Coordinate{X: 0, Y: 0}
+	topRight := Coordinate{X: size, Y: 0}
+	bottomRight := Coordinate{X: size, Y: size}
+	bottomLeft := Coordinate{X: 0, Y: size}
+	edges := []struct {
+		name     string
+		from, to Coordinate
+	}{
+		{"top edge", topLeft, topRight},
+		{"bottom edge", bottomLeft, bottomRight},
+		{"left edge", topLeft, bottomLeft},
+		{"right edge", topRight, bottomRight},
+		{"diagonal from top left", topLeft, bottomRight},
+		{"diagonal from top right", topRight, bottomLeft},
+	}
+
+	var measurements []CalibrationMeasurement
+	for _, edge := range edges {
+		if distance, ok := promptFloat(fmt.Sprintf("Measured length of %s (blank to skip): ", edge.name)); ok {
+			measurements = append(measurements, CalibrationMeasurement{From: edge.from, To: edge.to, Distance_MM: distance})
+		}
+	}
+
+	scale, spoolDistance, err := SolveCalibration(system, Settings.WoundSpool(), start, measurements)
+	if err != nil {
+		return err
+	}
+
+	Settings.ApplyCalibration(scale, spoolDistance)
+	if err := Settings.Write(); err != nil {
+		return err
+	}
+
+	fmt.Println("Saved calibration to", settingsFile)
+	return nil
+}
@@ -0,0 +1,141 @@
+package polargraph
+
+import (
+	"math"
+	"testing"
+)
+
+// Polar system of the test machine, offset so 0,0 is where the pen starts
+func calibrationSystem(start PolarCoordinate) PolarSystem {
+	system := PolarSystem{
+		XMin:           0,
+		XMax:           1000,
+		YMin:           0,
+		YMax:           2000,
+		RightMotorDist: 1000,
+	}
+	startingLocation := start.ToCoord(system)
+	system.XOffset = startingLocation.X
+	system.YOffset = startingLocation.Y
+	return system
+}
+
+// Solving measurements generated from known errors should recover those errors
+func TestSolveCalibration(t *testing.T) {
+	start := PolarCoordinate{LeftDist: 600, RightDist: 650}
+	system := calibrationSystem(start)
+	spool := WoundSpool{Circumference_MM: 100}
+
+	trueScale, trueDistance := 1.015, 1012.0
+	size := 200.0
+	corners := []Coordinate{{X: 0, Y: 0}, {X: size, Y: 0}, {X: size, Y: size}, {X: 0, Y: size}}
+
+	var measurements []CalibrationMeasurement
+	for from := 0; from < len(corners); from++ {
+		for to := from + 1; to < len(corners); to++ {
+			measurement := CalibrationMeasurement{From: corners[from], To: corners[to]}
+			measurement.Distance_MM = calibratedDistance(measurement, system, spool, start, trueScale, trueDistance)
+			measurements = append(measurements, measurement)
+		}
+	}
+
+	scale, spoolDistance, err := SolveCalibration(system, spool, start, measurements)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if math.Abs(scale-trueScale) > 0.0001 {
+		t.Error("Expected scale", trueScale, "and got", scale)
+	}
+	if math.Abs(spoolDistance-trueDistance) > 0.1 {
+		t.Error("Expected spool distance", trueDistance, "and got", spoolDistance)
+	}
+}
+
+// Where the pen is drawn for a pattern point, worked out from the triangles of the cords without the code being tested
+// The pen started at the start cord lengths, the spools are actualDistance apart instead of nominalDistance,
+// and let out scale times the change in cord they were commanded to
+func drawnPatternPoint(point Coordinate, start PolarCoordinate, nominalDistance, actualDistance, scale float64) Coordinate {
+	position := func(left, right, distance float64) Coordinate {
+		x := (left*left - right*right + distance*distance) / (2 * distance)
+		return Coordinate{X: x, Y: math.Sqrt(left*left - x*x)}
+	}
+
+	commanded := position(start.LeftDist, start.RightDist, nominalDistance).Add(point)
+	left := start.LeftDist + (commanded.Len()-start.LeftDist)*scale
+	right := start.RightDist + (commanded.Minus(Coordinate{X: nominalDistance}).Len()-start.RightDist)*scale
+	return position(left, right, actualDistance)
+}
+
+// Measurements of a pattern drawn by a machine with known errors should give back those errors, with or without the wound cord model
+func TestSolveCalibrationGeometry(t *testing.T) {
+	start := PolarCoordinate{LeftDist: 700, RightDist: 600}
+	system := calibrationSystem(start)
+	trueScale, trueDistance := 0.985, 1020.0
+
+	size := 250.0
+	points := []Coordinate{{X: 0, Y: 0}, {X: size, Y: 0}, {X: size, Y: size}, {X: 0, Y: size}}
+	var measurements []CalibrationMeasurement
+	for from := 0; from < len(points); from++ {
+		for to := from + 1; to < len(points); to++ {
+			distance := drawnPatternPoint(points[from], start, 1000, trueDistance, trueScale).DistanceTo(drawnPatternPoint(points[to], start, 1000, trueDistance, trueScale))
+			measurements = append(measurements, CalibrationMeasurement{From: points[from], To: points[to], Distance_MM: distance})
+		}
+	}
+
+	// a single layer of cord lets out the same length every turn, like a bare spool
+	for _, spool := range []WoundSpool{
+		{Circumference_MM: 100},
+		{Circumference_MM: 100, CordThickness_MM: 0.5, CoreDiameter_MM: 30, WrapsPerLayer: 1000000, CordLength_MM: 5000},
+	} {
+		scale, spoolDistance, err := SolveCalibration(system, spool, start, measurements)
+		if err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		if math.Abs(scale-trueScale) > 0.0001 || math.Abs(spoolDistance-trueDistance) > 0.1 {
+			t.Error("Expected scale", trueScale, "and spool distance", trueDistance, "and got", scale, spoolDistance, "with", spool)
+		}
+	}
+}
+
+// The circumference doesn't change how much cord the wound cord model lets out per turn, so its geometry should be scaled instead
+func TestApplyCalibration(t *testing.T) {
+	bare := SettingsData{SpoolCircumference_MM: 100, SpoolSingleStep_Degrees: 1.8, SpoolHorizontalDistance_MM: 1000}
+	bare.ApplyCalibration(1.02, 1010)
+	if math.Abs(bare.SpoolCircumference_MM-102) > 0.000001 || bare.SpoolHorizontalDistance_MM != 1010 || math.Abs(bare.StepSize_MM-0.51) > 0.000001 {
+		t.Error("Expected the circumference scaled and the step size from it and got", bare.SpoolCircumference_MM, bare.SpoolHorizontalDistance_MM, bare.StepSize_MM)
+	}
+
+	wound := SettingsData{SpoolCircumference_MM: 100, SpoolSingleStep_Degrees: 1.8, CordThickness_MM: 0.5, SpoolCoreDiameter_MM: 30, SpoolWrapsPerLayer: 1000000, CordLength_MM: 5000}
+	before := wound.WoundSpool()
+	wound.ApplyCalibration(1.02, 1010)
+	after := wound.WoundSpool()
+	if wound.SpoolCircumference_MM != 100 || math.Abs(wound.SpoolCoreDiameter_MM-30.6) > 0.000001 || math.Abs(wound.CordThickness_MM-0.51) > 0.000001 {
+		t.Error("Expected the spool core and cord scaled and got", wound.SpoolCircumference_MM, wound.SpoolCoreDiameter_MM, wound.CordThickness_MM)
+	}
+	if turns, scaledTurns := spoolTurns(before, 1000), spoolTurns(after, 1000*1.02); math.Abs(turns-scaledTurns) > 0.000001 {
+		t.Error("Expected the same turns to let out 1.02 times the cord and got", turns, scaledTurns)
+	}
+}
+
+// A single measurement can't solve for two unknowns
+func TestSolveCalibrationTooFewMeasurements(t *testing.T) {
+	measurements := []CalibrationMeasurement{{From: Coordinate{X: 0, Y: 0}, To: Coordinate{X: 10, Y: 0}, Distance_MM: 10}}
+	if _, _, err := SolveCalibration(PolarSystem{RightMotorDist: 1000}, WoundSpool{Circumference_MM: 100}, PolarCoordinate{LeftDist: 500, RightDist: 500}, measurements); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+// Pattern should start and end at the origin with the pen up
+func TestCalibrationPattern(t *testing.T) {
+	pattern := CalibrationPattern(100, 4)
+
+	first, last := pattern[0], pattern[len(pattern)-1]
+	if !first.Equals(Coordinate{X: 0, Y: 0, PenUp: true}) || !last.Equals(Coordinate{X: 0, Y: 0, PenUp: true}) {
+		t.Error("Unexpected start or end of pattern", first, last)
+	}
+
+	minPoint, maxPoint := Coordinates(pattern).Extents()
+	if !minPoint.Same(Coordinate{X: 0, Y: 0}) || !maxPoint.Same(Coordinate{X: 100, Y: 100}) {
+		t.Error("Unexpected extents", minPoint, maxPoint)
+	}
+}
@@ -9,7 +9,7 @@ import (
 )
 
 // Writes step data and position to a graph
-func WriteStepsToChart(stepData <-chan int8) {
+func WriteStepsToChart(stepData <-chan int8) error {
 
 	maxNumberSteps := 15000
 
@@ -63,7 +63,7 @@ func WriteStepsToChart(stepData <-chan int8) {
 	// axis labels.
 	p, err := chart.New()
 	if err != nil {
-		panic(err)
+		return err
 	}
 	p.Title.Text = "Polargraph Position & Velocity"
 	p.X.Label.Text = "2ms Slice"
@@ -97,7 +97,5 @@ func WriteStepsToChart(stepData <-chan int8) {
 	p.Legend.Add("Right Vel", rightVelLine)
 
 	// Save the plot to a PNG file.
-	if err := p.Save(5000, 500, "chart.png"); err != nil {
-		panic(err)
-	}
+	return p.Save(5000, 500, "chart.png")
 }
@@ -125,14 +125,19 @@ type PolarSystem struct {
 
 // Create a PolarSystem from the settings object
 func PolarSystemFromSettings() PolarSystem {
+	return Settings.PolarSystem()
+}
+
+// Create a PolarSystem from the given settings
+func (settings *SettingsData) PolarSystem() PolarSystem {
 	return PolarSystem{
 		XOffset:        0,
 		YOffset:        0,
-		XMin:           Settings.DrawingSurfaceMinX_MM,
-		XMax:           Settings.DrawingSurfaceMaxX_MM,
-		YMin:           Settings.DrawingSurfaceMinY_MM,
-		YMax:           Settings.DrawingSurfaceMaxY_MM,
-		RightMotorDist: Settings.SpoolHorizontalDistance_MM,
+		XMin:           settings.DrawingSurfaceMinX_MM,
+		XMax:           settings.DrawingSurfaceMaxX_MM,
+		YMin:           settings.DrawingSurfaceMinY_MM,
+		YMax:           settings.DrawingSurfaceMaxY_MM,
+		RightMotorDist: settings.SpoolHorizontalDistance_MM,
 	}
 }
 
@@ -167,6 +172,11 @@ func (coord PolarCoordinate) Ceil() PolarCoordinate {
 	return PolarCoordinate{math.Ceil(coord.LeftDist), math.Ceil(coord.RightDist), coord.PenUp}
 }
 
+// Apply math.Round to each value
+func (coord PolarCoordinate) Round() PolarCoordinate {
+	return PolarCoordinate{math.Round(coord.LeftDist), math.Round(coord.RightDist), coord.PenUp}
+}
+
 // Clamp the values of LeftDist,RightDist to the given maLeftDist/min
 func (coord PolarCoordinate) Clamp(max, min float64) PolarCoordinate {
 	return PolarCoordinate{math.Min(max, math.Max(coord.LeftDist, min)), math.Min(max, math.Max(coord.RightDist, min)), coord.PenUp}
@@ -180,22 +190,27 @@ func (coord Coordinate) ToPolar(system PolarSystem) (polarCoord PolarCoordinate)
 
 	// clip coordinates to system's area
 	if coord.X < system.XMin {
-		fmt.Println("WARNING: X value was outside left bounds, clipping", coord.X, "to", system.XMin)
+		fmt.Fprintln(Log, "WARNING: X value was outside left bounds, clipping", coord.X, "to", system.XMin)
 		coord.X = system.XMin
 	}
 	if coord.X > system.XMax {
-		fmt.Println("WARNING: X value was outside right bounds, clipping", coord.X, "to", system.XMax)
+		fmt.Fprintln(Log, "WARNING: X value was outside right bounds, clipping", coord.X, "to", system.XMax)
 		coord.X = system.XMax
 	}
 	if coord.Y < system.YMin {
-		fmt.Println("WARNING: Y value was outside top bounds, clipping", coord.Y, "to", system.YMin)
+		fmt.Fprintln(Log, "WARNING: Y value was outside top bounds, clipping", coord.Y, "to", system.YMin)
 		coord.Y = system.YMin
 	}
 	if coord.Y > system.YMax {
-		fmt.Println("WARNING: Y value was outside bottom bounds, clipping", coord.Y, "to", system.YMax)
+		fmt.Fprintln(Log, "WARNING: Y value was outside bottom bounds, clipping", coord.Y, "to", system.YMax)
 		coord.Y = system.YMax
 	}
 
+	return coord.absoluteToPolar(system)
+}
+
+// Convert a coordinate already relative to the motors into polar, without clipping it
+func (coord Coordinate) absoluteToPolar(system PolarSystem) (polarCoord PolarCoordinate) {
 	polarCoord.LeftDist = math.Sqrt(coord.X*coord.X + coord.Y*coord.Y)
 	xDiff := system.RightMotorDist - coord.X
 	polarCoord.RightDist = math.Sqrt(xDiff*xDiff + coord.Y*coord.Y)
@@ -203,6 +218,18 @@ func (coord Coordinate) ToPolar(system PolarSystem) (polarCoord PolarCoordinate)
 	return
 }
 
+// Rate each cord length changes per mm the pen moves in direction from coord, the Jacobian of ToPolar applied to direction
+func (coord Coordinate) PolarRate(system PolarSystem, direction Coordinate) PolarCoordinate {
+	x := coord.X + system.XOffset
+	y := coord.Y + system.YOffset
+	xDiff := x - system.RightMotorDist
+
+	return PolarCoordinate{
+		LeftDist:  (x*direction.X + y*direction.Y) / math.Sqrt(x*x+y*y),
+		RightDist: (xDiff*direction.X + y*direction.Y) / math.Sqrt(xDiff*xDiff+y*y),
+	}
+}
+
 // Convert the given polarCoordinate from polar to X,Y in the given PolarSystem
 func (polarCoord PolarCoordinate) ToCoord(system PolarSystem) (coord Coordinate) {
 
@@ -0,0 +1,216 @@
+package polargraph
+
+// Removes strokes that retrace lines already drawn, exact duplicates, reversed duplicates and collinear overlaps
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// A pen down line that is kept
+type dedupLine struct {
+	start, end Coordinate
+	style      drawStyle
+}
+
+// Kept lines bucketed by the grid cells they pass through, so only nearby lines are compared
+type lineHash struct {
+	cellSize float64
+	cells    map[[2]int][]int
+	lines    []dedupLine
+}
+
+func newLineHash(cellSize float64) *lineHash {
+	return &lineHash{cellSize: cellSize, cells: make(map[[2]int][]int)}
+}
+
+// Cells of the points along the line, spaced closely enough that no cell the line crosses is missed
+func (hash *lineHash) cellsAlong(line dedupLine) [][2]int {
+	length := line.end.Minus(line.start).Len()
+	samples := int(math.Ceil(2*length/hash.cellSize)) + 1
+
+	var cells [][2]int
+	for sample := 0; sample <= samples; sample++ {
+		point := line.start.Add(line.end.Minus(line.start).Scaled(float64(sample) / float64(samples)))
+		cell := [2]int{int(math.Floor(point.X / hash.cellSize)), int(math.Floor(point.Y / hash.cellSize))}
+		if len(cells) == 0 || cells[len(cells)-1] != cell {
+			cells = append(cells, cell)
+		}
+	}
+	return cells
+}
+
+func (hash *lineHash) add(line dedupLine) {
+	index := len(hash.lines)
+	hash.lines = append(hash.lines, line)
+	for _, cell := range hash.cellsAlong(line) {
+		bucket := hash.cells[cell]
+		if len(bucket) == 0 || bucket[len(bucket)-1] != index {
+			hash.cells[cell] = append(bucket, index)
+		}
+	}
+}
+
+// Kept lines in or next to the cells the line passes through, the cells are at least as big as the tolerance so nothing in reach is missed
+func (hash *lineHash) near(line dedupLine) []dedupLine {
+	seen := make(map[int]bool)
+	var near []dedupLine
+	for _, cell := range hash.cellsAlong(line) {
+		for x := -1; x <= 1; x++ {
+			for y := -1; y <= 1; y++ {
+				for _, index := range hash.cells[[2]int{cell[0] + x, cell[1] + y}] {
+					if !seen[index] {
+						seen[index] = true
+						near = append(near, hash.lines[index])
+					}
+				}
+			}
+		}
+	}
+	return near
+}
+
+// Parts of the line, as fractions from 0 at its start to 1 at its end, not already drawn by the kept lines
+// A kept line covers part of it when both of the kept line's ends are within tolerance of the line through it
+func (hash *lineHash) uncovered(line dedupLine, tolerance float64) (parts [][2]float64) {
+	direction := line.end.Minus(line.start)
+	length := direction.Len()
+	unit := direction.Scaled(1 / length)
+	normal := Coordinate{X: -unit.Y, Y: unit.X}
+
+	var covered [][2]float64
+	for _, other := range hash.near(line) {
+		if other.style != line.style {
+			continue
+		}
+		fromStart, fromEnd := other.start.Minus(line.start), other.end.Minus(line.start)
+		if math.Abs(fromStart.DotProduct(normal)) > tolerance || math.Abs(fromEnd.DotProduct(normal)) > tolerance {
+			continue
+		}
+		first, last := fromStart.DotProduct(unit)/length, fromEnd.DotProduct(unit)/length
+		if first > last {
+			first, last = last, first
+		}
+		if last > 0 && first < 1 {
+			covered = append(covered, [2]float64{math.Max(0, first), math.Min(1, last)})
+		}
+	}
+	if len(covered) == 0 {
+		return [][2]float64{{0, 1}}
+	}
+	sort.Slice(covered, func(i, j int) bool { return covered[i][0] < covered[j][0] })
+
+	// gaps between the covered parts, ignoring slivers too short to see
+	minimum := tolerance / length
+	reached := 0.0
+	for _, part := range covered {
+		if part[0]-reached > minimum {
+			parts = append(parts, [2]float64{reached, part[0]})
+		}
+		reached = math.Max(reached, part[1])
+	}
+	if 1-reached > minimum {
+		parts = append(parts, [2]float64{reached, 1})
+	}
+	return parts
+}
+
+// Remove the pen down lines, or the parts of them, that retrace lines drawn earlier within tolerance
+// Glyphs are split where a part is removed from their middle, points on their own and pen up moves are kept
+// The glyphs have to be straight lines, curves are only compared between the ends of their segments
+// Lines are only compared with lines of the same layer and colour, and what is left keeps the metadata of the line it is part of
+func DedupGlyphs(glyphs []Glyph, tolerance float64) (deduped []Glyph, removedLength float64) {
+	// the cells have to be at least as big as the tolerance, and are about as long as the average line
+	totalLength, lineCount := 0.0, 0
+	for _, glyph := range glyphs {
+		totalLength += glyph.Length()
+		lineCount += len(glyph.Segments) - 1
+	}
+	cellSize := 2 * tolerance
+	if lineCount > 0 && totalLength/float64(lineCount) > cellSize {
+		cellSize = totalLength / float64(lineCount)
+	}
+	hash := newLineHash(cellSize)
+
+	for _, glyph := range glyphs {
+		current := Path{glyph.Segments[0]}
+
+		// carry on from where the glyph got to, or lift the pen and start a new glyph at start, drawn like from
+		drawTo := func(start, end Coordinate, from Segment) {
+			start.PenUp, end.PenUp = false, false
+			if !current[len(current)-1].End.Same(start) {
+				if len(current) > 1 {
+					deduped = append(deduped, glyphFromSegments(current))
+				}
+				start.PenUp = true
+				current = Path{LineTo(start).withSourceOf(from)}
+			}
+			current = append(current, LineTo(end).withSourceOf(from))
+		}
+
+		for index := 1; index < len(glyph.Segments); index++ {
+			segment := glyph.Segments[index]
+			line := dedupLine{start: glyph.Segments[index-1].End, end: segment.End, style: segment.style()}
+			length := line.end.Minus(line.start).Len()
+			if length == 0 {
+				// a dot, kept so stippling still works
+				drawTo(line.start, line.end, segment)
+				continue
+			}
+
+			kept := 0.0
+			for _, part := range hash.uncovered(line, tolerance) {
+				partStart := line.start.Add(line.end.Minus(line.start).Scaled(part[0]))
+				partEnd := line.start.Add(line.end.Minus(line.start).Scaled(part[1]))
+				drawTo(partStart, partEnd, segment)
+
+				hash.add(dedupLine{start: partStart, end: partEnd, style: line.style})
+				kept += (part[1] - part[0]) * length
+			}
+			removedLength += length - kept
+		}
+
+		if len(current) > 1 || len(glyph.Segments) == 1 {
+			deduped = append(deduped, glyphFromSegments(current))
+		}
+	}
+	return deduped, removedLength
+}
+
+// Tolerance used to dedup paths, DedupTolerance_MM or a step when it isn't set
+func (settings *SettingsData) DedupTolerance() float64 {
+	if settings.DedupTolerance_MM > 0 {
+		return settings.DedupTolerance_MM
+	}
+	return settings.StepSize_MM
+}
+
+// A pipeline transform that removes retraced strokes from the whole path, curves are flattened to straight lines
+// The order of what is left is unchanged, so the path still starts and finishes in the same places
+func DedupTransform(tolerance float64) Transform {
+	return func(ctx context.Context, in <-chan Segment, out chan<- Segment) error {
+		path, err := CollectPath(ctx, in)
+		if err != nil || len(path) == 0 {
+			return err
+		}
+
+		glyphs, err := MakeGlyphs(path)
+		if err != nil {
+			return err
+		}
+		// only straight lines are compared, so curves are flattened here rather than for every stage
+		for index, glyph := range glyphs {
+			glyphs[index] = glyph.Flattened(Settings.StepSize_MM)
+		}
+		deduped, removedLength := DedupGlyphs(glyphs, tolerance)
+		fmt.Fprintln(Log, "Removed", removedLength, "mm of retraced strokes within", tolerance, "mm, glyphs went from", len(glyphs), "to", len(deduped))
+
+		var dedupedPath Path
+		for _, glyph := range deduped {
+			dedupedPath = append(dedupedPath, glyph.Segments...)
+		}
+		return SendPath(ctx, out, dedupedPath)
+	}
+}
@@ -0,0 +1,120 @@
+package polargraph
+
+import (
+	"math"
+	"testing"
+)
+
+// Glyph drawn through the points, starting with the pen up
+func dedupGlyph(points ...Coordinate) Glyph {
+	coords := append([]Coordinate{}, points...)
+	coords[0].PenUp = true
+	return GlyphFromCoordinates(coords)
+}
+
+// Total pen down length of the glyphs
+func dedupLength(glyphs []Glyph) (length float64) {
+	for _, glyph := range glyphs {
+		length += glyph.Length()
+	}
+	return
+}
+
+// Exact and reversed copies of a glyph should be removed completely
+func TestDedupDuplicates(t *testing.T) {
+	square := dedupGlyph(Coordinate{X: 0, Y: 0}, Coordinate{X: 10, Y: 0}, Coordinate{X: 10, Y: 10}, Coordinate{X: 0, Y: 10}, Coordinate{X: 0, Y: 0})
+	glyphs := []Glyph{square, square, square.Reversed()}
+
+	deduped, removedLength := DedupGlyphs(glyphs, 0.1)
+	if len(deduped) != 1 {
+		t.Fatal("Expected only the first square to be left and got", deduped)
+	}
+	if len(deduped[0].Segments) != len(square.Segments) {
+		t.Error("Expected the first square to be unchanged and got", deduped[0].Segments)
+	}
+	if math.Abs(removedLength-80) > 0.000001 {
+		t.Error("Expected 80mm to be removed and got", removedLength)
+	}
+}
+
+// A line that partly overlaps one already drawn, within tolerance, should be trimmed to the part that isn't
+func TestDedupCollinearOverlap(t *testing.T) {
+	glyphs := []Glyph{
+		dedupGlyph(Coordinate{X: 0, Y: 0}, Coordinate{X: 10, Y: 0}),
+		dedupGlyph(Coordinate{X: 15, Y: 0.05}, Coordinate{X: 5, Y: 0.05}),
+	}
+
+	deduped, removedLength := DedupGlyphs(glyphs, 0.1)
+	if len(deduped) != 2 {
+		t.Fatal("Expected both glyphs to be left and got", deduped)
+	}
+	trimmed := deduped[1]
+	if len(trimmed.Segments) != 2 || !trimmed.start().Same(Coordinate{X: 15, Y: 0.05}) || !trimmed.end().Same(Coordinate{X: 10, Y: 0.05}) {
+		t.Error("Expected the second glyph to be trimmed to 15 to 10 and got", trimmed.Segments)
+	}
+	if !trimmed.start().PenUp {
+		t.Error("Expected the trimmed glyph to start with the pen up")
+	}
+	if math.Abs(removedLength-5) > 0.000001 {
+		t.Error("Expected 5mm to be removed and got", removedLength)
+	}
+}
+
+// Removing the middle of a glyph should split it, and lines further than tolerance away or at an angle should be kept
+func TestDedupSplitsGlyph(t *testing.T) {
+	glyphs := []Glyph{
+		dedupGlyph(Coordinate{X: 4, Y: 0}, Coordinate{X: 6, Y: 0}),
+		dedupGlyph(Coordinate{X: 0, Y: 0}, Coordinate{X: 10, Y: 0}, Coordinate{X: 10, Y: 5}),
+		dedupGlyph(Coordinate{X: 0, Y: 1}, Coordinate{X: 10, Y: 1}),
+		dedupGlyph(Coordinate{X: 4, Y: -1}, Coordinate{X: 6, Y: 1}),
+	}
+
+	deduped, removedLength := DedupGlyphs(glyphs, 0.1)
+	if len(deduped) != 5 {
+		t.Fatal("Expected 5 glyphs and got", len(deduped), deduped)
+	}
+	if !deduped[1].end().Same(Coordinate{X: 4, Y: 0}) || !deduped[2].start().Same(Coordinate{X: 6, Y: 0}) || !deduped[2].end().Same(Coordinate{X: 10, Y: 5}) {
+		t.Error("Expected the second glyph to be split around 4 to 6 and got", deduped[1].Segments, deduped[2].Segments)
+	}
+	if math.Abs(removedLength-2) > 0.000001 {
+		t.Error("Expected 2mm to be removed and got", removedLength)
+	}
+	if math.Abs(dedupLength(deduped)-(dedupLength(glyphs)-removedLength)) > 0.000001 {
+		t.Error("Expected the length left to be", dedupLength(glyphs)-removedLength, "and got", dedupLength(deduped))
+	}
+}
+
+// Dots and lines shorter than the tolerance that don't retrace anything should be kept
+func TestDedupKeepsDots(t *testing.T) {
+	glyphs := []Glyph{
+		dedupGlyph(Coordinate{X: 1, Y: 1}, Coordinate{X: 1, Y: 1}),
+		dedupGlyph(Coordinate{X: 2, Y: 2}, Coordinate{X: 2.05, Y: 2}),
+		GlyphFromCoordinates([]Coordinate{{X: 0, Y: 0, PenUp: true}}),
+	}
+
+	deduped, removedLength := DedupGlyphs(glyphs, 0.1)
+	if len(deduped) != len(glyphs) || removedLength != 0 {
+		t.Error("Expected every glyph to be kept and got", deduped, "with", removedLength, "mm removed")
+	}
+}
+
+// Lines retraced from far apart in a large drawing should be found through the spatial hash
+func TestDedupManyLines(t *testing.T) {
+	var glyphs []Glyph
+	for row := 0; row < 100; row++ {
+		y := float64(row)
+		glyphs = append(glyphs, dedupGlyph(Coordinate{X: 0, Y: y}, Coordinate{X: 1000, Y: y}))
+	}
+	for row := 99; row >= 0; row-- {
+		y := float64(row) + 0.01
+		glyphs = append(glyphs, dedupGlyph(Coordinate{X: 1000, Y: y}, Coordinate{X: 500, Y: y}))
+	}
+
+	deduped, removedLength := DedupGlyphs(glyphs, 0.1)
+	if len(deduped) != 100 {
+		t.Error("Expected only the first 100 lines to be left and got", len(deduped))
+	}
+	if math.Abs(removedLength-50000) > 0.0001 {
+		t.Error("Expected 50000mm to be removed and got", removedLength)
+	}
+}
@@ -3,173 +3,356 @@ package polargraph
 // Handles sending data over serial to the arduino
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"math"
-	"os"
 	"strings"
 	"time"
 
 	serial "github.com/tarm/goserial"
 )
 
-// Output the coordinates to the screen
-func OutputCoords(plotCoords <-chan Coordinate) {
+// Receives the generated step stream, two values are written for every slice, left then right
+type StepSink interface {
+	WriteStep(step int8)
+}
 
-	for coord := range plotCoords {
-		fmt.Println(coord)
-	}
+// A StepSink that sends the steps over a channel
+type StepChannel chan<- int8
+
+func (channel StepChannel) WriteStep(step int8) {
+	channel <- step
+}
+
+// Generates steps for a series of segments, everything it needs is held here so it doesn't depend on the global Settings
+type StepGenerator struct {
+	// Motion limits, step size, spool model and the starting cord lengths
+	Settings SettingsData
+
+	// Motor positions and drawing bounds, the offsets are replaced so that 0,0 is the starting position
+	System PolarSystem
+
+	// Progress and warnings are written here
+	Log io.Writer
 
-	fmt.Println("Done plotting")
+	// When no segment arrives for this long the moves in the look ahead window are drawn without waiting for it to fill,
+	// so that live input like the mouse isn't held back, 0 always waits
+	IdleFlush time.Duration
 }
 
-// Takes in coordinates and outputs stepData
-func GenerateSteps(plotCoords <-chan Coordinate, stepData chan<- int8) {
+// Create a StepGenerator for the given settings, logging to log
+func NewStepGenerator(settings SettingsData, log io.Writer) StepGenerator {
+	return StepGenerator{Settings: settings, System: settings.PolarSystem(), Log: log, IdleFlush: 100 * time.Millisecond}
+}
+
+// Takes in segments and outputs stepData using the global Settings, the first segment only gives the starting point
+// Once ctx is done the pen is brought to a stop, lifted and returned to the starting position
+func GenerateSteps(ctx context.Context, plotSegments <-chan Segment, stepData chan<- int8) error {
 
 	defer close(stepData)
 
-	polarSystem := PolarSystemFromSettings()
-	previousPolarPos := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}
+	_, err := NewStepGenerator(Settings, Log).Generate(ctx, plotSegments, StepChannel(stepData))
+	return err
+}
+
+// Takes in segments and writes the steps to follow them to sink, the first segment only gives the starting point
+// Once ctx is done no more segments are read, the moves already planned are cut short as soon as the pen can stop,
+// then the pen is lifted and returned to the starting position so that it is still where the settings expect it to be
+// Returns the RMS difference in mm between the spool positions the steps reach and the ideal positions over every slice
+func (generator StepGenerator) Generate(ctx context.Context, plotSegments <-chan Segment, sink StepSink) (rmsError float64, err error) {
+
+	settings := generator.Settings
+	polarSystem := generator.System
+	previousPolarPos := PolarCoordinate{LeftDist: settings.StartingLeftDist_MM, RightDist: settings.StartingRightDist_MM}
 	startingLocation := previousPolarPos.ToCoord(polarSystem)
 
-	fmt.Println("Start Location", startingLocation, "Initial Polar", previousPolarPos)
+	fmt.Fprintln(generator.Log, "Start Location", startingLocation, "Initial Polar", previousPolarPos)
 
 	if startingLocation.IsNaN() {
-		panic("Starting location is not a valid number, setup has impossible values")
+		return 0, errors.New("Starting location is not a valid number, setup has impossible values")
 	}
 
 	// setup 0,0 as the initial location of the plot head
 	polarSystem.XOffset = startingLocation.X
 	polarSystem.YOffset = startingLocation.Y
 
-	//var interp PositionInterpolater = new(LinearInterpolater)
-	var interp = new(TrapezoidInterpolater)
+	// steps are generated from how far the spools have turned, which is not linear in cord length once cord stacks up on the spool
+	spool := settings.WoundSpool()
+	writer, err := newStepWriter(&settings, spool, previousPolarPos, sink)
+	if err != nil {
+		return 0, err
+	}
+
+	interp, err := NewInterpolater(&settings)
+	if err != nil {
+		return 0, err
+	}
 
-	target, chanOpen := <-plotCoords
+	var first Segment
+	chanOpen := false
+	select {
+	case first, chanOpen = <-plotSegments:
+	case <-ctx.Done():
+	}
 	if !chanOpen {
 		return
 	}
-	origin := target
 
+	planner := NewLookAheadPlanner(&settings, settings.LookAheadSegments, first.End, polarSystem, spool, interp)
 	var currentPenUp bool = true // arduino code defaults to pen up on ResetCommand
-	var anotherTarget bool = true
+	returning := false
+
+	idle := time.NewTimer(generator.IdleFlush)
+	defer idle.Stop()
+
+	for {
+		// keep the look ahead window full while there are more segments
+		for chanOpen && !planner.Ready() {
+			var idleFlush <-chan time.Time
+			if generator.IdleFlush > 0 && planner.Len() > 0 {
+				if !idle.Stop() {
+					select {
+					case <-idle.C:
+					default:
+					}
+				}
+				idle.Reset(generator.IdleFlush)
+				idleFlush = idle.C
+			}
 
-	for anotherTarget {
-		nextTarget, chanOpen := <-plotCoords
-		if !chanOpen {
-			anotherTarget = false
-			nextTarget = target
+			select {
+			case segment, open := <-plotSegments:
+				if chanOpen = open; open {
+					planner.Add(segment)
+				}
+			case <-idleFlush:
+				planner.Flush()
+			case <-ctx.Done():
+				chanOpen = false
+			}
 		}
 
-		if target.PenUp != currentPenUp {
+		if ctx.Err() != nil && !returning {
+			// when every move in the window is needed to stop this is tried again after the next one
+			chanOpen = false
+			planner.Stop()
+			if !planner.Full() {
+				fmt.Fprintln(generator.Log, "Stopping, returning to the starting position")
+				planner.Add(LineTo(Coordinate{PenUp: true}))
+				returning = true
+			}
+		}
+		if planner.Len() == 0 {
+			break
+		}
+
+		origin, segment, entrySpeed, maxSpeed, exitSpeed := planner.Next()
+
+		if segment.End.PenUp != currentPenUp {
 			// send twice in order to preserve alignment of always sending 2 values at a time over serial
-			if target.PenUp {
-				stepData <- PenUpCommand
-				stepData <- PenUpCommand
+			if segment.End.PenUp {
+				sink.WriteStep(PenUpCommand)
+				sink.WriteStep(PenUpCommand)
 			} else {
-				stepData <- PenDownCommand
-				stepData <- PenDownCommand
+				sink.WriteStep(PenDownCommand)
+				sink.WriteStep(PenDownCommand)
 			}
-			currentPenUp = target.PenUp
+			currentPenUp = segment.End.PenUp
 		}
 
-		interp.Setup(origin, target, nextTarget)
+		interp.Setup(origin, segment, entrySpeed, maxSpeed, exitSpeed)
 
 		//fmt.Println("Slices", interp.Slices(), "------------------------")
 
-		for slice := 1.0; slice <= interp.Slices(); slice++ {
+		writer.writeMove(interp, polarSystem)
+	}
+
+	if writer.clampedSlices > 0 {
+		fmt.Fprintln(generator.Log, "WARNING:", writer.clampedSlices, "slices needed more than the max steps per slice and were clamped, the drawing will be shifted")
+	}
+	fmt.Fprintf(generator.Log, "Quantisation error RMS: %.5f mm\n", writer.rmsError())
+	fmt.Fprintln(generator.Log, "Done generating steps")
+
+	return writer.rmsError(), nil
+}
+
+// Turns interpolated positions into steps, keeping track of where the spools have actually been moved to
+type stepWriter struct {
+	spool     WoundSpool
+	stepSize  float64
+	quantiser *StepQuantiser
+	sink      StepSink
+
+	position PolarCoordinate // spool position reached by the steps written so far
+
+	slices        int     // number of slices written
+	clampedSlices int     // slices that needed more than StepsMaxValue steps
+	squaredError  float64 // sum over every slice of the squared difference between the position reached and the ideal position
+}
+
+// Create a stepWriter for the given settings, starting with the spools at position
+func newStepWriter(settings *SettingsData, spool WoundSpool, position PolarCoordinate, sink StepSink) (*stepWriter, error) {
+	quantiser, err := NewStepQuantiser(settings.Quantiser)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stepWriter{
+		spool:     spool,
+		stepSize:  settings.StepSize_MM,
+		quantiser: quantiser,
+		sink:      sink,
+		position:  spool.ToSpool(position),
+	}, nil
+}
 
-			sliceTarget := interp.Position(slice)
-			polarSliceTarget := sliceTarget.ToPolar(polarSystem)
+// Writes the steps for every slice of the interpolater's current move
+func (writer *stepWriter) writeMove(interp PositionInterpolater, polarSystem PolarSystem) {
 
-			// calc number of steps that will be made this time slice, have to precision that can be sent in a single value from StepsMaxValue to -StepsMaxValue
-			sliceSteps := polarSliceTarget.
-				Minus(previousPolarPos).
-				Scaled(StepsFixedPointFactor/Settings.StepSize_MM).
-				Ceil().
-				Clamp(StepsMaxValue, -StepsMaxValue)
-			previousPolarPos = previousPolarPos.
-				Add(sliceSteps.Scaled(Settings.StepSize_MM / StepsFixedPointFactor))
+	toSteps := StepsFixedPointFactor / writer.stepSize
 
-			stepData <- int8(-sliceSteps.LeftDist)
-			stepData <- int8(sliceSteps.RightDist)
+	for slice := 1.0; slice <= interp.Slices(); slice++ {
+
+		sliceTarget := interp.Position(slice)
+		spoolSliceTarget := writer.spool.ToSpool(sliceTarget.ToPolar(polarSystem))
+
+		// calc number of steps that will be made this time slice, have to precision that can be sent in a single value from StepsMaxValue to -StepsMaxValue
+		sliceSteps := writer.quantiser.Quantise(writer.position.Scaled(toSteps), spoolSliceTarget.Scaled(toSteps))
+		if clamped := sliceSteps.Clamp(StepsMaxValue, -StepsMaxValue); clamped != sliceSteps {
+			writer.clampedSlices++
+			sliceSteps = clamped
 		}
-		origin = target
-		target = nextTarget
+		writer.position = writer.position.
+			Add(sliceSteps.Scaled(1 / toSteps))
+
+		sliceError := writer.position.Minus(spoolSliceTarget)
+		writer.squaredError += sliceError.LeftDist*sliceError.LeftDist + sliceError.RightDist*sliceError.RightDist
+		writer.slices++
+
+		writer.sink.WriteStep(int8(-sliceSteps.LeftDist))
+		writer.sink.WriteStep(int8(sliceSteps.RightDist))
 	}
-	fmt.Println("Done generating steps")
 }
 
-// Count steps
-func CountSteps(stepData <-chan int8) {
+// Root mean square over both spools and every slice of the difference between where the steps moved the spools and where they should be
+func (writer *stepWriter) rmsError() float64 {
+	if writer.slices == 0 {
+		return 0
+	}
+	return math.Sqrt(writer.squaredError / float64(2*writer.slices))
+}
+
+// Slices and pen transitions in a stream of steps, as the microcontroller will run them
+type StepCount struct {
+	DrawSlices, TravelSlices, PenTransitions int
+}
 
-	sliceCount := 0
-	penTransition := 0
+// Count the slices spent drawing with the pen down separately from pen up travel
+func TallySteps(stepData <-chan int8) (count StepCount) {
+	penUp := true // arduino code defaults to pen up on ResetCommand
 	for step := range stepData {
 
-		if step == PenUpCommand || step == PenDownCommand {
-			penTransition++
-		} else {
-			sliceCount++
+		switch {
+		case step == PenUpCommand:
+			count.PenTransitions++
+			penUp = true
+		case step == PenDownCommand:
+			count.PenTransitions++
+			penUp = false
+		case penUp:
+			count.TravelSlices++
+		default:
+			count.DrawSlices++
 		}
 	}
 	// since data is sent once for left and right spools, have to divide by 2
-	sliceCount = sliceCount >> 1
-	penTransition = penTransition >> 1
-	penTransitionCooldown_US := 650000.0 // as defined in the microcontroller code
-	fmt.Println("Steps", sliceCount, "Pen Transitions", penTransition, "Time", time.Duration(float64(sliceCount)*TimeSlice_US+float64(penTransition)*penTransitionCooldown_US)*time.Microsecond)
+	count.DrawSlices = count.DrawSlices >> 1
+	count.TravelSlices = count.TravelSlices >> 1
+	count.PenTransitions = count.PenTransitions >> 1
+	return
+}
+
+// Time spent drawing with the pen down
+func (count StepCount) DrawTime() time.Duration {
+	return time.Duration(float64(count.DrawSlices)*TimeSlice_US) * time.Microsecond
 }
 
-// Sends the given stepData to the stepper driver
-func WriteStepsToSerial(stepData <-chan int8) {
+// Time spent moving with the pen up
+func (count StepCount) TravelTime() time.Duration {
+	return time.Duration(float64(count.TravelSlices)*TimeSlice_US) * time.Microsecond
+}
+
+// Total time, including waiting for the pen to move up or down
+func (count StepCount) Time() time.Duration {
+	return time.Duration(float64(count.DrawSlices+count.TravelSlices)*TimeSlice_US+float64(count.PenTransitions)*PenTransitionCooldown_US) * time.Microsecond
+}
+
+// Count steps, reporting the time spent drawing with the pen down separately from pen up travel
+func CountSteps(stepData <-chan int8) error {
+	count := TallySteps(stepData)
+	fmt.Println("Steps", count.DrawSlices+count.TravelSlices, "Pen Transitions", count.PenTransitions, "Time", count.Time())
+	fmt.Println("Drawing", count.DrawTime(), "Travel", count.TravelTime())
+	return nil
+}
+
+// Sends the given stepData to the stepper driver, returning when stepData is closed or on the first error
+// stepData is not drained after an error, the caller has to stop whatever is sending on it
+func WriteStepsToSerial(stepData <-chan int8) error {
 
 	fmt.Println("Opening com port ", Settings.SerialPortPath)
 	c := &serial.Config{Name: Settings.SerialPortPath, Baud: 57600}
 	s, err := serial.OpenPort(c)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer s.Close()
 
+	return writeStepsOverSerial(s, stepData)
+}
+
+// Reset the stepper driver then send it the steps as it asks for them, until stepData is closed
+func writeStepsOverSerial(port io.ReadWriter, stepData <-chan int8) error {
 	// buffers to use during serial communication
-	writeData := make([]byte, 128)
+	writeData := make([]byte, 0, 128)
 	readData := make([]byte, 1)
 
 	previousSend := time.Now()
 	var totalSends int = 0
-	var byteData int8
 
 	// send a -128 to force the arduino to restart and rerequest data
-	_, err = s.Write([]byte{ResetCommand})
-	if err != nil {
-		panic(err)
+	if _, err := port.Write([]byte{ResetCommand}); err != nil {
+		return err
 	}
 
-	var pauseAfterWrite = false
-
 	for stepDataOpen := true; stepDataOpen; {
 		// wait for next data request
-		n, err := s.Read(readData)
+		n, err := port.Read(readData)
 		if err != nil {
-			panic(err)
+			return err
 		}
 		if n != 1 {
-			panic(err)
+			continue
 		}
 
-		dataToWrite := int(readData[0])
-		for i := 0; i < dataToWrite; i += 2 {
+		switch readData[0] {
+		case LimitSwitchResponse:
+			return errors.New("Limit switch triggered, the stepper driver has stopped moving")
+		case HomedResponse:
+			continue
+		}
 
-			if pauseAfterWrite {
-				// want to fill remainder of buffer with 0s before writing it to serial
-				writeData[i] = byte(0)
-				writeData[i+1] = byte(0)
-			} else {
-				// even if stepData is closed and empty, receiving from it will return default value 0 for byteData and false for stepDataOpen
-				byteData = <-stepData
-				writeData[i] = byte(byteData)
-				byteData, stepDataOpen = <-stepData
-				writeData[i+1] = byte(byteData)
+		// the arduino accepts the requested data in several writes, so steps are sent as soon as they are ready
+		// instead of waiting for enough to fill the request, which lets a single short move start right away
+		var steps []byte
+		for requested := int(readData[0]); requested > 0 && stepDataOpen; requested -= len(steps) {
+			steps, stepDataOpen = receiveReadySteps(stepData, writeData, requested)
+			if len(steps) == 0 {
+				continue
+			}
+			if _, err = port.Write(steps); err != nil {
+				return err
 			}
 		}
 
@@ -182,27 +365,131 @@ func WriteStepsToSerial(stepData <-chan int8) {
 
 			previousSend = curTime
 		}
+	}
+	return nil
+}
 
-		_, err = s.Write(writeData)
-		if err != nil {
-			panic(err)
+// Receive up to max values from stepData into buffer, waiting for the first slice but not for any after it
+// Slices are always received whole, left then right, open is false once stepData is closed
+func receiveReadySteps(stepData <-chan int8, buffer []byte, max int) (steps []byte, open bool) {
+	steps = buffer[:0]
+	for len(steps)+2 <= max {
+		var left, right int8
+		if len(steps) == 0 {
+			left, open = <-stepData
+		} else {
+			select {
+			case left, open = <-stepData:
+			default:
+				return steps, true
+			}
 		}
+		if !open {
+			return steps, false
+		}
+		if right, open = <-stepData; !open {
+			return steps, false
+		}
+		steps = append(steps, byte(left), byte(right))
+	}
+	return steps, true
+}
+
+// Longest the host waits for homing to finish, longer than the stepper driver takes to give up by itself
+const HomeTimeout = 3 * time.Minute
 
-		if pauseAfterWrite {
-			pauseAfterWrite = false
+// How long a read from the serial port waits while homing, so the deadline and ctx are checked between reads
+const homeReadTimeout = time.Second
 
-			fmt.Println("Press any key to continue...")
-			reader := bufio.NewReader(os.Stdin)
-			_, err = reader.ReadString('\n')
-			if err != nil {
-				panic(err)
+// Retract both spools until their limit switches trigger, then set the starting position to the known homed position
+// Stops the stepper driver and returns ctx's error when ctx is cancelled
+func Home(ctx context.Context) error {
+
+	if Settings.HomeLeftDist_MM == 0 || Settings.HomeRightDist_MM == 0 {
+		return errors.New("HomeLeftDist_MM and HomeRightDist_MM must be set in the settings file before homing")
+	}
+
+	fmt.Println("Opening com port ", Settings.SerialPortPath)
+	c := &serial.Config{Name: Settings.SerialPortPath, Baud: 57600, ReadTimeout: homeReadTimeout}
+	s, err := serial.OpenPort(c)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	fmt.Println("Homing...")
+	if err := homeOverSerial(ctx, s, time.Now().Add(HomeTimeout)); err != nil {
+		return err
+	}
+
+	Settings.StartingLeftDist_MM = Settings.HomeLeftDist_MM
+	Settings.StartingRightDist_MM = Settings.HomeRightDist_MM
+	fmt.Println("Homed at", PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM})
+	return Settings.Write()
+}
+
+// Reset the stepper driver, send it the home command when it asks for data, and wait until it answers that it has homed
+// A read that times out or finds nothing waiting returns io.EOF, which only means there is nothing to read yet
+func homeOverSerial(ctx context.Context, port io.ReadWriter, deadline time.Time) error {
+	// send a -128 to force the arduino to restart and rerequest data
+	if _, err := port.Write([]byte{ResetCommand}); err != nil {
+		return err
+	}
+
+	// the home command is sent twice in order to preserve alignment of always sending 2 values at a time, the rest is filled with 0s
+	homeData := make([]byte, 128)
+	homeData[0] = HomeCommand
+	homeData[1] = HomeCommand
+	emptyData := make([]byte, 128)
+	readData := make([]byte, 1)
+
+	for homeSent := false; ; {
+		if ctx.Err() != nil {
+			// the arduino stops homing when it sees a reset
+			port.Write([]byte{ResetCommand})
+			return ctx.Err()
+		}
+		if time.Now().After(deadline) {
+			port.Write([]byte{ResetCommand})
+			return errors.New("Timed out waiting for homing to finish")
+		}
+
+		n, err := port.Read(readData)
+		if err == io.EOF {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if n != 1 {
+			continue
+		}
+
+		switch readData[0] {
+		case HomedResponse:
+			return nil
+		case LimitSwitchResponse:
+			if homeSent {
+				return errors.New("Homing gave up before both limit switches triggered, check the switches and their wiring")
 			}
+			return errors.New("Limit switch triggered before homing started")
+		}
+
+		// data request, keep the arduino idle until homing finishes
+		if homeSent {
+			_, err = port.Write(emptyData[:readData[0]])
+		} else {
+			_, err = port.Write(homeData[:readData[0]])
+			homeSent = true
+		}
+		if err != nil {
+			return err
 		}
 	}
 }
 
 // Used to manually adjust length of each step
-func InteractiveMoveSpool() {
+// Returns the first error moving a spool, or nil once a blank line is entered
+func InteractiveMoveSpool() error {
 
 	for {
 
@@ -210,24 +497,32 @@ func InteractiveMoveSpool() {
 		var distance float64
 		fmt.Print("Input L/R DIST:")
 		if _, err := fmt.Scanln(&side, &distance); err != nil {
-			return
+			return nil
 		}
 
 		leftSpool := strings.ToLower(side) == "l"
 		fmt.Println("Moving ", side, distance)
 
-		MoveSpool(leftSpool, distance)
+		if err := MoveSpool(leftSpool, distance); err != nil {
+			return err
+		}
 	}
 }
 
 // Move a specific spool a given distance
-func MoveSpool(leftSpool bool, distance float64) {
+func MoveSpool(leftSpool bool, distance float64) error {
 
 	alignStepData := make(chan int8, 1024)
-	go WriteStepsToSerial(alignStepData)
+	writerDone := make(chan error)
+	go func() {
+		err := WriteStepsToSerial(alignStepData)
+		for range alignStepData {
+		}
+		writerDone <- err
+	}()
 
-	interp := new(TrapezoidInterpolater)
-	interp.Setup(Coordinate{}, Coordinate{X: distance, Y: 0}, Coordinate{})
+	interp := &TrapezoidInterpolater{settings: &Settings}
+	interp.Setup(Coordinate{}, LineTo(Coordinate{X: distance, Y: 0, PenUp: true}), 0, Settings.MaxSpeed_MM_S, 0)
 	position := 0.0
 
 	for slice := 1.0; slice <= interp.Slices(); slice++ {
@@ -248,4 +543,5 @@ func MoveSpool(leftSpool bool, distance float64) {
 	}
 
 	close(alignStepData)
+	return <-writerDone
 }
@@ -0,0 +1,423 @@
+package polargraph
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden step streams in testdata")
+
+// Collects the step stream in memory
+type stepRecorder []int8
+
+func (recorder *stepRecorder) WriteStep(step int8) {
+	*recorder = append(*recorder, step)
+}
+
+// Fixed settings so the step stream doesn't depend on the config file
+func goldenSettings(interpolater string) SettingsData {
+	settings := SettingsData{
+		SpoolCircumference_MM:      60,
+		SpoolSingleStep_Degrees:    0.225,
+		Acceleration_Seconds:       0.5,
+		JerkTime_Seconds:           0.1,
+		Interpolater:               interpolater,
+		SpoolHorizontalDistance_MM: 1000,
+		DrawingSurfaceMinY_MM:      50,
+		DrawingSurfaceMaxY_MM:      1000,
+		DrawingSurfaceMinX_MM:      25,
+		StartingLeftDist_MM:        600,
+		StartingRightDist_MM:       650,
+		JunctionDeviation_MM:       0.05,
+		LookAheadSegments:          8,
+	}
+	settings.CalculateDerivedFields()
+	return settings
+}
+
+// Generate the steps for the given path
+func generateGoldenSteps(t *testing.T, settings SettingsData, path Path) stepRecorder {
+	plotSegments := make(chan Segment, len(path))
+	for _, segment := range path {
+		plotSegments <- segment
+	}
+	close(plotSegments)
+
+	var steps stepRecorder
+	if _, err := NewStepGenerator(settings, ioutil.Discard).Generate(context.Background(), plotSegments, &steps); err != nil {
+		t.Fatal(err)
+	}
+	return steps
+}
+
+// Rounding to the nearest step should track the ideal cord lengths more closely than always rounding up
+func TestGenerateStepsQuantisationError(t *testing.T) {
+	path := Path{
+		LineTo(Coordinate{X: 0, Y: 0, PenUp: true}),
+		ArcAround(Coordinate{X: 0, Y: 0}, Coordinate{X: 0, Y: 20}, 2*math.Pi, false),
+	}
+
+	errors := make(map[string]float64)
+	for _, quantiser := range []string{"ceil", "round", "sigmadelta"} {
+		settings := goldenSettings("trapezoid")
+		settings.Quantiser = quantiser
+
+		plotSegments := make(chan Segment, len(path))
+		for _, segment := range path {
+			plotSegments <- segment
+		}
+		close(plotSegments)
+
+		var steps stepRecorder
+		rmsError, err := NewStepGenerator(settings, ioutil.Discard).Generate(context.Background(), plotSegments, &steps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		errors[quantiser] = rmsError
+
+		// a single fixed point step is the most any of them should be off by
+		if fixedStep := settings.StepSize_MM / StepsFixedPointFactor; errors[quantiser] > fixedStep {
+			t.Error(quantiser, "RMS error", errors[quantiser], "is more than a fixed point step", fixedStep)
+		}
+	}
+
+	if errors["round"] >= errors["ceil"] {
+		t.Error("Expected round RMS error", errors["round"], "to be less than ceil", errors["ceil"])
+	}
+}
+
+// Format the steps with one slice per line
+func formatSteps(steps stepRecorder) []byte {
+	var buffer bytes.Buffer
+	for index := 0; index+1 < len(steps); index += 2 {
+		fmt.Fprintln(&buffer, steps[index], steps[index+1])
+	}
+	return buffer.Bytes()
+}
+
+// Add up the steps to find the cord lengths reached, returning the lengths at every pen command and at the end
+func integrateSteps(settings SettingsData, steps stepRecorder) (positions []PolarCoordinate) {
+	position := PolarCoordinate{LeftDist: settings.StartingLeftDist_MM, RightDist: settings.StartingRightDist_MM}
+	stepSize := settings.StepSize_MM / StepsFixedPointFactor
+
+	for index := 0; index+1 < len(steps); index += 2 {
+		left, right := steps[index], steps[index+1]
+		if left == PenUpCommand || left == PenDownCommand {
+			positions = append(positions, position)
+			continue
+		}
+		position.LeftDist -= float64(left) * stepSize
+		position.RightDist += float64(right) * stepSize
+	}
+	return append(positions, position)
+}
+
+// Cord lengths where the pen is raised or lowered and at the end of the path
+func expectedPositions(settings SettingsData, path Path) (positions []PolarCoordinate) {
+	system := settings.PolarSystem()
+	start := PolarCoordinate{LeftDist: settings.StartingLeftDist_MM, RightDist: settings.StartingRightDist_MM}.ToCoord(system)
+	system.XOffset, system.YOffset = start.X, start.Y
+
+	penUp := true
+	for index := 1; index < len(path); index++ {
+		if path[index].End.PenUp != penUp {
+			positions = append(positions, path[index-1].End.ToPolar(system))
+			penUp = path[index].End.PenUp
+		}
+	}
+	return append(positions, path[len(path)-1].End.ToPolar(system))
+}
+
+// Fixed paths should always produce the recorded step stream, and following the steps should land on the path
+func TestGenerateStepsGolden(t *testing.T) {
+	square := Path{
+		LineTo(Coordinate{X: 0, Y: 0, PenUp: true}),
+		LineTo(Coordinate{X: 10, Y: 10, PenUp: true}),
+		LineTo(Coordinate{X: 30, Y: 10}),
+		LineTo(Coordinate{X: 30, Y: 30}),
+		LineTo(Coordinate{X: 10, Y: 30}),
+		LineTo(Coordinate{X: 10, Y: 10}),
+		LineTo(Coordinate{X: 0, Y: 0, PenUp: true}),
+	}
+	curves := Path{
+		LineTo(Coordinate{X: 0, Y: 0, PenUp: true}),
+		LineTo(Coordinate{X: 20, Y: 0, PenUp: true}),
+		ArcAround(Coordinate{X: 20, Y: 0}, Coordinate{X: 10, Y: 0}, math.Pi, false),
+		CubicTo(Coordinate{X: -10, Y: 20}, Coordinate{X: 30, Y: 30}, Coordinate{X: 20, Y: 10}),
+		LineTo(Coordinate{X: 0, Y: 0, PenUp: true}),
+	}
+
+	cases := []struct {
+		name         string
+		interpolater string
+		quantiser    string
+		path         Path
+	}{
+		{"square_trapezoid", "trapezoid", "ceil", square},
+		{"square_scurve", "scurve", "ceil", square},
+		{"curves_trapezoid", "trapezoid", "ceil", curves},
+		{"square_round", "trapezoid", "round", square},
+		{"square_sigmadelta", "trapezoid", "sigmadelta", square},
+	}
+
+	for _, test := range cases {
+		settings := goldenSettings(test.interpolater)
+		settings.Quantiser = test.quantiser
+		steps := generateGoldenSteps(t, settings, test.path)
+		formatted := formatSteps(steps)
+
+		goldenFile := filepath.Join("testdata", test.name+".golden")
+		if *updateGolden {
+			if err := ioutil.WriteFile(goldenFile, formatted, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		golden, err := ioutil.ReadFile(goldenFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(formatted, golden) {
+			t.Error(test.name, "step stream differs from", goldenFile, "run go test -update to rewrite it after an intended change")
+		}
+
+		if again := formatSteps(generateGoldenSteps(t, settings, test.path)); !bytes.Equal(formatted, again) {
+			t.Error(test.name, "step stream changed between runs")
+		}
+
+		reached, expected := integrateSteps(settings, steps), expectedPositions(settings, test.path)
+		if len(reached) != len(expected) {
+			t.Fatal(test.name, "expected", len(expected), "positions and reached", len(reached))
+		}
+		for index := range expected {
+			diff := reached[index].Minus(expected[index])
+			if math.Abs(diff.LeftDist) > settings.StepSize_MM || math.Abs(diff.RightDist) > settings.StepSize_MM {
+				t.Error(test.name, "position", index, "reached", reached[index], "expected", expected[index])
+			}
+		}
+	}
+}
+
+// Writes steps until there are enough of them, then stops the generator
+type cancellingRecorder struct {
+	stepRecorder
+	limit  int
+	cancel context.CancelFunc
+}
+
+func (recorder *cancellingRecorder) WriteStep(step int8) {
+	recorder.stepRecorder.WriteStep(step)
+	if len(recorder.stepRecorder) == recorder.limit {
+		recorder.cancel()
+	}
+}
+
+// Stopping part way through a drawing should lift the pen and return it to where it started
+func TestGenerateStepsStopped(t *testing.T) {
+	settings := goldenSettings("trapezoid")
+
+	plotSegments := make(chan Segment, 1024)
+	plotSegments <- LineTo(Coordinate{X: 0, Y: 0, PenUp: true})
+	for i := 1; i < 1000; i++ {
+		plotSegments <- LineTo(Coordinate{X: float64(i), Y: float64(i % 2)})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	recorder := &cancellingRecorder{limit: 2000, cancel: cancel}
+	if _, err := NewStepGenerator(settings, ioutil.Discard).Generate(ctx, plotSegments, recorder); err != nil {
+		t.Fatal(err)
+	}
+	steps := recorder.stepRecorder
+
+	if len(steps) < 2 || steps[len(steps)-2] == PenUpCommand {
+		t.Fatal("Expected the pen to move back after being lifted")
+	}
+	penUp := false
+	for index := recorder.limit; index < len(steps); index++ {
+		penUp = penUp || steps[index] == PenUpCommand
+	}
+	if !penUp {
+		t.Error("Expected the pen to be lifted after stopping")
+	}
+
+	positions := integrateSteps(settings, steps)
+	end, start := positions[len(positions)-1], PolarCoordinate{LeftDist: settings.StartingLeftDist_MM, RightDist: settings.StartingRightDist_MM}
+	if math.Abs(end.LeftDist-start.LeftDist) > settings.StepSize_MM || math.Abs(end.RightDist-start.RightDist) > settings.StepSize_MM {
+		t.Error("Expected to return to", start, "and ended at", end)
+	}
+	// a few mm to slow down and return is much less than the rest of the drawing
+	if len(steps) > 2*recorder.limit {
+		t.Error("Expected to stop soon after being cancelled, generated", len(steps), "steps")
+	}
+}
+
+// The steps of a short move should be sent without waiting for enough to fill the whole request
+func TestReceiveReadySteps(t *testing.T) {
+	stepData := make(chan int8, 16)
+	for _, step := range []int8{1, -1, 2, -2} {
+		stepData <- step
+	}
+
+	steps, open := receiveReadySteps(stepData, make([]byte, 0, 128), 128)
+	if !open || !bytes.Equal(steps, []byte{1, 0xFF, 2, 0xFE}) {
+		t.Error("Expected the 2 ready slices and the channel open, got", steps, open)
+	}
+
+	for _, step := range []int8{3, -3, 4, -4, 5} {
+		stepData <- step
+	}
+	close(stepData)
+	if steps, open = receiveReadySteps(stepData, make([]byte, 0, 128), 2); !open || !bytes.Equal(steps, []byte{3, 0xFD}) {
+		t.Error("Expected a single slice when only 2 values are requested, got", steps, open)
+	}
+	if steps, open = receiveReadySteps(stepData, make([]byte, 0, 128), 128); open || !bytes.Equal(steps, []byte{4, 0xFC}) {
+		t.Error("Expected the last whole slice and the channel closed, got", steps, open)
+	}
+}
+
+// Moves should be drawn when no more segments arrive for a while, without waiting for the look ahead window to fill
+func TestGenerateStepsIdleFlush(t *testing.T) {
+	settings := goldenSettings("trapezoid")
+
+	plotSegments := make(chan Segment)
+	stepData := make(chan int8, 1<<16)
+	generator := NewStepGenerator(settings, ioutil.Discard)
+	generator.IdleFlush = 10 * time.Millisecond
+	done := make(chan error)
+	go func() {
+		_, err := generator.Generate(context.Background(), plotSegments, StepChannel(stepData))
+		done <- err
+	}()
+
+	plotSegments <- LineTo(Coordinate{X: 0, Y: 0, PenUp: true})
+	plotSegments <- LineTo(Coordinate{X: 10, Y: 0, PenUp: true})
+
+	select {
+	case <-stepData:
+	case <-time.After(time.Second):
+		t.Error("Expected steps for the move before the window was full")
+	}
+
+	close(plotSegments)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The stepper driver end of a serial port, reads return the responses one byte at a time then time out
+type fakeSerialPort struct {
+	responses []byte
+	written   bytes.Buffer
+	writes    []int // length of each write
+}
+
+func (port *fakeSerialPort) Read(data []byte) (int, error) {
+	if len(port.responses) == 0 {
+		time.Sleep(time.Millisecond)
+		return 0, io.EOF
+	}
+	data[0], port.responses = port.responses[0], port.responses[1:]
+	return 1, nil
+}
+
+func (port *fakeSerialPort) Write(data []byte) (int, error) {
+	port.writes = append(port.writes, len(data))
+	return port.written.Write(data)
+}
+
+// Homing should reset the driver, send the home command on its first request and idle until it has homed
+func TestHomeOverSerial(t *testing.T) {
+	port := &fakeSerialPort{responses: []byte{128, 64, HomedResponse}}
+	if err := homeOverSerial(context.Background(), port, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{ResetCommand, HomeCommand, HomeCommand}
+	expected = append(expected, make([]byte, 126+64)...)
+	if !bytes.Equal(port.written.Bytes(), expected) {
+		t.Error("Expected a reset, the home command in a 128 byte request and 64 idle bytes, got", port.written.Bytes())
+	}
+}
+
+// Homing should fail when the driver gives up or a switch is already triggered, and stop the driver on timeout or cancel
+func TestHomeOverSerialFailures(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		responses []byte
+		deadline  time.Duration
+		err       string
+		reset     bool
+	}{
+		{name: "gave up", ctx: context.Background(), responses: []byte{128, LimitSwitchResponse}, deadline: time.Minute, err: "Homing gave up before both limit switches triggered, check the switches and their wiring"},
+		{name: "already triggered", ctx: context.Background(), responses: []byte{LimitSwitchResponse}, deadline: time.Minute, err: "Limit switch triggered before homing started"},
+		{name: "timed out", ctx: context.Background(), responses: []byte{128}, deadline: 20 * time.Millisecond, err: "Timed out waiting for homing to finish", reset: true},
+		{name: "cancelled", ctx: cancelled, responses: []byte{128}, deadline: time.Minute, err: context.Canceled.Error(), reset: true},
+	}
+	for _, test := range tests {
+		port := &fakeSerialPort{responses: test.responses}
+		err := homeOverSerial(test.ctx, port, time.Now().Add(test.deadline))
+		if err == nil || err.Error() != test.err {
+			t.Error(test.name, "expected", test.err, "and got", err)
+		}
+
+		written := port.written.Bytes()
+		if stopped := len(written) > 1 && written[len(written)-1] == ResetCommand; stopped != test.reset {
+			t.Error(test.name, "expected the driver reset at the end", test.reset, "and got", written)
+		}
+	}
+}
+
+// Steps should be sent as soon as they are ready, filling the rest of a request with later writes
+func TestWriteStepsOverSerial(t *testing.T) {
+	stepData := make(chan int8, 16)
+	for _, step := range []int8{1, -1, 2, -2} {
+		stepData <- step
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		for _, step := range []int8{3, -3, 4, -4, 5, -5} {
+			stepData <- step
+		}
+		close(stepData)
+	}()
+
+	port := &fakeSerialPort{responses: []byte{HomedResponse, 8, 128}}
+	if err := writeStepsOverSerial(port, stepData); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{ResetCommand, 1, 255, 2, 254, 3, 253, 4, 252, 5, 251}
+	if !bytes.Equal(port.written.Bytes(), expected) {
+		t.Error("Expected a reset then every step and got", port.written.Bytes())
+	}
+	// the two ready slices are sent without waiting for the rest of the 8 byte request
+	if len(port.writes) < 4 || port.writes[1] != 4 {
+		t.Error("Expected the reset, the ready steps on their own, then the later steps and got writes of", port.writes)
+	}
+}
+
+// A triggered limit switch should stop sending steps
+func TestWriteStepsOverSerialLimitSwitch(t *testing.T) {
+	stepData := make(chan int8, 2)
+	stepData <- 1
+	stepData <- 1
+
+	port := &fakeSerialPort{responses: []byte{LimitSwitchResponse}}
+	if err := writeStepsOverSerial(port, stepData); err == nil {
+		t.Error("Expected an error for the limit switch")
+	}
+	if !bytes.Equal(port.written.Bytes(), []byte{ResetCommand}) {
+		t.Error("Expected only the reset sent and got", port.written.Bytes())
+	}
+}
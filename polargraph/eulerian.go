@@ -0,0 +1,252 @@
+package polargraph
+
+// Draws glyphs that share points as a graph, split into as few pen down trails as possible with Eulerian paths
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// A line between two points of the stroke graph, virtual edges pair up odd points and are where the pen lifts
+type strokeEdge struct {
+	from, to int
+	virtual  bool
+
+	// the segment the line was drawn by, for its metadata
+	source Segment
+}
+
+// The point at the other end of the edge from point
+func (edge strokeEdge) other(point int) int {
+	if edge.from == point {
+		return edge.to
+	}
+	return edge.from
+}
+
+// Every pen down line of the glyphs, with the points they share merged
+type strokeGraph struct {
+	points   []Coordinate
+	edges    []strokeEdge
+	adjacent [][]int // index into edges of the edges at each point
+	lookup   map[[2]int64][]int
+}
+
+func newStrokeGraph() *strokeGraph {
+	return &strokeGraph{lookup: make(map[[2]int64][]int)}
+}
+
+// Index of the point at coord, added if there isn't one close enough to count as the Same
+func (graph *strokeGraph) point(coord Coordinate) int {
+	const cellSize = 0.00001
+	cellX, cellY := int64(math.Floor(coord.X/cellSize)), int64(math.Floor(coord.Y/cellSize))
+	for x := cellX - 1; x <= cellX+1; x++ {
+		for y := cellY - 1; y <= cellY+1; y++ {
+			for _, index := range graph.lookup[[2]int64{x, y}] {
+				if graph.points[index].Same(coord) {
+					return index
+				}
+			}
+		}
+	}
+
+	coord.PenUp = false
+	index := len(graph.points)
+	graph.points = append(graph.points, coord)
+	graph.adjacent = append(graph.adjacent, nil)
+	graph.lookup[[2]int64{cellX, cellY}] = append(graph.lookup[[2]int64{cellX, cellY}], index)
+	return index
+}
+
+func (graph *strokeGraph) addEdge(from, to int, virtual bool, source Segment) {
+	index := len(graph.edges)
+	graph.edges = append(graph.edges, strokeEdge{from: from, to: to, virtual: virtual, source: source})
+	graph.adjacent[from] = append(graph.adjacent[from], index)
+	graph.adjacent[to] = append(graph.adjacent[to], index)
+}
+
+// Points with an odd number of edges, every trail has to start or finish at one of them
+func (graph *strokeGraph) oddPoints() (odd []int) {
+	for index, edges := range graph.adjacent {
+		if len(edges)%2 == 1 {
+			odd = append(odd, index)
+		}
+	}
+	return
+}
+
+// Draw edges no longer than maxRetrace twice where both their ends are odd, each one makes both ends even and saves a pen lift
+// Shortest edges are retraced first, returns the length retraced
+func (graph *strokeGraph) addRetraces(maxRetrace float64) (retraced float64) {
+	if maxRetrace <= 0 {
+		return 0
+	}
+
+	var candidates []int
+	for index, edge := range graph.edges {
+		if edge.from != edge.to && graph.points[edge.from].DistanceTo(graph.points[edge.to]) <= maxRetrace {
+			candidates = append(candidates, index)
+		}
+	}
+	length := func(index int) float64 {
+		return graph.points[graph.edges[index].from].DistanceTo(graph.points[graph.edges[index].to])
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return length(candidates[i]) < length(candidates[j]) })
+
+	for _, index := range candidates {
+		edge := graph.edges[index]
+		if len(graph.adjacent[edge.from])%2 == 1 && len(graph.adjacent[edge.to])%2 == 1 {
+			graph.addEdge(edge.from, edge.to, false, edge.source)
+			retraced += length(index)
+		}
+	}
+	return
+}
+
+// A point reached by the walk, and the edge it was reached along, -1 for where the walk started
+type strokeStep struct {
+	point, edge int
+}
+
+// Walk every unused edge reachable from start, ending back at start, with Hierholzer's algorithm
+// next holds how far through each point's edges have already been tried, so the whole graph is walked in linear time
+func (graph *strokeGraph) circuit(start int, used []bool, next []int) (circuit []strokeStep) {
+	stack := []strokeStep{{point: start, edge: -1}}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		edges := graph.adjacent[top.point]
+		for next[top.point] < len(edges) && used[edges[next[top.point]]] {
+			next[top.point]++
+		}
+
+		if next[top.point] == len(edges) {
+			circuit = append(circuit, top)
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		edge := edges[next[top.point]]
+		used[edge] = true
+		stack = append(stack, strokeStep{point: graph.edges[edge].other(top.point), edge: edge})
+	}
+
+	for i, j := 0, len(circuit)-1; i < j; i, j = i+1, j-1 {
+		circuit[i], circuit[j] = circuit[j], circuit[i]
+	}
+	return
+}
+
+// Split a closed circuit into glyphs at its virtual edges, the pieces either side of the start are drawn as one
+// Each line keeps the metadata of the segment it was drawn by
+func (graph *strokeGraph) trails(circuit []strokeStep) (glyphs []Glyph) {
+	var trails [][]strokeStep
+	trail := []strokeStep{circuit[0]}
+	for _, step := range circuit[1:] {
+		if graph.edges[step.edge].virtual {
+			trails = append(trails, trail)
+			trail = []strokeStep{step}
+		} else {
+			trail = append(trail, step)
+		}
+	}
+	trails = append(trails, trail)
+
+	// the last trail finishes where the first one starts
+	if len(trails) > 1 {
+		last := trails[len(trails)-1]
+		trails = trails[:len(trails)-1]
+		trails[0] = append(last, trails[0][1:]...)
+	}
+
+	for _, trail := range trails {
+		if len(trail) < 2 {
+			continue
+		}
+		segments := make(Path, len(trail))
+		for index, step := range trail[1:] {
+			segments[index+1] = LineTo(graph.points[step.point]).withSourceOf(graph.edges[step.edge].source)
+		}
+		start := graph.points[trail[0].point]
+		start.PenUp = true
+		segments[0] = LineTo(start).withSourceOf(segments[1])
+		glyphs = append(glyphs, glyphFromSegments(segments))
+	}
+	return
+}
+
+// Redraw the pen down lines of the glyphs as the fewest trails that draw every line, lifting the pen only between trails
+// The glyphs have to be straight lines, curves are only drawn between the ends of their segments
+// Lines up to maxRetrace long are drawn twice where that saves a lift, returns the trails and the length retraced
+// A group of connected lines with 2n points where an odd number of lines meet needs n trails, or one if there are none
+// Lines of different layers or colours are never drawn in the same trail, the trails of each style follow the ones before
+func EulerianGlyphs(glyphs []Glyph, maxRetrace float64) (trails []Glyph, retraced float64) {
+	var styles []drawStyle
+	graphs := make(map[drawStyle]*strokeGraph)
+	for _, glyph := range glyphs {
+		previous := glyph.start()
+		for _, segment := range glyph.Segments[1:] {
+			graph, ok := graphs[segment.style()]
+			if !ok {
+				graph = newStrokeGraph()
+				graphs[segment.style()] = graph
+				styles = append(styles, segment.style())
+			}
+			graph.addEdge(graph.point(previous), graph.point(segment.End), false, segment)
+			previous = segment.End
+		}
+	}
+
+	for _, style := range styles {
+		styleTrails, styleRetraced := graphs[style].eulerianTrails(maxRetrace)
+		trails = append(trails, styleTrails...)
+		retraced += styleRetraced
+	}
+	return trails, retraced
+}
+
+// The fewest trails that draw every edge of the graph, and the length retraced
+func (graph *strokeGraph) eulerianTrails(maxRetrace float64) (trails []Glyph, retraced float64) {
+	retraced = graph.addRetraces(maxRetrace)
+
+	// pair up the odd points with virtual edges so every point is even, each pair is one more trail
+	odd := graph.oddPoints()
+	for index := 0; index+1 < len(odd); index += 2 {
+		graph.addEdge(odd[index], odd[index+1], true, Segment{})
+	}
+
+	// walk circuits until every edge is drawn, groups of lines with no odd points are only reached from their own points
+	used := make([]bool, len(graph.edges))
+	next := make([]int, len(graph.points))
+	walk := func(start int) {
+		if circuit := graph.circuit(start, used, next); len(circuit) > 1 {
+			trails = append(trails, graph.trails(circuit)...)
+		}
+	}
+	for _, start := range odd {
+		walk(start)
+	}
+	for start := range graph.points {
+		walk(start)
+	}
+	return trails, retraced
+}
+
+// Replace the glyphs with Eulerian trails, keeping the point the drawing starts from first
+// The trails are straight lines, curves are flattened to a step so that the lines they share with other glyphs are found
+func eulerianStrategy(glyphs []Glyph) []Glyph {
+	if len(glyphs) == 0 {
+		return glyphs
+	}
+
+	flattened := make([]Glyph, len(glyphs))
+	for index, glyph := range glyphs {
+		flattened[index] = glyph.Flattened(Settings.StepSize_MM)
+	}
+	trails, retraced := EulerianGlyphs(flattened, Settings.Retrace_MM)
+	fmt.Fprintln(Log, "Eulerian trails:", len(glyphs), "glyphs drawn as", len(trails), "trails, retracing", retraced, "mm")
+
+	origin := glyphs[0].start()
+	origin.PenUp = true
+	return append([]Glyph{GlyphFromCoordinates([]Coordinate{origin})}, trails...)
+}
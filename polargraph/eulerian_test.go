@@ -0,0 +1,145 @@
+package polargraph
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"testing"
+)
+
+// Each pen down line of the glyphs, written the same way whichever direction it is drawn in
+func strokeLines(glyphs []Glyph) (lines []string) {
+	for _, glyph := range glyphs {
+		for index := 1; index < len(glyph.Segments); index++ {
+			from, to := glyph.Segments[index-1].End, glyph.Segments[index].End
+			if to.X < from.X || (to.X == from.X && to.Y < from.Y) {
+				from, to = to, from
+			}
+			lines = append(lines, fmt.Sprintf("%.3f,%.3f-%.3f,%.3f", from.X, from.Y, to.X, to.Y))
+		}
+	}
+	sort.Strings(lines)
+	return
+}
+
+// A grid of size by size cells, every cell edge drawn as its own glyph
+func gridGlyphs(size int) (glyphs []Glyph) {
+	for a := 0; a <= size; a++ {
+		for b := 0; b < size; b++ {
+			glyphs = append(glyphs,
+				GlyphFromCoordinates([]Coordinate{{X: float64(b), Y: float64(a), PenUp: true}, {X: float64(b + 1), Y: float64(a)}}),
+				GlyphFromCoordinates([]Coordinate{{X: float64(a), Y: float64(b), PenUp: true}, {X: float64(a), Y: float64(b + 1)}}))
+		}
+	}
+	return
+}
+
+// Check the trails draw exactly the lines of the glyphs, and that they each start with the only pen up
+func checkTrails(t *testing.T, glyphs, trails []Glyph) {
+	for _, trail := range trails {
+		if !trail.start().PenUp {
+			t.Error("Trail doesn't start with the pen up", trail)
+		}
+		for _, segment := range trail.Segments[1:] {
+			if segment.End.PenUp {
+				t.Error("Trail lifts the pen part way", trail)
+			}
+		}
+	}
+
+	expected, actual := strokeLines(glyphs), strokeLines(trails)
+	if len(expected) != len(actual) {
+		t.Fatal("Expected", len(expected), "lines and got", len(actual))
+	}
+	for index := range expected {
+		if expected[index] != actual[index] {
+			t.Fatal("Expected line", expected[index], "and got", actual[index])
+		}
+	}
+}
+
+// A grid has 4 odd points along each side between the corners, so needs half as many trails as it has odd points
+func TestEulerianGrid(t *testing.T) {
+	glyphs := gridGlyphs(5)
+	trails, retraced := EulerianGlyphs(glyphs, 0)
+
+	checkTrails(t, glyphs, trails)
+	if len(trails) != 8 {
+		t.Error("Expected 8 trails and got", len(trails))
+	}
+	if retraced != 0 {
+		t.Error("Expected nothing to be retraced and got", retraced)
+	}
+}
+
+// Closed shapes that don't touch should each be drawn in one trail
+func TestEulerianSeparateLoops(t *testing.T) {
+	var glyphs []Glyph
+	for _, offset := range []float64{0, 10, 20} {
+		// a square, drawn as two halves
+		glyphs = append(glyphs,
+			GlyphFromCoordinates([]Coordinate{{X: offset, Y: 0, PenUp: true}, {X: offset + 5, Y: 0}, {X: offset + 5, Y: 5}}),
+			GlyphFromCoordinates([]Coordinate{{X: offset, Y: 0, PenUp: true}, {X: offset, Y: 5}, {X: offset + 5, Y: 5}}))
+	}
+	// and a dot
+	glyphs = append(glyphs, GlyphFromCoordinates([]Coordinate{{X: 40, Y: 0, PenUp: true}, {X: 40, Y: 0}}))
+
+	trails, _ := EulerianGlyphs(glyphs, 0)
+	checkTrails(t, glyphs, trails)
+	if len(trails) != 4 {
+		t.Error("Expected 4 trails and got", len(trails), trails)
+	}
+}
+
+// An H has 6 odd points, retracing the short crossbar leaves 4
+func TestEulerianRetrace(t *testing.T) {
+	glyphs := []Glyph{
+		GlyphFromCoordinates([]Coordinate{{X: 0, Y: 0, PenUp: true}, {X: 0, Y: 10}, {X: 0, Y: 20}}),
+		GlyphFromCoordinates([]Coordinate{{X: 1, Y: 0, PenUp: true}, {X: 1, Y: 10}, {X: 1, Y: 20}}),
+		GlyphFromCoordinates([]Coordinate{{X: 0, Y: 10, PenUp: true}, {X: 1, Y: 10}}),
+	}
+
+	trails, retraced := EulerianGlyphs(glyphs, 0)
+	if len(trails) != 3 || retraced != 0 {
+		t.Error("Expected 3 trails without retracing and got", len(trails), "retracing", retraced)
+	}
+
+	trails, retraced = EulerianGlyphs(glyphs, 1)
+	if len(trails) != 2 || math.Abs(retraced-1) > 0.000001 {
+		t.Error("Expected 2 trails retracing 1mm and got", len(trails), "retracing", retraced)
+	}
+	if drawn := dedupLength(trails); math.Abs(drawn-42) > 0.000001 {
+		t.Error("Expected 42mm to be drawn and got", drawn)
+	}
+}
+
+// The euler strategy should lift the pen less than greedy for a grid, and still start from the same place
+func TestOptimizeTravelEuler(t *testing.T) {
+	defer func(strategy string, tolerance float64) {
+		Settings.OptimizeStrategy, Settings.MergeTolerance_MM = strategy, tolerance
+	}(Settings.OptimizeStrategy, Settings.MergeTolerance_MM)
+	Settings.MergeTolerance_MM = 0
+
+	input := makePath(t, gridGlyphs(4))
+	lifts := func(path Path) (count int) {
+		for _, segment := range path {
+			if segment.End.PenUp {
+				count++
+			}
+		}
+		return
+	}
+
+	Settings.OptimizeStrategy = "greedy"
+	greedy := optimizeTravel(t, input)
+	Settings.OptimizeStrategy = "euler"
+	euler := optimizeTravel(t, input)
+
+	if lifts(euler) >= lifts(greedy) {
+		t.Error("Expected fewer pen lifts than the", lifts(greedy), "from greedy and got", lifts(euler))
+	}
+	if !euler[0].End.Same(input[0].End) {
+		t.Error("Expected to start at", input[0].End, "and got", euler[0].End)
+	}
+	checkTrails(t, makeGlyphs(t, input), makeGlyphs(t, euler))
+}
@@ -0,0 +1,216 @@
+package polargraph
+
+// Grid over the start and end points of glyphs, used to find the closest remaining glyph without scanning all of them
+
+import (
+	"math"
+)
+
+// A glyph start or end point stored in the grid
+type glyphPoint struct {
+	glyph int // index of the glyph in the slice the index was built from
+	end   bool
+	point Coordinate
+}
+
+// The closest point found by a search
+type glyphMatch struct {
+	glyph      int
+	reversed   bool
+	separation float64
+}
+
+// Whether this match should be picked over other, matching the order of a scan through every glyph:
+// smallest separation, then lowest glyph index, then the start before the end of the same glyph
+func (match glyphMatch) before(other glyphMatch) bool {
+	if match.separation != other.separation {
+		return match.separation < other.separation
+	}
+	if match.glyph != other.glyph {
+		return match.glyph < other.glyph
+	}
+	return !match.reversed && other.reversed
+}
+
+// Uniform grid of cells holding the start and end points of the glyphs that haven't been removed
+type glyphIndex struct {
+	min        Coordinate
+	cellSize   float64
+	cols, rows int
+	cells      [][]glyphPoint
+	removed    []bool
+	remaining  int
+}
+
+// Index the start and end points of every glyph
+func newGlyphIndex(glyphs []Glyph) *glyphIndex {
+	index := &glyphIndex{removed: make([]bool, len(glyphs)), remaining: len(glyphs)}
+
+	points := make([]glyphPoint, 0, 2*len(glyphs))
+	for number, glyph := range glyphs {
+		points = append(points, glyphPoint{glyph: number, point: glyph.start()}, glyphPoint{glyph: number, end: true, point: glyph.end()})
+	}
+	index.build(points)
+	return index
+}
+
+// Put the points into a grid sized so there are about as many cells as glyphs
+func (index *glyphIndex) build(points []glyphPoint) {
+	if len(points) == 0 {
+		index.cols, index.rows, index.cells = 0, 0, nil
+		return
+	}
+
+	index.min = points[0].point
+	max := index.min
+	for _, point := range points {
+		index.min.X, index.min.Y = math.Min(index.min.X, point.point.X), math.Min(index.min.Y, point.point.Y)
+		max.X, max.Y = math.Max(max.X, point.point.X), math.Max(max.Y, point.point.Y)
+	}
+
+	size := max.Minus(index.min)
+	index.cellSize = math.Max(size.X, size.Y) / math.Ceil(math.Sqrt(float64(len(points)/2)))
+	if index.cellSize <= 0 {
+		index.cellSize = 1
+	}
+	index.cols = int(size.X/index.cellSize) + 1
+	index.rows = int(size.Y/index.cellSize) + 1
+	index.cells = make([][]glyphPoint, index.cols*index.rows)
+
+	for _, point := range points {
+		col, row := index.cell(point.point)
+		index.cells[row*index.cols+col] = append(index.cells[row*index.cols+col], point)
+	}
+}
+
+// Column and row of the cell containing point
+func (index *glyphIndex) cell(point Coordinate) (col, row int) {
+	col = int((point.X - index.min.X) / index.cellSize)
+	row = int((point.Y - index.min.Y) / index.cellSize)
+	if col < 0 {
+		col = 0
+	} else if col >= index.cols {
+		col = index.cols - 1
+	}
+	if row < 0 {
+		row = 0
+	} else if row >= index.rows {
+		row = index.rows - 1
+	}
+	return
+}
+
+// Remove both points of a glyph, they are dropped from their cells the next time the cells are searched
+// Once most glyphs are gone the grid is rebuilt smaller, so searches don't spend their time on empty cells
+func (index *glyphIndex) Remove(glyph int) {
+	if index.removed[glyph] {
+		return
+	}
+	index.removed[glyph] = true
+	index.remaining--
+
+	if index.remaining > 0 && 8*index.remaining < len(index.cells) {
+		points := make([]glyphPoint, 0, 2*index.remaining)
+		for _, cell := range index.cells {
+			for _, point := range cell {
+				if !index.removed[point.glyph] {
+					points = append(points, point)
+				}
+			}
+		}
+		index.build(points)
+	}
+}
+
+// Number of glyphs not yet removed
+func (index *glyphIndex) Len() int {
+	return index.remaining
+}
+
+// Check the points in a cell against the best match so far, compacting out removed glyphs
+func (index *glyphIndex) searchCell(col, row int, from Coordinate, best *glyphMatch, found *bool) {
+	cell := index.cells[row*index.cols+col]
+	kept := cell[:0]
+	for _, point := range cell {
+		if index.removed[point.glyph] {
+			continue
+		}
+		kept = append(kept, point)
+
+		match := glyphMatch{glyph: point.glyph, reversed: point.end, separation: from.SeparationFrom(point.point)}
+		if !*found || match.before(*best) {
+			*best, *found = match, true
+		}
+	}
+	index.cells[row*index.cols+col] = kept
+}
+
+// Find the remaining glyph whose start or end is closest to from, by the same measure and tie breaks as SeparationFrom in a full scan
+// Cells are searched in square rings outwards, points in ring r+1 are always further than r cells away so the search stops once the best match is closer
+// That holds even when from is outside the grid and its cell is clamped to the edge, since that only moves it further from every cell
+func (index *glyphIndex) Nearest(from Coordinate) (glyph int, reversed bool, ok bool) {
+	if index.remaining == 0 {
+		return 0, false, false
+	}
+
+	centerCol, centerRow := index.cell(from)
+	var best glyphMatch
+	found := false
+
+	maxRing := index.cols
+	if index.rows > maxRing {
+		maxRing = index.rows
+	}
+	for ring := 0; ring <= maxRing; ring++ {
+		for row := centerRow - ring; row <= centerRow+ring; row++ {
+			if row < 0 || row >= index.rows {
+				continue
+			}
+			// only the edges of the ring, the inside was searched by the smaller rings
+			step := 2 * ring
+			if row == centerRow-ring || row == centerRow+ring || step == 0 {
+				step = 1
+			}
+			for col := centerCol - ring; col <= centerCol+ring; col += step {
+				if col >= 0 && col < index.cols {
+					index.searchCell(col, row, from, &best, &found)
+				}
+			}
+		}
+
+		// less a small margin in case rounding put a point on the boundary into the next cell
+		if found && best.separation < (float64(ring)-0.001)*index.cellSize {
+			break
+		}
+	}
+
+	return best.glyph, best.reversed, found
+}
+
+// Every remaining glyph start or end within radius of from, measured the same way as SeparationFrom
+func (index *glyphIndex) Within(from Coordinate, radius float64) (matches []glyphMatch) {
+	if index.remaining == 0 {
+		return nil
+	}
+
+	minCol, minRow := index.cell(Coordinate{X: from.X - radius, Y: from.Y - radius})
+	maxCol, maxRow := index.cell(Coordinate{X: from.X + radius, Y: from.Y + radius})
+	for row := minRow; row <= maxRow; row++ {
+		for col := minCol; col <= maxCol; col++ {
+			cell := index.cells[row*index.cols+col]
+			kept := cell[:0]
+			for _, point := range cell {
+				if index.removed[point.glyph] {
+					continue
+				}
+				kept = append(kept, point)
+
+				if separation := from.SeparationFrom(point.point); separation <= radius {
+					matches = append(matches, glyphMatch{glyph: point.glyph, reversed: point.end, separation: separation})
+				}
+			}
+			index.cells[row*index.cols+col] = kept
+		}
+	}
+	return matches
+}
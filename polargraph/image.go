@@ -3,16 +3,19 @@ package polargraph
 // Draws a series of coordinates to an image
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
 	_ "image/gif"
 	_ "image/jpeg"
 	"image/png"
+	"math"
 	"os"
+	"strings"
 )
 
-func DrawToImageExact(imageName string, widthMM float64, heightMM float64, plotCoords <-chan Coordinate) {
+func DrawToImageExact(imageName string, widthMM float64, heightMM float64, plotCoords <-chan Coordinate) error {
 	paddingMM := 20.0
 
 	dpi := 150.0
@@ -23,7 +26,7 @@ func DrawToImageExact(imageName string, widthMM float64, heightMM float64, plotC
 	canvasWidth := (widthMM + paddingMM*2) * mmToPixels
 	canvasHeight := (heightMM + paddingMM*2) * mmToPixels
 
-	fmt.Println("Paper ", widthMM, "mm x ", heightMM, "mm. Image ", canvasWidth, "px x", canvasHeight, "px")
+	fmt.Fprintln(Log, "Paper ", widthMM, "mm x ", heightMM, "mm. Image ", canvasWidth, "px x", canvasHeight, "px")
 
 	image := image.NewRGBA(image.Rect(0, 0, int(canvasWidth), int(canvasHeight)))
 
@@ -43,15 +46,16 @@ func DrawToImageExact(imageName string, widthMM float64, heightMM float64, plotC
 		previousPoint = next
 	}
 
-	file, err := os.OpenFile(imageName, os.O_CREATE|os.O_WRONLY, 0666)
+	file, err := os.OpenFile(imageName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer file.Close()
 
 	if err = png.Encode(file, image); err != nil {
-		panic(err)
+		file.Close()
+		return err
 	}
+	return file.Close()
 }
 
 // Draw a line, from http://41j.com/blog/2012/09/bresenhams-line-drawing-algorithm-implemetations-in-go-and-c/
@@ -118,3 +122,40 @@ func drawLineExact(start Coordinate, end Coordinate, image *image.RGBA) {
 		}
 	}
 }
+
+// A pipeline sink that draws the segments to a png image of the paper size given in mm, like the svg's width and height
+// When the size isn't known, because the segments don't come from an svg, the image is sized to fit them instead
+func ImageSink(imageName string, widthMM float64, heightMM float64) Sink {
+	return func(ctx context.Context, in <-chan Segment) error {
+		path, err := CollectPath(ctx, in)
+		if err != nil || len(path) == 0 {
+			return err
+		}
+
+		coords := path.Coordinates(Settings.StepSize_MM)
+		if widthMM <= 0 || heightMM <= 0 {
+			_, maxPoint := coords.Extents()
+			widthMM, heightMM = math.Max(0, maxPoint.X), math.Max(0, maxPoint.Y)
+		}
+
+		fmt.Fprintln(Log, "Outputting to image ", imageName)
+		plotCoords := make(chan Coordinate, len(coords))
+		for _, coord := range coords {
+			plotCoords <- coord
+		}
+		close(plotCoords)
+		return DrawToImageExact(imageName, widthMM, heightMM, plotCoords)
+	}
+}
+
+// Image sink named after and sized like the svg file given as the first command argument
+func imageSinkFromArgs(args []string) (Sink, error) {
+	if len(args) > 0 && strings.HasSuffix(args[0], ".svg") {
+		width, height, err := SvgFileSize(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return ImageSink(strings.TrimSuffix(args[0], ".svg")+".png", width, height), nil
+	}
+	return ImageSink("image.png", 0, 0), nil
+}
@@ -0,0 +1,61 @@
+package polargraph
+
+import (
+	"context"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Draw the path with the sink and return the width and height of the image in pixels
+func drawnImageSize(t testing.TB, sink Sink, imageName string, path Path) (int, int) {
+	if err := NewPipeline(PathSource(path), sink).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(imageName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	drawn, err := png.DecodeConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return drawn.Width, drawn.Height
+}
+
+// The image of an svg should show its whole page, however little of it is drawn on
+func TestImageSinkSvgSize(t *testing.T) {
+	defer func(settings SettingsData) { Settings = settings }(Settings)
+	Settings = goldenSettings("")
+
+	fileName := writeLayeredSvg(t)
+	sink, err := imageSinkFromArgs([]string{fileName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _, _, err := ParseSvgFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 100mm of page and 20mm of padding each side at 150 dpi
+	width, height := drawnImageSize(t, sink, filepath.Join(filepath.Dir(fileName), "layered.png"), data)
+	if width != 826 || height != 826 {
+		t.Error("Expected an 826px square image and got", width, height)
+	}
+}
+
+// Without a page size the image should fit what is drawn
+func TestImageSinkFitsPath(t *testing.T) {
+	defer func(settings SettingsData) { Settings = settings }(Settings)
+	Settings = goldenSettings("")
+
+	imageName := filepath.Join(t.TempDir(), "image.png")
+	width, height := drawnImageSize(t, ImageSink(imageName, 0, 0), imageName, analyzePath())
+	// 30mm by 20mm drawn, with the padding
+	if width != 413 || height != 354 {
+		t.Error("Expected a 413px by 354px image and got", width, height)
+	}
+}
@@ -0,0 +1,186 @@
+package polargraph
+
+// Local search that shortens the pen up travel left by the greedy glyph order, with 2-opt and Or-opt moves
+
+import (
+	"fmt"
+	"time"
+)
+
+// Moves have to save at least this much travel, so rounding can't make them undo each other forever
+const improveEpsilon = 1e-9
+
+// An ordering of glyphs being improved, glyphs are only copied into place once the search is done
+type glyphTour struct {
+	glyphs   []Glyph
+	order    []int  // index into glyphs of the glyph at each position
+	reversed []bool // whether the glyph at each position is drawn from its end to its start
+}
+
+// Where the glyph at position starts being drawn
+func (tour *glyphTour) start(position int) Coordinate {
+	glyph := &tour.glyphs[tour.order[position]]
+	if tour.reversed[position] {
+		return glyph.end()
+	}
+	return glyph.start()
+}
+
+// Where the glyph at position finishes being drawn
+func (tour *glyphTour) end(position int) Coordinate {
+	glyph := &tour.glyphs[tour.order[position]]
+	if tour.reversed[position] {
+		return glyph.start()
+	}
+	return glyph.end()
+}
+
+// Pen up travel from the glyph at position to the next one, nothing after the last glyph
+func (tour *glyphTour) gap(position int) float64 {
+	if position+1 >= len(tour.order) {
+		return 0
+	}
+	return tour.end(position).DistanceTo(tour.start(position + 1))
+}
+
+// Travel from a point to the start of the glyph at position, nothing if there is no glyph there
+func (tour *glyphTour) travelTo(from Coordinate, position int) float64 {
+	if position >= len(tour.order) {
+		return 0
+	}
+	return from.DistanceTo(tour.start(position))
+}
+
+// Reverse the glyphs from first to last, drawing each of them backwards
+func (tour *glyphTour) reverse(first, last int) {
+	for ; first < last; first, last = first+1, last-1 {
+		tour.order[first], tour.order[last] = tour.order[last], tour.order[first]
+		tour.reversed[first], tour.reversed[last] = !tour.reversed[last], !tour.reversed[first]
+	}
+	if first == last {
+		tour.reversed[first] = !tour.reversed[first]
+	}
+}
+
+// Move the length glyphs starting at first to after the glyph at position, reversing them if asked
+func (tour *glyphTour) move(first, length, position int, reverse bool) {
+	order := append([]int{}, tour.order[first:first+length]...)
+	reversed := append([]bool{}, tour.reversed[first:first+length]...)
+	if reverse {
+		for i, j := 0, length-1; i <= j; i, j = i+1, j-1 {
+			order[i], order[j] = order[j], order[i]
+			reversed[i], reversed[j] = !reversed[j], !reversed[i]
+		}
+	}
+
+	remainingOrder := append(append([]int{}, tour.order[:first]...), tour.order[first+length:]...)
+	remainingReversed := append(append([]bool{}, tour.reversed[:first]...), tour.reversed[first+length:]...)
+	insert := position + 1
+	if position > first {
+		insert -= length
+	}
+
+	tour.order = append(append(append(tour.order[:0], remainingOrder[:insert]...), order...), remainingOrder[insert:]...)
+	tour.reversed = append(append(append(tour.reversed[:0], remainingReversed[:insert]...), reversed...), remainingReversed[insert:]...)
+}
+
+// Try reversing every run of glyphs, which replaces the travel into and out of the run, returns whether anything improved
+func (tour *glyphTour) twoOpt(deadline time.Time) (improved bool) {
+	count := len(tour.order)
+	for first := 1; first < count; first++ {
+		if time.Now().After(deadline) {
+			return
+		}
+		for last := first; last < count; last++ {
+			before := tour.gap(first-1) + tour.gap(last)
+			after := tour.end(first-1).DistanceTo(tour.end(last)) + tour.travelTo(tour.start(first), last+1)
+			if after < before-improveEpsilon {
+				tour.reverse(first, last)
+				improved = true
+			}
+		}
+	}
+	return
+}
+
+// Try moving every run of up to 3 glyphs to between two other glyphs, either way round, returns whether anything improved
+func (tour *glyphTour) orOpt(deadline time.Time) (improved bool) {
+	count := len(tour.order)
+	for length := 1; length <= 3; length++ {
+		for first := 1; first+length <= count; first++ {
+			if time.Now().After(deadline) {
+				return
+			}
+
+			last := first + length - 1
+			removed := tour.gap(first-1) + tour.gap(last) - tour.travelTo(tour.end(first-1), last+1)
+			runStart, runEnd := tour.start(first), tour.end(last)
+
+			for position := 0; position < count; position++ {
+				if position >= first-1 && position <= last {
+					continue
+				}
+
+				replaced := tour.gap(position)
+				forward := tour.end(position).DistanceTo(runStart) + tour.travelTo(runEnd, position+1) - replaced
+				backward := tour.end(position).DistanceTo(runEnd) + tour.travelTo(runStart, position+1) - replaced
+				if forward < removed-improveEpsilon && forward <= backward {
+					tour.move(first, length, position, false)
+					improved = true
+					break
+				}
+				if backward < removed-improveEpsilon {
+					tour.move(first, length, position, true)
+					improved = true
+					break
+				}
+			}
+		}
+	}
+	return
+}
+
+// Improve the order of the glyphs with 2-opt and Or-opt moves until no move helps or budget runs out
+// The first glyph stays first, and glyphs that end up within mergeTolerance of each other are merged
+func ImproveGlyphOrder(glyphs []Glyph, budget time.Duration, mergeTolerance float64) (improved []Glyph) {
+	if len(glyphs) < 3 || budget <= 0 {
+		return glyphs
+	}
+
+	penUpDistanceBefore := TotalPenUpTravelForGlyphs(glyphs)
+	fmt.Fprintln(Log, "Improving order for up to", budget, "starting penUp distance:", penUpDistanceBefore)
+
+	tour := glyphTour{glyphs: glyphs, order: make([]int, len(glyphs)), reversed: make([]bool, len(glyphs))}
+	for index := range tour.order {
+		tour.order[index] = index
+	}
+
+	deadline := time.Now().Add(budget)
+	passes := 0
+	for time.Now().Before(deadline) {
+		passes++
+		twoOptImproved := tour.twoOpt(deadline)
+		orOptImproved := tour.orOpt(deadline)
+		if !twoOptImproved && !orOptImproved {
+			break
+		}
+	}
+
+	for position, index := range tour.order {
+		next := glyphs[index]
+		if tour.reversed[position] {
+			next = next.Reversed()
+		}
+
+		if position > 0 && improved[len(improved)-1].CanBeMergedWith(next, mergeTolerance) {
+			improved[len(improved)-1] = improved[len(improved)-1].MergeWith(next)
+		} else {
+			improved = append(improved, next)
+		}
+	}
+
+	penUpDistanceAfter := TotalPenUpTravelForGlyphs(improved)
+	fmt.Fprintln(Log, "Done after", passes, "passes, penUp distance:", penUpDistanceAfter, "reduced to", (penUpDistanceAfter/penUpDistanceBefore)*100, "%")
+
+	return improved
+}
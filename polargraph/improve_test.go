@@ -0,0 +1,83 @@
+package polargraph
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Every pen down line drawn by the glyphs, sorted and with their direction ignored so orders can be compared
+func drawnLines(glyphs []Glyph) []string {
+	var lines []string
+	for _, glyph := range glyphs {
+		for index := 1; index < len(glyph.Segments); index++ {
+			from, to := glyph.Segments[index-1].End, glyph.Segments[index].End
+			if from.X == to.X && from.Y == to.Y {
+				// merging glyphs that touch repeats the point where they meet
+				continue
+			}
+			if to.X < from.X || (to.X == from.X && to.Y < from.Y) {
+				from, to = to, from
+			}
+			lines = append(lines, fmt.Sprint(from.X, from.Y, to.X, to.Y))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// A row of glyphs visited out and back should be straightened out
+func TestImproveGlyphOrderRow(t *testing.T) {
+	var glyphs []Glyph
+	glyphs = append(glyphs, GlyphFromCoordinates([]Coordinate{{X: 0, Y: 0, PenUp: true}}))
+	for _, x := range []float64{10, 30, 50, 40, 20} {
+		glyphs = append(glyphs, GlyphFromCoordinates([]Coordinate{{X: x, Y: 0, PenUp: true}, {X: x + 5, Y: 0}}))
+	}
+
+	improved := ImproveGlyphOrder(copyGlyphs(glyphs), time.Second, 0)
+
+	if travel := TotalPenUpTravelForGlyphs(improved); math.Abs(travel-30) > 0.000001 {
+		t.Error("Expected pen up travel of 30 and got", travel, improved)
+	}
+	if !improved[0].Equals(glyphs[0]) {
+		t.Error("Expected the first glyph to stay first and got", improved[0])
+	}
+}
+
+// Improving should never add travel, and should draw exactly the same lines
+func TestImproveGlyphOrderRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+	for trial := 0; trial < 10; trial++ {
+		glyphs := reorderGlyphs(t, randomGlyphs(random, 2+random.Intn(200), 100), 0)
+		before := TotalPenUpTravelForGlyphs(glyphs)
+		expectedLines := drawnLines(glyphs)
+		first := glyphs[0].withSegments(append(Path{}, glyphs[0].Segments...))
+
+		improved := ImproveGlyphOrder(copyGlyphs(glyphs), time.Second, 0)
+
+		if after := TotalPenUpTravelForGlyphs(improved); after > before+0.000001 {
+			t.Error("Trial", trial, "pen up travel went from", before, "to", after)
+		}
+		if !improved[0].start().Equals(first.start()) {
+			t.Error("Trial", trial, "expected to start at", first.start(), "and got", improved[0].start())
+		}
+		for index, glyph := range improved {
+			if !glyph.start().PenUp {
+				t.Error("Trial", trial, "glyph", index, "starts without pen up")
+			}
+		}
+
+		lines := drawnLines(improved)
+		if len(lines) != len(expectedLines) {
+			t.Fatal("Trial", trial, "expected", len(expectedLines), "lines and got", len(lines))
+		}
+		for index := range lines {
+			if lines[index] != expectedLines[index] {
+				t.Fatal("Trial", trial, "expected line", expectedLines[index], "and got", lines[index])
+			}
+		}
+	}
+}
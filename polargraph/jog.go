@@ -0,0 +1,242 @@
+package polargraph
+
+// Interactive keyboard control of the pen position in X,Y
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Step sizes selectable with the number keys
+var jogStepSizes = []float64{0.1, 1, 10, 50, 100}
+
+// State of the pen while jogging
+type jogState struct {
+	system   PolarSystem
+	position Coordinate  // current position of the pen relative to the origin
+	steps    *stepWriter // keeps track of the spool position, used to calculate steps
+	stepSize float64
+	interp   TrapezoidInterpolater
+	stepData chan<- int8
+	sleep    func(time.Duration) // waits for the arduino to run the steps sent
+}
+
+// Put the terminal into cbreak mode so single key presses can be read, or restore it
+func setTerminalCbreak(enabled bool) {
+	args := []string{"-cbreak", "echo"}
+	if enabled {
+		args = []string{"cbreak", "-echo"}
+	}
+
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	if err := cmd.Run(); err != nil {
+		fmt.Println("WARNING: unable to change terminal mode:", err)
+	}
+}
+
+// Current position as cord lengths
+func (jog *jogState) polar() PolarCoordinate {
+	return jog.position.ToPolar(jog.system)
+}
+
+// Move in a straight line to the given position
+func (jog *jogState) moveTo(target Coordinate) {
+	target.PenUp = jog.position.PenUp
+
+	absolute := Coordinate{X: target.X + jog.system.XOffset, Y: target.Y + jog.system.YOffset}
+	if absolute.X < jog.system.XMin || absolute.X > jog.system.XMax || absolute.Y < jog.system.YMin || absolute.Y > jog.system.YMax {
+		fmt.Println("Position", target, "is outside of the drawing surface")
+		return
+	}
+
+	speed, _, _ := Settings.MotionLimits(target.PenUp)
+	jog.interp.Setup(jog.position, LineTo(target), 0, speed, 0)
+	slices := jog.steps.slices
+	jog.steps.writeMove(&jog.interp, jog.system)
+	jog.position = target
+	jog.wait(float64(jog.steps.slices-slices) * TimeSlice_US)
+
+	fmt.Println("Position", jog.position, jog.polar())
+}
+
+// Raise or lower the pen
+func (jog *jogState) togglePen() {
+	jog.position.PenUp = !jog.position.PenUp
+
+	// send twice in order to preserve alignment of always sending 2 values at a time over serial
+	command := PenDownCommand
+	if jog.position.PenUp {
+		command = PenUpCommand
+	}
+	jog.stepData <- command
+	jog.stepData <- command
+	jog.wait(PenTransitionCooldown_US)
+
+	fmt.Println("Pen up:", jog.position.PenUp)
+}
+
+// Make the current position the new 0,0 and the starting position for future plots
+func (jog *jogState) setOrigin() {
+	polar := jog.polar()
+	jog.system.XOffset += jog.position.X
+	jog.system.YOffset += jog.position.Y
+	jog.position.X, jog.position.Y = 0, 0
+
+	Settings.StartingLeftDist_MM = polar.LeftDist
+	Settings.StartingRightDist_MM = polar.RightDist
+	fmt.Println("Origin set to", polar)
+}
+
+// Save the current position into the given slot
+func (jog *jogState) savePosition(slot int) {
+	polar := jog.polar()
+	saved := SavedPosition{Slot: slot, LeftDist: polar.LeftDist, RightDist: polar.RightDist}
+
+	for index := range Settings.JogPresets {
+		if Settings.JogPresets[index].Slot == slot {
+			Settings.JogPresets[index] = saved
+			fmt.Println("Saved", polar, "to slot", slot)
+			return
+		}
+	}
+	Settings.JogPresets = append(Settings.JogPresets, saved)
+	fmt.Println("Saved", polar, "to slot", slot)
+}
+
+// Move to the position saved in the given slot
+func (jog *jogState) gotoPosition(slot int) {
+	for _, saved := range Settings.JogPresets {
+		if saved.Slot == slot {
+			jog.moveTo(PolarCoordinate{LeftDist: saved.LeftDist, RightDist: saved.RightDist}.ToCoord(jog.system))
+			return
+		}
+	}
+	fmt.Println("No position saved in slot", slot)
+}
+
+// Wait for the steps just sent to be run, so the arduino's buffer has drained before the next key is read
+func (jog *jogState) wait(duration_US float64) {
+	jog.sleep(time.Duration(duration_US) * time.Microsecond)
+}
+
+// Control the pen with the keyboard, arrow keys move it in X,Y
+func InteractiveJog() error {
+
+	start := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}
+	system := PolarSystemFromSettings()
+	startingLocation := start.ToCoord(system)
+	if startingLocation.IsNaN() {
+		return errors.New("Starting location is not a valid number, setup has impossible values")
+	}
+	system.XOffset = startingLocation.X
+	system.YOffset = startingLocation.Y
+
+	stepData := make(chan int8, 1024)
+	steps, err := newStepWriter(&Settings, WoundSpoolFromSettings(), start, StepChannel(stepData))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(`Jog mode:
+	arrow keys - move pen
+	1-5 - select step size of 0.1, 1, 10, 50 or 100 mm
+	space - raise / lower pen
+	o - set origin here
+	s N - save position to slot N (0-9)
+	g N - go to position saved in slot N (0-9)
+	q - quit and save position`)
+
+	writerDone := make(chan error)
+	go func() {
+		err := WriteStepsToSerial(stepData)
+		for range stepData {
+		}
+		writerDone <- err
+	}()
+
+	jog := jogState{
+		system:   system,
+		interp:   TrapezoidInterpolater{settings: &Settings},
+		position: Coordinate{X: 0, Y: 0, PenUp: true}, // arduino code defaults to pen up on ResetCommand
+		steps:    steps,
+		stepSize: jogStepSizes[1],
+		stepData: stepData,
+		sleep:    time.Sleep,
+	}
+
+	setTerminalCbreak(true)
+	defer setTerminalCbreak(false)
+
+	reader := bufio.NewReader(os.Stdin)
+	readSlot := func() (int, bool) {
+		key, err := reader.ReadByte()
+		if err != nil || key < '0' || key > '9' {
+			fmt.Println("Expected a slot number 0-9")
+			return 0, false
+		}
+		return int(key - '0'), true
+	}
+
+	for running := true; running; {
+		key, err := reader.ReadByte()
+		if err != nil {
+			break
+		}
+
+		switch {
+		case key == 0x1b: // escape sequence, arrow keys are ESC [ A-D
+			if next, _ := reader.ReadByte(); next != '[' {
+				continue
+			}
+			arrow, _ := reader.ReadByte()
+			switch arrow {
+			case 'A':
+				jog.moveTo(jog.position.Add(Coordinate{X: 0, Y: -jog.stepSize}))
+			case 'B':
+				jog.moveTo(jog.position.Add(Coordinate{X: 0, Y: jog.stepSize}))
+			case 'C':
+				jog.moveTo(jog.position.Add(Coordinate{X: jog.stepSize, Y: 0}))
+			case 'D':
+				jog.moveTo(jog.position.Add(Coordinate{X: -jog.stepSize, Y: 0}))
+			}
+		case key >= '1' && int(key-'1') < len(jogStepSizes):
+			jog.stepSize = jogStepSizes[key-'1']
+			fmt.Println("Step size", jog.stepSize, "mm")
+		case key == ' ':
+			jog.togglePen()
+		case key == 'o':
+			jog.setOrigin()
+		case key == 's':
+			if slot, ok := readSlot(); ok {
+				jog.savePosition(slot)
+			}
+		case key == 'g':
+			if slot, ok := readSlot(); ok {
+				jog.gotoPosition(slot)
+			}
+		case key == 'q':
+			running = false
+		}
+	}
+
+	if !jog.position.PenUp {
+		jog.togglePen()
+	}
+	close(stepData)
+	writerErr := <-writerDone
+
+	// persist where the pen ended up so the next command starts from the right place
+	polar := jog.polar()
+	Settings.StartingLeftDist_MM = polar.LeftDist
+	Settings.StartingRightDist_MM = polar.RightDist
+	if err := Settings.Write(); err != nil {
+		return err
+	}
+	fmt.Println("Saved position", polar)
+	return writerErr
+}
@@ -0,0 +1,133 @@
+package polargraph
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// A jog state at the settings' starting position, recording its steps and pen commands and adding up its waits instead of sleeping
+func testJogState(t testing.TB) (*jogState, *stepRecorder, chan int8, *time.Duration) {
+	start := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}
+	system := PolarSystemFromSettings()
+	startingLocation := start.ToCoord(system)
+	system.XOffset = startingLocation.X
+	system.YOffset = startingLocation.Y
+
+	recorder := &stepRecorder{}
+	steps, err := newStepWriter(&Settings, WoundSpoolFromSettings(), start, recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	penCommands := make(chan int8, 16)
+	waited := new(time.Duration)
+	return &jogState{
+		system:   system,
+		interp:   TrapezoidInterpolater{settings: &Settings},
+		position: Coordinate{X: 0, Y: 0, PenUp: true},
+		steps:    steps,
+		stepSize: jogStepSizes[1],
+		stepData: penCommands,
+		sleep:    func(duration time.Duration) { *waited += duration },
+	}, recorder, penCommands, waited
+}
+
+// Cord let out by the recorded steps, left then right
+func recordedCordChange(recorder *stepRecorder) PolarCoordinate {
+	var change PolarCoordinate
+	for index := 0; index+1 < len(*recorder); index += 2 {
+		change.LeftDist -= float64((*recorder)[index])
+		change.RightDist += float64((*recorder)[index+1])
+	}
+	return change.Scaled(Settings.StepSize_MM / StepsFixedPointFactor)
+}
+
+// Settings for a jog test, with room to move around the starting position
+func jogSettings() SettingsData {
+	settings := goldenSettings("")
+	settings.DrawingSurfaceMaxX_MM = 975
+	return settings
+}
+
+// Moving should send the steps for the change in cord lengths, and moves off the drawing surface should be refused
+func TestJogMove(t *testing.T) {
+	defer func(settings SettingsData) { Settings = settings }(Settings)
+	Settings = jogSettings()
+
+	jog, recorder, _, waited := testJogState(t)
+	before := jog.polar()
+	jog.moveTo(Coordinate{X: 10, Y: -5})
+	if jog.position.X != 10 || jog.position.Y != -5 || !jog.position.PenUp {
+		t.Error("Expected the pen up at 10, -5 and got", jog.position)
+	}
+
+	expected := jog.polar().Minus(before)
+	change := recordedCordChange(recorder)
+	if math.Abs(change.LeftDist-expected.LeftDist) > Settings.StepSize_MM || math.Abs(change.RightDist-expected.RightDist) > Settings.StepSize_MM {
+		t.Error("Expected the steps to let out", expected, "and got", change)
+	}
+	// the next key is only read once the arduino has run the move
+	if slices := len(*recorder) / 2; *waited != time.Duration(float64(slices)*TimeSlice_US)*time.Microsecond {
+		t.Error("Expected to wait for", slices, "slices and waited", *waited)
+	}
+
+	sent := len(*recorder)
+	jog.moveTo(Coordinate{X: 10, Y: -1000})
+	if len(*recorder) != sent || jog.position.Y != -5 {
+		t.Error("Expected a move off the drawing surface to be refused and got", jog.position, len(*recorder)-sent, "steps")
+	}
+}
+
+// The pen commands are sent twice so the steps stay in pairs
+func TestJogTogglePen(t *testing.T) {
+	defer func(settings SettingsData) { Settings = settings }(Settings)
+	Settings = jogSettings()
+
+	jog, _, penCommands, waited := testJogState(t)
+	jog.togglePen()
+	if jog.position.PenUp || len(penCommands) != 2 || <-penCommands != PenDownCommand || <-penCommands != PenDownCommand {
+		t.Error("Expected the pen down command sent twice and got", jog.position)
+	}
+	if *waited != time.Duration(PenTransitionCooldown_US)*time.Microsecond {
+		t.Error("Expected to wait for the pen to move and waited", *waited)
+	}
+}
+
+// Saving a slot again should replace it, and going to a slot should move back to where it was saved
+func TestJogSaveAndGotoPosition(t *testing.T) {
+	defer func(settings SettingsData) { Settings = settings }(Settings)
+	Settings = jogSettings()
+	Settings.JogPresets = []SavedPosition{{Slot: 1, LeftDist: 500, RightDist: 500}}
+
+	jog, recorder, _, _ := testJogState(t)
+	jog.moveTo(Coordinate{X: 20, Y: 0})
+	jog.savePosition(3)
+	jog.moveTo(Coordinate{X: 5, Y: 10})
+	jog.savePosition(3)
+	saved := jog.polar()
+
+	if len(Settings.JogPresets) != 2 || Settings.JogPresets[0].LeftDist != 500 || Settings.JogPresets[1].Slot != 3 {
+		t.Fatal("Expected slot 1 kept and slot 3 saved once and got", Settings.JogPresets)
+	}
+	if Settings.JogPresets[1].LeftDist != saved.LeftDist || Settings.JogPresets[1].RightDist != saved.RightDist {
+		t.Error("Expected slot 3 to hold", saved, "and got", Settings.JogPresets[1])
+	}
+
+	jog.moveTo(Coordinate{X: -30, Y: 40})
+	jog.gotoPosition(3)
+	if math.Abs(jog.position.X-5) > 0.000001 || math.Abs(jog.position.Y-10) > 0.000001 {
+		t.Error("Expected to go back to 5, 10 and got", jog.position)
+	}
+	change := recordedCordChange(recorder)
+
+	sent := len(*recorder)
+	jog.gotoPosition(7)
+	if len(*recorder) != sent {
+		t.Error("Expected no move for an empty slot")
+	}
+	// every move started where the last one finished, so the steps add up to the cord change since the start
+	expected := saved.Minus(PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM})
+	if math.Abs(change.LeftDist-expected.LeftDist) > Settings.StepSize_MM || math.Abs(change.RightDist-expected.RightDist) > Settings.StepSize_MM {
+		t.Error("Expected the steps to let out", expected, "and got", change)
+	}
+}
@@ -0,0 +1,179 @@
+package polargraph
+
+// Reads relative mouse movement from a linux evdev device and uses it to directly control the pen
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"strconv"
+)
+
+// Event types and codes from linux/input-event-codes.h
+const (
+	evSyn uint16 = 0x00
+	evKey uint16 = 0x01
+	evRel uint16 = 0x02
+
+	relX uint16 = 0x00
+	relY uint16 = 0x01
+
+	btnLeft   uint16 = 0x110
+	btnRight  uint16 = 0x111
+	btnMiddle uint16 = 0x112
+)
+
+// Size of struct input_event, a timeval of two longs followed by type, code and value
+var mouseEventSize = 2*(bits.UintSize/8) + 8
+
+// A single event read from an evdev device
+type MouseEvent struct {
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// Settings used to turn mouse movement into pen movement
+type MouseControl struct {
+	// Distance in mm the pen moves for each count of mouse movement
+	Scale float64
+
+	// Bounds the pen is kept within, relative to the starting position
+	MinPoint, MaxPoint Coordinate
+}
+
+// Create a MouseControl that keeps the pen within the drawing surface
+func MouseControlFromSettings(scale float64) MouseControl {
+	system := PolarSystemFromSettings()
+	start := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}.ToCoord(system)
+
+	return MouseControl{
+		Scale:    scale,
+		MinPoint: Coordinate{X: system.XMin - start.X, Y: system.YMin - start.Y},
+		MaxPoint: Coordinate{X: system.XMax - start.X, Y: system.YMax - start.Y},
+	}
+}
+
+// Read events from an evdev device or a file recorded from one, closes the channel when the reader is exhausted
+func ReadMouseEvents(reader io.Reader, events chan<- MouseEvent) {
+	defer close(events)
+
+	buffer := make([]byte, mouseEventSize)
+	for {
+		if _, err := io.ReadFull(reader, buffer); err != nil {
+			if err != io.EOF {
+				fmt.Fprintln(Log, "Stopped reading mouse events:", err)
+			}
+			return
+		}
+
+		// skip the timeval at the start of the event
+		data := buffer[mouseEventSize-8:]
+		events <- MouseEvent{
+			Type:  binary.LittleEndian.Uint16(data[0:2]),
+			Code:  binary.LittleEndian.Uint16(data[2:4]),
+			Value: int32(binary.LittleEndian.Uint32(data[4:8])),
+		}
+	}
+}
+
+// Converts mouse events into plot coordinates, the left button raises / lowers the pen and the right or middle button stops
+// The last move is drawn once the mouse stops because GenerateSteps flushes its look ahead window when no more coordinates come
+func (mouse MouseControl) GeneratePath(events <-chan MouseEvent, plotCoords chan<- Coordinate) {
+	defer close(plotCoords)
+
+	position := Coordinate{X: 0, Y: 0, PenUp: true}
+	plotCoords <- position
+
+	var deltaX, deltaY float64
+	for event := range events {
+		switch event.Type {
+		case evRel:
+			if event.Code == relX {
+				deltaX += float64(event.Value)
+			} else if event.Code == relY {
+				deltaY += float64(event.Value)
+			}
+
+		case evKey:
+			if event.Value != 1 { // only act on button presses, not releases or repeats
+				continue
+			}
+			switch event.Code {
+			case btnLeft:
+				position.PenUp = !position.PenUp
+				plotCoords <- position
+			case btnRight, btnMiddle:
+				fmt.Fprintln(Log, "Stop button pressed")
+				position.PenUp = true
+				plotCoords <- position
+				return
+			}
+
+		case evSyn:
+			// movement is reported in a group ending with a sync event
+			if deltaX == 0 && deltaY == 0 {
+				continue
+			}
+			position.X = math.Min(mouse.MaxPoint.X, math.Max(mouse.MinPoint.X, position.X+deltaX*mouse.Scale))
+			position.Y = math.Min(mouse.MaxPoint.Y, math.Max(mouse.MinPoint.Y, position.Y+deltaY*mouse.Scale))
+			deltaX, deltaY = 0, 0
+
+			plotCoords <- position
+		}
+	}
+
+	position.PenUp = true
+	plotCoords <- position
+}
+
+// A pipeline source that moves the pen with the mouse events read from eventPath, scale is the mm moved per count
+func MouseSource(eventPath string, scale float64) Source {
+	return func(ctx context.Context, out chan<- Segment) error {
+		fmt.Fprintln(Log, "Reading mouse events from", eventPath)
+		eventFile, err := os.Open(eventPath)
+		if err != nil {
+			return err
+		}
+
+		mouseEvents := make(chan MouseEvent, 1024)
+		plotCoords := make(chan Coordinate, 1024)
+		go ReadMouseEvents(eventFile, mouseEvents)
+		go MouseControlFromSettings(scale).GeneratePath(mouseEvents, plotCoords)
+
+		// closing the file stops the reader, which lets GeneratePath finish and close plotCoords
+		defer func() {
+			eventFile.Close()
+			for range plotCoords {
+			}
+		}()
+
+		for coord := range plotCoords {
+			if err := SendSegment(ctx, out, LineTo(coord)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Mouse source from the command arguments [scale] [path]
+func mouseSourceFromArgs(args []string) (Source, error) {
+	scale := 0.1
+	if len(args) > 0 {
+		var err error
+		if scale, err = strconv.ParseFloat(args[0], 64); err != nil || scale == 0 {
+			return nil, fmt.Errorf("Unable to parse %s as a non zero scale", args[0])
+		}
+	}
+
+	eventPath := Settings.MousePath
+	if len(args) > 1 {
+		eventPath = args[1]
+	}
+	return MouseSource(eventPath, scale), nil
+}
@@ -0,0 +1,68 @@
+package polargraph
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Encode events the same way the kernel writes them, with an empty timeval
+func recordMouseEvents(events []MouseEvent) *bytes.Buffer {
+	buffer := new(bytes.Buffer)
+	for _, event := range events {
+		buffer.Write(make([]byte, mouseEventSize-8))
+		binary.Write(buffer, binary.LittleEndian, event)
+	}
+	return buffer
+}
+
+// Recorded movement should be read back and turned into coordinates
+func TestMouseGeneratePath(t *testing.T) {
+	recorded := recordMouseEvents([]MouseEvent{
+		{Type: evRel, Code: relX, Value: 10},
+		{Type: evRel, Code: relY, Value: 4},
+		{Type: evSyn},
+		{Type: evKey, Code: btnLeft, Value: 1},
+		{Type: evSyn},
+		{Type: evKey, Code: btnLeft, Value: 0},
+		{Type: evSyn},
+		{Type: evRel, Code: relX, Value: -30},
+		{Type: evSyn},
+		{Type: evKey, Code: btnRight, Value: 1},
+		{Type: evRel, Code: relX, Value: 10},
+		{Type: evSyn},
+	})
+
+	mouse := MouseControl{
+		Scale:    0.5,
+		MinPoint: Coordinate{X: -5, Y: -100},
+		MaxPoint: Coordinate{X: 100, Y: 100},
+	}
+
+	events := make(chan MouseEvent, 100)
+	plotCoords := make(chan Coordinate, 100)
+	ReadMouseEvents(recorded, events)
+	mouse.GeneratePath(events, plotCoords)
+
+	expected := []Coordinate{
+		{X: 0, Y: 0, PenUp: true},
+		{X: 5, Y: 2, PenUp: true},
+		{X: 5, Y: 2, PenUp: false},
+		{X: -5, Y: 2, PenUp: false}, // clamped to MinPoint
+		{X: -5, Y: 2, PenUp: true},
+	}
+
+	index := 0
+	for coord := range plotCoords {
+		if index >= len(expected) {
+			t.Fatal("Unexpected extra coordinate", coord)
+		}
+		if !coord.Equals(expected[index]) {
+			t.Error("Expected", expected[index], "and got", coord)
+		}
+		index++
+	}
+	if index != len(expected) {
+		t.Error("Expected", len(expected), "coordinates and got", index)
+	}
+}
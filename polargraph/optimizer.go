@@ -1,57 +1,115 @@
 package polargraph
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"math"
+	"time"
 )
 
+// A run of segments drawn without lifting the pen, the first one is the pen up move to where it starts
+// Glyphs are values, no method changes a glyph or shares its segments with the glyph it returns
 type Glyph struct {
-	Coordinates []Coordinate
+	Segments Path
+
+	// Layer and Color the glyph was drawn with, glyphs are only merged with others of the same layer and colour
+	Layer, Color string
+
+	// Whether the glyph finishes where it starts
+	Closed bool
+
+	// Id of the element of the source drawing the glyph came from, blank if not known
+	SourceID string
+}
+
+// What a glyph or segment is drawn with, glyphs drawn differently are never joined
+type drawStyle struct {
+	layer, color string
+}
+
+func (g Glyph) style() drawStyle {
+	return drawStyle{layer: g.Layer, color: g.Color}
+}
+
+func (segment Segment) style() drawStyle {
+	return drawStyle{layer: segment.Layer, color: segment.Color}
+}
+
+// Glyph drawn with straight lines through coordinates, the first coordinate is where it starts
+func GlyphFromCoordinates(coordinates []Coordinate) Glyph {
+	return Glyph{}.withSegments(PathFromCoordinates(coordinates))
+}
+
+// Glyph drawn through segments, with the metadata of the first one drawn with the pen down
+// segments must not be shared with another glyph
+func glyphFromSegments(segments Path) Glyph {
+	first := segments[0]
+	if len(segments) > 1 {
+		first = segments[1]
+	}
+	return Glyph{Layer: first.Layer, Color: first.Color, SourceID: first.SourceID}.withSegments(segments)
+}
+
+// A straight line to end with the metadata of the glyph
+func (g Glyph) lineTo(end Coordinate) Segment {
+	return Segment{Kind: LineKind, End: end, Layer: g.Layer, Color: g.Color, SourceID: g.SourceID}
+}
+
+// Glyph drawn through segments with the metadata of this one, segments must not be shared with another glyph
+func (g Glyph) withSegments(segments Path) Glyph {
+	g.Segments = segments
+
+	// a single straight line can only finish where it starts as a dot
+	g.Closed = len(segments) > 1 && segments[0].End.Same(segments[len(segments)-1].End) && (len(segments) > 2 || segments[1].Kind != LineKind)
+	return g
 }
 
-func (g *Glyph) start() Coordinate {
-	return g.Coordinates[0]
+func (g Glyph) start() Coordinate {
+	return g.Segments[0].End
 }
 
-func (g *Glyph) end() Coordinate {
-	return g.Coordinates[len(g.Coordinates)-1]
+func (g Glyph) end() Coordinate {
+	return g.Segments[len(g.Segments)-1].End
 }
 
 // Not real distance, but much faster to calculate
-func (g *Glyph) SeparationFrom(other Glyph) float64 {
+func (g Glyph) SeparationFrom(other Glyph) float64 {
 	return g.end().SeparationFrom(other.start())
 }
 
-func (g *Glyph) SeparationFromReversed(other Glyph) float64 {
+func (g Glyph) SeparationFromReversed(other Glyph) float64 {
 	return g.end().SeparationFrom(other.end())
 }
 
-func (g *Glyph) DistanceTo(other Glyph) float64 {
+func (g Glyph) DistanceTo(other Glyph) float64 {
 	return g.end().DistanceTo(other.start())
 }
 
-func (g *Glyph) DistanceToReversed(other Glyph) float64 {
+func (g Glyph) DistanceToReversed(other Glyph) float64 {
 	return g.end().DistanceTo(other.end())
 }
 
-func (g *Glyph) Length() float64 {
+// Distance drawn along the segments
+func (g Glyph) Length() float64 {
 	length := 0.0
-	for i := 1; i < len(g.Coordinates); i++ {
-		prev := g.Coordinates[i-1]
-		cur := g.Coordinates[i]
-		length += prev.DistanceTo(cur)
+	for i := 1; i < len(g.Segments); i++ {
+		length += newSegmentPath(g.Segments[i-1].End, g.Segments[i]).Length()
 	}
 
 	return length
 }
 
-func (g *Glyph) Equals(other Glyph) bool {
-	if len(g.Coordinates) != len(other.Coordinates) {
+// Whether other has the same segments and metadata
+func (g Glyph) Equals(other Glyph) bool {
+	if len(g.Segments) != len(other.Segments) {
+		return false
+	}
+	if g.Layer != other.Layer || g.Color != other.Color || g.Closed != other.Closed || g.SourceID != other.SourceID {
 		return false
 	}
 
-	for i := 0; i < len(g.Coordinates); i++ {
-		if g.Coordinates[i] != other.Coordinates[i] {
+	for i := 0; i < len(g.Segments); i++ {
+		if g.Segments[i] != other.Segments[i] {
 			return false
 		}
 
@@ -60,34 +118,53 @@ func (g *Glyph) Equals(other Glyph) bool {
 	return true
 }
 
-func (g *Glyph) Reversed() Glyph {
-	reversed := make([]Coordinate, len(g.Coordinates))
+// The glyph drawn from its end back to its start, curves stay curves
+func (g Glyph) Reversed() Glyph {
+	reversed := make(Path, len(g.Segments))
 
-	for i := 0; i < len(g.Coordinates); i++ {
-		this := g.Coordinates[i]
-		if this.PenUp {
-			this.PenUp = false
-		}
-		opp := len(g.Coordinates) - 1 - i
-		reversed[opp] = this
+	start := g.end()
+	start.PenUp = true
+	reversed[0] = g.lineTo(start)
+	for i := 1; i < len(g.Segments); i++ {
+		reversed[len(g.Segments)-i] = g.Segments[i].Reversed(g.Segments[i-1].End)
 	}
 
-	reversed[0].PenUp = true
+	g.Segments = reversed
+	return g
+}
 
-	return Glyph{Coordinates: reversed}
+// The glyph drawn with straight lines only, curves are flattened to within tolerance
+// The lines keep the metadata of the segment they were flattened from
+func (g Glyph) Flattened(tolerance float64) Glyph {
+	flattened := Path{g.Segments[0]}
+	for index := 1; index < len(g.Segments); index++ {
+		segment := g.Segments[index]
+		for _, coord := range segment.Flatten(g.Segments[index-1].End, tolerance) {
+			flattened = append(flattened, LineTo(coord).withSourceOf(segment))
+		}
+	}
+	return g.withSegments(flattened)
 }
 
-func (g *Glyph) CanBeMergedWith(other Glyph) bool {
-	return g.end().Same(other.start())
+// Whether other is drawn the same way and starts where this glyph ends, or within tolerance of it
+func (g Glyph) CanBeMergedWith(other Glyph, tolerance float64) bool {
+	if g.style() != other.style() {
+		return false
+	}
+	return g.end().Same(other.start()) || g.end().DistanceTo(other.start()) <= tolerance
 }
 
-func (g *Glyph) MergeWith(other Glyph) Glyph {
-	otherCoords := other.Coordinates
-	otherCoords[0].PenUp = false
-	theseCoords := make([]Coordinate, len(g.Coordinates))
-	copy(theseCoords, g.Coordinates)
-	coordinates := append(theseCoords, otherCoords...)
-	return Glyph{Coordinates: coordinates}
+// Draw other straight after this glyph without lifting the pen, joining the ends with a line if they are apart
+// The merged glyph keeps the metadata of this one
+func (g Glyph) MergeWith(other Glyph) Glyph {
+	segments := make(Path, 0, len(g.Segments)+len(other.Segments))
+	segments = append(segments, g.Segments...)
+	segments = append(segments, other.Segments...)
+
+	join := other.start()
+	join.PenUp = false
+	segments[len(g.Segments)] = other.lineTo(join)
+	return g.withSegments(segments)
 }
 
 func TotalTravelForGlyphs(glyphs []Glyph) float64 {
@@ -113,84 +190,221 @@ func TotalPenUpTravelForGlyphs(glyphs []Glyph) float64 {
 	return total
 }
 
-func OptimizeTravel(input []Coordinate) (output []Coordinate) {
-	glyphs := MakeGlyphs(input)
-	optimizedGlyphs := ReorderGlyphs(glyphs)
-	output = MakeCoordinates(optimizedGlyphs)
+// Check the optimize and order strategies named in the settings
+func checkOptimizeStrategies(settings *SettingsData) error {
+	switch settings.OptimizeStrategy {
+	case "", "greedy", "euler":
+	default:
+		return fmt.Errorf("Unknown optimize strategy: %s", settings.OptimizeStrategy)
+	}
+	switch settings.OrderStrategy {
+	case "", "nearest", "hilbert":
+	default:
+		return fmt.Errorf("Unknown order strategy: %s", settings.OrderStrategy)
+	}
+	return nil
+}
+
+// Reorder the glyphs of the path to reduce pen up travel, following the strategies in the global Settings
+// Curves are kept as they are, except by the euler strategy which redraws the path with straight lines
+func OptimizeTravel(input Path) (output Path, err error) {
+	output, _, err = OptimizeTravelPhases(input)
+	return
+}
+
+// The glyphs after one phase of optimizing, in the order they would be drawn
+type OptimizePhase struct {
+	Name   string
+	Glyphs []Glyph
+}
+
+// Optimize like OptimizeTravel, also returning the glyphs after each phase that ran: join, euler, reorder and improve
+func OptimizeTravelPhases(input Path) (output Path, phases []OptimizePhase, err error) {
+	if err := checkOptimizeStrategies(&Settings); err != nil {
+		return nil, nil, err
+	}
+
+	glyphs, err := MakeGlyphs(input)
+	if err != nil {
+		return nil, nil, err
+	}
+	lifts := len(glyphs)
+	if Settings.MergeTolerance_MM > 0 {
+		glyphs = JoinGlyphs(glyphs, Settings.MergeTolerance_MM)
+		fmt.Fprintln(Log, "Joined glyphs within", Settings.MergeTolerance_MM, "mm, saved", lifts-len(glyphs), "pen lifts")
+		phases = append(phases, OptimizePhase{Name: "join", Glyphs: glyphs})
+	}
+	if Settings.OptimizeStrategy == "euler" {
+		glyphs = eulerianStrategy(glyphs)
+		phases = append(phases, OptimizePhase{Name: "euler", Glyphs: glyphs})
+	}
+	var optimizedGlyphs []Glyph
+	if Settings.OrderStrategy == "hilbert" {
+		optimizedGlyphs = ReorderGlyphsByRegion(glyphs, Settings.RegionSize_MM, Settings.MergeTolerance_MM)
+	} else if optimizedGlyphs, err = ReorderGlyphs(glyphs, Settings.MergeTolerance_MM); err != nil {
+		return nil, nil, err
+	}
+	phases = append(phases, OptimizePhase{Name: "reorder", Glyphs: optimizedGlyphs})
+	if Settings.OptimizeTime_Seconds > 0 {
+		optimizedGlyphs = ImproveGlyphOrder(optimizedGlyphs, time.Duration(Settings.OptimizeTime_Seconds*float64(time.Second)), Settings.MergeTolerance_MM)
+		phases = append(phases, OptimizePhase{Name: "improve", Glyphs: optimizedGlyphs})
+	}
+	fmt.Fprintln(Log, "Optimizing reduced the pen lifts from", lifts, "to", len(optimizedGlyphs))
+	output, err = MakePath(optimizedGlyphs)
+	return output, phases, err
+}
+
+// Join glyphs into chains wherever one glyph's start or end is within tolerance of another's, reversing glyphs as needed
+// Chains are grown from both ends, except that nothing is put before the first glyph so the drawing still starts there
+// Only glyphs of the same layer and colour are joined
+func JoinGlyphs(glyphs []Glyph, tolerance float64) (joined []Glyph) {
+	if len(glyphs) == 0 {
+		return glyphs
+	}
+	index := newGlyphIndex(glyphs)
+
+	// closest glyph drawn the same way as chain with a start or end within tolerance of point, by actual distance
+	closest := func(chain Glyph, point Coordinate) (glyphMatch, bool) {
+		var best glyphMatch
+		found := false
+		for _, match := range index.Within(point, tolerance) {
+			if glyphs[match.glyph].style() != chain.style() {
+				continue
+			}
+			candidate := glyphs[match.glyph].start()
+			if match.reversed {
+				candidate = glyphs[match.glyph].end()
+			}
+			match.separation = point.DistanceTo(candidate)
+			if match.separation <= tolerance && (!found || match.before(best)) {
+				best, found = match, true
+			}
+		}
+		return best, found
+	}
+
+	for seed := range glyphs {
+		if index.removed[seed] {
+			continue
+		}
+		index.Remove(seed)
+		chain := glyphs[seed]
+
+		// add glyphs that start or end near the end of the chain
+		for {
+			match, ok := closest(chain, chain.end())
+			if !ok {
+				break
+			}
+			index.Remove(match.glyph)
+			next := glyphs[match.glyph]
+			if match.reversed {
+				next = next.Reversed()
+			}
+			chain = chain.MergeWith(next)
+		}
+
+		// then glyphs that end or start near the start of the chain, drawn before it
+		for seed != 0 {
+			match, ok := closest(chain, chain.start())
+			if !ok {
+				break
+			}
+			index.Remove(match.glyph)
+			previous := glyphs[match.glyph]
+			if !match.reversed {
+				// its start is near the chain start, so it is drawn backwards to finish there
+				previous = previous.Reversed()
+			}
+			chain = previous.MergeWith(chain)
+		}
 
-	return output
+		joined = append(joined, chain)
+	}
+	return joined
 }
 
-func MakeGlyphs(coordinates []Coordinate) (glyphs []Glyph) {
+// Split the path into glyphs at each pen up segment, every glyph gets its own copy of its segments
+// A glyph starts with a straight pen up move, whatever shape the pen up segment before it had, and there are no glyphs in an empty path
+// Each glyph has the layer, colour and source id of its first pen down segment
+func MakeGlyphs(path Path) (glyphs []Glyph, err error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	if !path[0].End.PenUp {
+		return nil, errors.New("Path starts without pen up")
+	}
 	glyphs = make([]Glyph, 0)
 
-	// First coordinate is always moving with pen up
+	// First segment is always moving with pen up
 	penUp := 0
-	for i := 1; i < len(coordinates); i++ {
-		coordinate := coordinates[i]
-
-		if coordinate.PenUp {
-			// Slice from previous pen up to current
-			glyphCoordinates := coordinates[penUp:i]
-			glyph := Glyph{Coordinates: glyphCoordinates}
-			glyphs = append(glyphs, glyph)
+	for i := 1; i < len(path); i++ {
+		if path[i].End.PenUp {
+			// From previous pen up to current
+			segments := append(Path{LineTo(path[penUp].End).withSourceOf(path[penUp])}, path[penUp+1:i]...)
+			glyphs = append(glyphs, glyphFromSegments(segments))
 			penUp = i
 		}
 	}
 
 	// Last one is until the end
-	glyph := Glyph{Coordinates: coordinates[penUp:]}
-	glyphs = append(glyphs, glyph)
+	glyphs = append(glyphs, glyphFromSegments(append(Path{LineTo(path[penUp].End).withSourceOf(path[penUp])}, path[penUp+1:]...)))
+
+	return glyphs, nil
+}
 
-	for j := 0; j < len(glyphs); j++ {
-		if !glyphs[j].start().PenUp {
-			panic(fmt.Sprint("Glyph at ", j, " starts without pen up: ", glyphs[j]))
+// Check the glyph starts with a pen up move and is drawn with the pen down after it
+func (g Glyph) check() error {
+	if len(g.Segments) == 0 || !g.start().PenUp {
+		return errors.New("starts without pen up")
+	}
+	for index := 1; index < len(g.Segments); index++ {
+		if g.Segments[index].End.PenUp {
+			return fmt.Errorf("segment %d is pen up", index)
 		}
 	}
+	return nil
+}
 
-	return glyphs
+// Check every glyph, returning an error for the first one that isn't made like MakeGlyphs makes them
+func checkGlyphs(glyphs []Glyph) error {
+	for index, glyph := range glyphs {
+		if err := glyph.check(); err != nil {
+			return fmt.Errorf("Glyph at %d %v", index, err)
+		}
+	}
+	return nil
 }
 
-func ReorderGlyphs(glyphs []Glyph) (sorted []Glyph) {
+// Draw the nearest glyph next, either way round, merging glyphs that start within mergeTolerance of where the last one ends
+func ReorderGlyphs(glyphs []Glyph, mergeTolerance float64) (sorted []Glyph, err error) {
 	sorted = make([]Glyph, 0)
 	if len(glyphs) == 0 {
 		return
 	}
+	if err := checkGlyphs(glyphs); err != nil {
+		return nil, err
+	}
 
 	penUpDistanceBefore := TotalPenUpTravelForGlyphs(glyphs)
 
-	fmt.Println("Reordering, starting penUp distance:", penUpDistanceBefore)
+	fmt.Fprintln(Log, "Reordering, starting penUp distance:", penUpDistanceBefore)
 
 	// Start with first glyph
 	sorted = append(sorted, glyphs[0])
 
-	// Loop through the rest
-	glyphs = glyphs[1:]
+	// The rest are found through a grid of their start and end points
+	index := newGlyphIndex(glyphs)
+	index.Remove(0)
 
-	for len(glyphs) > 0 {
+	for index.Len() > 0 {
 
 		// We take the last glyph and try to find next one that is closest
 		glyph := sorted[len(sorted)-1]
-		glyph_end := glyph.end()
-
-		distance, index, reversed := math.MaxFloat64, -1, false
 
 		// Find closest glyph
-		for i := 0; i < len(glyphs); i++ {
-
-			start := glyphs[i].start()
-			end := glyphs[i].end()
-			d := glyph_end.SeparationFrom(start)
-			if d < distance {
-				distance, index, reversed = d, i, false
-			}
-			r := glyph_end.SeparationFrom(end)
-			if r < distance {
-				distance, index, reversed = r, i, true
-			}
-		}
-
-		closest := glyphs[index]
+		closestIndex, reversed, _ := index.Nearest(glyph.end())
+		closest := glyphs[closestIndex]
 
 		var next Glyph
 		// Check if we need to reverse it
@@ -200,59 +414,67 @@ func ReorderGlyphs(glyphs []Glyph) (sorted []Glyph) {
 			next = closest
 		}
 
-		if !next.start().PenUp {
-			if reversed {
-				panic("Reversed glyph starts without pen up")
-			} else {
-				panic(fmt.Sprint("Glyph at ", index, " starts without pen up: ", next, " Sorted: ", len(sorted)))
-			}
-		}
-
 		// Merge with last or just add to list
-		if glyph.CanBeMergedWith(next) {
-			merged := glyph.MergeWith(next)
-			if !merged.start().PenUp {
-				panic(fmt.Sprint("glyph starts without pen up. glyph: ", glyph, " next: ", next, " merged: ", merged))
-			}
-
-			sorted[len(sorted)-1] = merged
+		if glyph.CanBeMergedWith(next, mergeTolerance) {
+			sorted[len(sorted)-1] = glyph.MergeWith(next)
 		} else {
 			sorted = append(sorted, next)
 		}
 
 		// Remove found glyph
-		glyphs = append(glyphs[:index], glyphs[index+1:]...)
+		index.Remove(closestIndex)
 	}
 
 	penUpDistanceAfter := TotalPenUpTravelForGlyphs(sorted)
 
-	fmt.Println("Done, penUp distance:", penUpDistanceAfter, "reduced to", (float64(penUpDistanceAfter)/float64(penUpDistanceBefore))*100, "%")
+	fmt.Fprintln(Log, "Done, penUp distance:", penUpDistanceAfter, "reduced to", (float64(penUpDistanceAfter)/float64(penUpDistanceBefore))*100, "%")
 
-	return sorted
+	return sorted, nil
 }
 
-func MakeCoordinates(glyphs []Glyph) (coordinates []Coordinate) {
-	coordinates = make([]Coordinate, 0)
+// Join the glyphs back into a path, finishing with the pen lifted where the last glyph ends
+func MakePath(glyphs []Glyph) (path Path, err error) {
+	path = make(Path, 0)
 	if len(glyphs) == 0 {
 		return
 	}
+	if err := checkGlyphs(glyphs); err != nil {
+		return nil, err
+	}
 
 	for i := 0; i < len(glyphs); i++ {
-		glyph := glyphs[i]
-		if !glyph.start().PenUp {
-			panic(fmt.Sprint("Glyph at ", i, " starts without pen up"))
-		}
-		for j := 1; j < len(glyph.Coordinates); j++ {
-			if glyph.Coordinates[j].PenUp {
-				panic(fmt.Sprint("Coord at ", j, " is pen up"))
-			}
-		}
-		coordinates = append(coordinates, glyph.Coordinates...)
+		path = append(path, glyphs[i].Segments...)
 	}
 
-	last := coordinates[len(coordinates)-1]
+	last := path[len(path)-1].End
 	last.PenUp = true
-	coordinates = append(coordinates, last)
+	path = append(path, LineTo(last))
 
 	return
 }
+
+// A pipeline transform that reorders the whole path to reduce pen up travel
+// Curves reach the planner as curves, and a final pen up move back to the origin is kept at the end
+// When the context is from withOptimizePhases the glyphs after each phase are passed to its record function
+func OptimizeTransform() Transform {
+	return func(ctx context.Context, in <-chan Segment, out chan<- Segment) error {
+		path, err := CollectPath(ctx, in)
+		if err != nil || len(path) == 0 {
+			return err
+		}
+
+		var finish Path
+		if last := path[len(path)-1]; len(path) > 1 && last.End.PenUp {
+			path, finish = path[:len(path)-1], path[len(path)-1:]
+		}
+
+		optimized, phases, err := OptimizeTravelPhases(path)
+		if err != nil {
+			return err
+		}
+		if record, ok := ctx.Value(optimizePhasesKey{}).(func([]OptimizePhase)); ok {
+			record(phases)
+		}
+		return SendPath(ctx, out, append(optimized, finish...))
+	}
+}
@@ -1,6 +1,8 @@
 package polargraph
 
 import (
+	"math"
+	"math/rand"
 	"testing"
 )
 
@@ -13,20 +15,20 @@ func TestMakeGlyphs(t *testing.T) {
 	coords[3] = Coordinate{X: 4, Y: 5, PenUp: true}
 	coords[4] = Coordinate{X: 5, Y: 6, PenUp: false}
 
-	glyphs := MakeGlyphs(coords)
+	glyphs := makeGlyphs(t, PathFromCoordinates(coords))
 
 	if len(glyphs) != 2 {
 		t.Error("Should be 2 glyphs, found", len(glyphs))
 	}
 
 	first := glyphs[0]
-	if len(first.Coordinates) != 3 {
-		t.Error("Should have 3 coordinates, found", len(first.Coordinates))
+	if len(first.Segments) != 3 {
+		t.Error("Should have 3 coordinates, found", len(first.Segments))
 	}
 
 	second := glyphs[1]
-	if len(second.Coordinates) != 2 {
-		t.Error("Should have 2 coordinates, found", len(second.Coordinates))
+	if len(second.Segments) != 2 {
+		t.Error("Should have 2 coordinates, found", len(second.Segments))
 	}
 }
 
@@ -36,14 +38,14 @@ func TestLine(t *testing.T) {
 	coords[0] = Coordinate{X: 1, Y: 2, PenUp: true}
 	coords[1] = Coordinate{X: 2, Y: 3, PenUp: false}
 
-	glyphs := MakeGlyphs(coords)
+	glyphs := makeGlyphs(t, PathFromCoordinates(coords))
 	if len(glyphs) != 1 {
 		t.Error("Should be 1 glyph, found", len(glyphs))
 	}
 
 	first := glyphs[0]
-	if len(first.Coordinates) != 2 {
-		t.Error("Should have 2 coordinates, found", len(first.Coordinates))
+	if len(first.Segments) != 2 {
+		t.Error("Should have 2 coordinates, found", len(first.Segments))
 	}
 }
 
@@ -52,13 +54,13 @@ func TestDistance(t *testing.T) {
 	g1_cords[0] = Coordinate{X: 0, Y: 5, PenUp: true}
 	g1_cords[1] = Coordinate{X: 5, Y: 5, PenUp: false}
 
-	g1 := Glyph{Coordinates: g1_cords}
+	g1 := GlyphFromCoordinates(g1_cords)
 
 	g2_cords := make([]Coordinate, 2)
 	g2_cords[0] = Coordinate{X: 5, Y: 2, PenUp: true}
 	g2_cords[1] = Coordinate{X: 10, Y: 2, PenUp: false}
 
-	g2 := Glyph{Coordinates: g2_cords}
+	g2 := GlyphFromCoordinates(g2_cords)
 
 	distance := g1.DistanceTo(g2)
 
@@ -72,13 +74,13 @@ func TestReversedDistance(t *testing.T) {
 	g1_cords[0] = Coordinate{X: 0, Y: 5, PenUp: true}
 	g1_cords[1] = Coordinate{X: 5, Y: 5, PenUp: false}
 
-	g1 := Glyph{Coordinates: g1_cords}
+	g1 := GlyphFromCoordinates(g1_cords)
 
 	g2_cords := make([]Coordinate, 2)
 	g2_cords[0] = Coordinate{X: 5, Y: 5, PenUp: true}
 	g2_cords[1] = Coordinate{X: 10, Y: 5, PenUp: false}
 
-	g2 := Glyph{Coordinates: g2_cords}
+	g2 := GlyphFromCoordinates(g2_cords)
 
 	distance := g1.DistanceToReversed(g2)
 
@@ -92,7 +94,7 @@ func TestLength(t *testing.T) {
 	g1_cords[0] = Coordinate{X: 0, Y: 5, PenUp: true}
 	g1_cords[1] = Coordinate{X: 5, Y: 5, PenUp: false}
 
-	g1 := Glyph{Coordinates: g1_cords}
+	g1 := GlyphFromCoordinates(g1_cords)
 
 	l1 := g1.Length()
 
@@ -105,7 +107,7 @@ func TestLength(t *testing.T) {
 	g2_cords[1] = Coordinate{X: 10, Y: 2, PenUp: false}
 	g2_cords[2] = Coordinate{X: 10, Y: 12, PenUp: false}
 
-	g2 := Glyph{Coordinates: g2_cords}
+	g2 := GlyphFromCoordinates(g2_cords)
 
 	l2 := g2.Length()
 
@@ -119,19 +121,19 @@ func TestEquals(t *testing.T) {
 	g1_cords[0] = Coordinate{X: 0, Y: 5, PenUp: true}
 	g1_cords[1] = Coordinate{X: 5, Y: 5, PenUp: false}
 
-	g1 := Glyph{Coordinates: g1_cords}
+	g1 := GlyphFromCoordinates(g1_cords)
 
 	g2_cords := make([]Coordinate, 2)
 	g2_cords[0] = Coordinate{X: 5, Y: 2, PenUp: true}
 	g2_cords[1] = Coordinate{X: 10, Y: 2, PenUp: false}
 
-	g2 := Glyph{Coordinates: g2_cords}
+	g2 := GlyphFromCoordinates(g2_cords)
 
 	g3_cords := make([]Coordinate, 2)
 	g3_cords[0] = Coordinate{X: 0, Y: 5, PenUp: true}
 	g3_cords[1] = Coordinate{X: 5, Y: 5, PenUp: false}
 
-	g3 := Glyph{Coordinates: g3_cords}
+	g3 := GlyphFromCoordinates(g3_cords)
 
 	if !g1.Equals(g1) {
 		t.Error("Should be equal to self")
@@ -151,13 +153,13 @@ func TestTotalTravel(t *testing.T) {
 	g1_cords[0] = Coordinate{X: 0, Y: 5, PenUp: true}
 	g1_cords[1] = Coordinate{X: 5, Y: 5, PenUp: false}
 
-	g1 := Glyph{Coordinates: g1_cords}
+	g1 := GlyphFromCoordinates(g1_cords)
 
 	g2_cords := make([]Coordinate, 2)
 	g2_cords[0] = Coordinate{X: 5, Y: 2, PenUp: true}
 	g2_cords[1] = Coordinate{X: 10, Y: 2, PenUp: false}
 
-	g2 := Glyph{Coordinates: g2_cords}
+	g2 := GlyphFromCoordinates(g2_cords)
 
 	glyphs := make([]Glyph, 2)
 	glyphs[0] = g1
@@ -176,19 +178,19 @@ func TestPenUpTravel(t *testing.T) {
 	g1_cords[0] = Coordinate{X: 0, Y: 5, PenUp: true}
 	g1_cords[1] = Coordinate{X: 5, Y: 5, PenUp: false}
 
-	g1 := Glyph{Coordinates: g1_cords}
+	g1 := GlyphFromCoordinates(g1_cords)
 
 	g2_cords := make([]Coordinate, 2)
 	g2_cords[0] = Coordinate{X: 5, Y: 2, PenUp: true}
 	g2_cords[1] = Coordinate{X: 10, Y: 2, PenUp: false}
 
-	g2 := Glyph{Coordinates: g2_cords}
+	g2 := GlyphFromCoordinates(g2_cords)
 
 	g3_cords := make([]Coordinate, 2)
 	g3_cords[0] = Coordinate{X: 10, Y: 7, PenUp: true}
 	g3_cords[1] = Coordinate{X: 5, Y: 7, PenUp: false}
 
-	g3 := Glyph{Coordinates: g3_cords}
+	g3 := GlyphFromCoordinates(g3_cords)
 
 	glyphs := make([]Glyph, 3)
 	glyphs[0] = g1
@@ -209,17 +211,17 @@ func TestReversed(t *testing.T) {
 	g1_cords[1] = Coordinate{X: 5, Y: 5, PenUp: false}
 	g1_cords[2] = Coordinate{X: 5, Y: 10, PenUp: false}
 
-	g1 := Glyph{Coordinates: g1_cords}
+	g1 := GlyphFromCoordinates(g1_cords)
 
 	reversed := g1.Reversed()
-	if !reversed.Coordinates[0].Same(g1.Coordinates[2]) {
+	if !reversed.Segments[0].End.Same(g1.Segments[2].End) {
 		t.Error("Not reversed!, reversed:", reversed, "original:", g1)
 	}
 
 }
 
 func TestReorderEmpty(t *testing.T) {
-	reordered := ReorderGlyphs(make([]Glyph, 0))
+	reordered := reorderGlyphs(t, make([]Glyph, 0), 0)
 	if len(reordered) > 0 {
 		t.Error("Failed reordering:", reordered)
 	}
@@ -230,19 +232,19 @@ func TestCheckMergeGlyphs(t *testing.T) {
 	g1_cords[0] = Coordinate{X: 0, Y: 5, PenUp: true}
 	g1_cords[1] = Coordinate{X: 5, Y: 5, PenUp: false}
 	g1_cords[2] = Coordinate{X: 5, Y: 10, PenUp: false}
-	g1 := Glyph{Coordinates: g1_cords}
+	g1 := GlyphFromCoordinates(g1_cords)
 
 	g2_cords := make([]Coordinate, 2)
 	g2_cords[0] = Coordinate{X: 5, Y: 10, PenUp: true}
 	g2_cords[1] = Coordinate{X: 10, Y: 2, PenUp: false}
 
-	g2 := Glyph{Coordinates: g2_cords}
+	g2 := GlyphFromCoordinates(g2_cords)
 
-	if g1.CanBeMergedWith(g2) == false {
+	if g1.CanBeMergedWith(g2, 0) == false {
 		t.Error("Can be merged:", g1, g2)
 	}
 
-	if g2.CanBeMergedWith(g1) {
+	if g2.CanBeMergedWith(g1, 0) {
 		t.Error("Cannot be merged:", g2, g1)
 	}
 }
@@ -252,22 +254,27 @@ func TestMergeGlyphs(t *testing.T) {
 	g1_cords[0] = Coordinate{X: 0, Y: 5, PenUp: true}
 	g1_cords[1] = Coordinate{X: 5, Y: 5, PenUp: false}
 	g1_cords[2] = Coordinate{X: 5, Y: 10, PenUp: false}
-	g1 := Glyph{Coordinates: g1_cords}
+	g1 := GlyphFromCoordinates(g1_cords)
 
 	g2_cords := make([]Coordinate, 2)
 	g2_cords[0] = Coordinate{X: 5, Y: 10, PenUp: true}
 	g2_cords[1] = Coordinate{X: 10, Y: 2, PenUp: false}
 
-	g2 := Glyph{Coordinates: g2_cords}
+	g2 := GlyphFromCoordinates(g2_cords)
 
 	merged := g1.MergeWith(g2)
 
-	all_coords := append(g1_cords, g2_cords...)
-	shouldBe := Glyph{Coordinates: all_coords}
+	all_coords := append(append([]Coordinate{}, g1_cords...), g2_cords...)
+	all_coords[3].PenUp = false
+	shouldBe := GlyphFromCoordinates(all_coords)
 
 	if !merged.Equals(shouldBe) {
 		t.Error("Not merged correctly", g1, g2)
 	}
+
+	if !g2.start().PenUp || !g2_cords[0].PenUp {
+		t.Error("Merging changed the glyph merged in", g2)
+	}
 }
 
 func TestReorderOne(t *testing.T) {
@@ -276,12 +283,12 @@ func TestReorderOne(t *testing.T) {
 	g1_cords[1] = Coordinate{X: 3, Y: 11, PenUp: false}
 	g1_cords[2] = Coordinate{X: 4, Y: 10, PenUp: false}
 
-	g1 := Glyph{Coordinates: g1_cords}
+	g1 := GlyphFromCoordinates(g1_cords)
 
 	glyphs := make([]Glyph, 1)
 	glyphs[0] = g1
 
-	reordered := ReorderGlyphs(glyphs)
+	reordered := reorderGlyphs(t, glyphs, 0)
 
 	if len(reordered) != 1 {
 		t.Error("Failed reordering:", reordered)
@@ -294,27 +301,27 @@ func TestReorder(t *testing.T) {
 	g1_cords[1] = Coordinate{X: 3, Y: 11, PenUp: false}
 	g1_cords[2] = Coordinate{X: 4, Y: 10, PenUp: false}
 
-	g1 := Glyph{Coordinates: g1_cords}
+	g1 := GlyphFromCoordinates(g1_cords)
 
 	g2_cords := make([]Coordinate, 2)
 	g2_cords[0] = Coordinate{X: 3, Y: 7, PenUp: true}
 	g2_cords[1] = Coordinate{X: 6, Y: 7, PenUp: false}
 
-	g2 := Glyph{Coordinates: g2_cords}
+	g2 := GlyphFromCoordinates(g2_cords)
 
 	g3_cords := make([]Coordinate, 3)
 	g3_cords[0] = Coordinate{X: 4, Y: 9, PenUp: true}
 	g3_cords[1] = Coordinate{X: 7, Y: 9, PenUp: false}
 	g3_cords[2] = Coordinate{X: 7, Y: 7, PenUp: false}
 
-	g3 := Glyph{Coordinates: g3_cords}
+	g3 := GlyphFromCoordinates(g3_cords)
 
 	glyphs := make([]Glyph, 3)
 	glyphs[0] = g1
 	glyphs[1] = g2
 	glyphs[2] = g3
 
-	reordered := ReorderGlyphs(glyphs)
+	reordered := reorderGlyphs(t, glyphs, 0)
 
 	if len(reordered) != 3 {
 		t.Error("Wrong glyph count!")
@@ -340,26 +347,26 @@ func TestReorderMerge(t *testing.T) {
 	g1_cords[1] = Coordinate{X: 3, Y: 11, PenUp: false}
 	g1_cords[2] = Coordinate{X: 4, Y: 10, PenUp: false}
 
-	g1 := Glyph{Coordinates: g1_cords}
+	g1 := GlyphFromCoordinates(g1_cords)
 
 	g2_cords := make([]Coordinate, 2)
 	g2_cords[0] = Coordinate{X: 3, Y: 7, PenUp: true}
 	g2_cords[1] = Coordinate{X: 7, Y: 7, PenUp: false}
 
-	g2 := Glyph{Coordinates: g2_cords}
+	g2 := GlyphFromCoordinates(g2_cords)
 
 	g3_cords := make([]Coordinate, 3)
 	g3_cords[0] = Coordinate{X: 4, Y: 9, PenUp: true}
 	g3_cords[1] = Coordinate{X: 7, Y: 9, PenUp: false}
 	g3_cords[2] = Coordinate{X: 7, Y: 7, PenUp: false}
 
-	g3 := Glyph{Coordinates: g3_cords}
+	g3 := GlyphFromCoordinates(g3_cords)
 
 	g4_cords := make([]Coordinate, 2)
 	g4_cords[0] = Coordinate{X: 3, Y: 7, PenUp: true}
 	g4_cords[1] = Coordinate{X: 3, Y: 4, PenUp: false}
 
-	g4 := Glyph{Coordinates: g4_cords}
+	g4 := GlyphFromCoordinates(g4_cords)
 
 	glyphs := make([]Glyph, 4)
 	glyphs[0] = g1
@@ -367,7 +374,7 @@ func TestReorderMerge(t *testing.T) {
 	glyphs[2] = g3
 	glyphs[3] = g4
 
-	reordered := ReorderGlyphs(glyphs)
+	reordered := reorderGlyphs(t, glyphs, 0)
 
 	if len(reordered) != 2 {
 		t.Error("Wrong glyph count! should be 2, but have", len(reordered))
@@ -385,24 +392,24 @@ func TestReorderMerge(t *testing.T) {
 	}
 }
 
-func TestMakeCoordinates(t *testing.T) {
+func TestMakePath(t *testing.T) {
 	g1_cords := make([]Coordinate, 2)
 	g1_cords[0] = Coordinate{X: 0, Y: 5, PenUp: true}
 	g1_cords[1] = Coordinate{X: 5, Y: 5, PenUp: false}
 
-	g1 := Glyph{Coordinates: g1_cords}
+	g1 := GlyphFromCoordinates(g1_cords)
 
 	g2_cords := make([]Coordinate, 2)
 	g2_cords[0] = Coordinate{X: 5, Y: 2, PenUp: true}
 	g2_cords[1] = Coordinate{X: 10, Y: 2, PenUp: false}
 
-	g2 := Glyph{Coordinates: g2_cords}
+	g2 := GlyphFromCoordinates(g2_cords)
 
 	glyphs := make([]Glyph, 2)
 	glyphs[0] = g1
 	glyphs[1] = g2
 
-	coordinates := MakeCoordinates(glyphs)
+	path := makePath(t, glyphs)
 
 	shouldBe := make([]Coordinate, 5)
 	// First glyph
@@ -416,14 +423,14 @@ func TestMakeCoordinates(t *testing.T) {
 	// Pen up, we are done
 	shouldBe[4] = Coordinate{X: 10, Y: 2, PenUp: true}
 
-	if len(coordinates) != len(shouldBe) {
-		t.Error("Wrong number of coordinates, should be", len(shouldBe), "is", len(coordinates))
+	if len(path) != len(shouldBe) {
+		t.Error("Wrong number of segments, should be", len(shouldBe), "is", len(path))
 		return
 	}
 
-	for i := 0; i < len(coordinates); i++ {
-		if !coordinates[i].Equals(shouldBe[i]) {
-			t.Error("Wrong coordinates at index", i, coordinates[i], "should be", shouldBe[i])
+	for i := 0; i < len(path); i++ {
+		if path[i].Kind != LineKind || !path[i].End.Equals(shouldBe[i]) {
+			t.Error("Wrong segment at index", i, path[i], "should be a line to", shouldBe[i])
 		}
 	}
 }
@@ -431,3 +438,327 @@ func TestMakeCoordinates(t *testing.T) {
 func TestMultipleLines(t *testing.T) {
 
 }
+
+// Greedy reordering by scanning every remaining glyph, what ReorderGlyphs did before it used a glyph index
+func reorderGlyphsByScan(glyphs []Glyph) (sorted []Glyph) {
+	sorted = append(sorted, glyphs[0])
+	glyphs = append([]Glyph{}, glyphs[1:]...)
+
+	for len(glyphs) > 0 {
+		glyph := sorted[len(sorted)-1]
+		distance, index, reversed := math.MaxFloat64, -1, false
+		for i := 0; i < len(glyphs); i++ {
+			if d := glyph.end().SeparationFrom(glyphs[i].start()); d < distance {
+				distance, index, reversed = d, i, false
+			}
+			if r := glyph.end().SeparationFrom(glyphs[i].end()); r < distance {
+				distance, index, reversed = r, i, true
+			}
+		}
+
+		next := glyphs[index]
+		if reversed {
+			next = next.Reversed()
+		}
+		if glyph.CanBeMergedWith(next, 0) {
+			sorted[len(sorted)-1] = glyph.MergeWith(next)
+		} else {
+			sorted = append(sorted, next)
+		}
+		glyphs = append(glyphs[:index], glyphs[index+1:]...)
+	}
+	return sorted
+}
+
+// Random glyphs, coordinates are whole numbers on a small grid so there are plenty of ties and glyphs that can be merged
+func randomGlyphs(random *rand.Rand, count int, size int) []Glyph {
+	glyphs := make([]Glyph, count)
+	for index := range glyphs {
+		coords := make([]Coordinate, 2+random.Intn(3))
+		for c := range coords {
+			coords[c] = Coordinate{X: float64(random.Intn(size)), Y: float64(random.Intn(size))}
+		}
+		coords[0].PenUp = true
+		glyphs[index] = GlyphFromCoordinates(coords)
+	}
+	return glyphs
+}
+
+// Copy the glyphs, so each run starts from its own input
+func copyGlyphs(glyphs []Glyph) []Glyph {
+	copied := make([]Glyph, len(glyphs))
+	for index, glyph := range glyphs {
+		copied[index] = glyph.withSegments(append(Path{}, glyph.Segments...))
+	}
+	return copied
+}
+
+// The glyph index should pick exactly the same glyphs as scanning all of them
+func TestReorderMatchesScan(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 20; trial++ {
+		glyphs := randomGlyphs(random, 1+random.Intn(300), 1+random.Intn(40))
+
+		expected := reorderGlyphsByScan(copyGlyphs(glyphs))
+		reordered := reorderGlyphs(t, copyGlyphs(glyphs), 0)
+
+		if len(reordered) != len(expected) {
+			t.Fatal("Trial", trial, "expected", len(expected), "glyphs and got", len(reordered))
+		}
+		for index := range expected {
+			if !reordered[index].Equals(expected[index]) {
+				t.Fatal("Trial", trial, "glyph", index, "expected", expected[index], "and got", reordered[index])
+			}
+		}
+	}
+}
+
+func BenchmarkReorderGlyphs(b *testing.B) {
+	glyphs := randomGlyphs(rand.New(rand.NewSource(1)), 50000, 100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		input := copyGlyphs(glyphs)
+		b.StartTimer()
+		reorderGlyphs(b, input, 0)
+	}
+}
+
+// Glyphs within the tolerance should be joined at either end of the chain, reversed where needed
+func TestJoinGlyphs(t *testing.T) {
+	glyph := func(coords ...Coordinate) Glyph {
+		coords[0].PenUp = true
+		return GlyphFromCoordinates(coords)
+	}
+	glyphs := []Glyph{
+		glyph(Coordinate{X: 100, Y: 100}),
+		glyph(Coordinate{X: 0, Y: 0}, Coordinate{X: 10, Y: 0}),
+		glyph(Coordinate{X: 10.1, Y: 0}, Coordinate{X: 20, Y: 0}),
+		glyph(Coordinate{X: 30, Y: 0}, Coordinate{X: 20.1, Y: 0}),
+		glyph(Coordinate{X: -10, Y: 0}, Coordinate{X: -0.1, Y: 0}),
+		glyph(Coordinate{X: -10.1, Y: 0}, Coordinate{X: -20, Y: 0}),
+		glyph(Coordinate{X: 50, Y: 50}, Coordinate{X: 60, Y: 60}),
+	}
+
+	joined := JoinGlyphs(glyphs, 0.2)
+
+	if len(joined) != 3 {
+		t.Fatal("Expected 3 glyphs and got", len(joined), joined)
+	}
+	if !joined[0].Equals(glyphs[0]) || !joined[2].Equals(glyphs[6]) {
+		t.Error("Expected the glyphs out of reach to be left alone and got", joined[0], joined[2])
+	}
+
+	expected := []float64{-20, -10.1, -10, -0.1, 0, 10, 10.1, 20, 20.1, 30}
+	chain := joined[1].Segments
+	if len(chain) != len(expected) {
+		t.Fatal("Expected the chain through", expected, "and got", chain)
+	}
+	for index, x := range expected {
+		if chain[index].End.X != x || chain[index].End.PenUp != (index == 0) {
+			t.Error("Segment", index, "expected X", x, "and got", chain[index])
+		}
+	}
+}
+
+// Merging should accept ends within the tolerance
+func TestCanBeMergedWithTolerance(t *testing.T) {
+	first := GlyphFromCoordinates([]Coordinate{{X: 0, Y: 0, PenUp: true}, {X: 10, Y: 0}})
+	second := GlyphFromCoordinates([]Coordinate{{X: 10.15, Y: 0, PenUp: true}, {X: 20, Y: 0}})
+
+	if first.CanBeMergedWith(second, 0) {
+		t.Error("Expected glyphs 0.15 apart not to merge without a tolerance")
+	}
+	if !first.CanBeMergedWith(second, 0.2) {
+		t.Error("Expected glyphs 0.15 apart to merge with a 0.2 tolerance")
+	}
+}
+
+// Glyphs made from a drawing should know if they are closed, and keep it right when reversed or merged
+func TestGlyphClosed(t *testing.T) {
+	glyphs := makeGlyphs(t, PathFromCoordinates([]Coordinate{
+		{X: 0, Y: 0, PenUp: true}, {X: 1, Y: 0}, {X: 1, Y: 1}, {X: 0, Y: 0},
+		{X: 0, Y: 0, PenUp: true}, {X: 5, Y: 5},
+	}))
+	square, tail := glyphs[0], glyphs[1]
+	if !square.Closed || tail.Closed {
+		t.Fatal("Expected only the square to be closed, got", square.Closed, tail.Closed)
+	}
+
+	if reversed := square.Reversed(); !reversed.Closed {
+		t.Error("Expected the reversed square to still be closed")
+	}
+	if merged := square.MergeWith(tail); merged.Closed {
+		t.Error("Expected the square merged with a line leading away from it to be open")
+	}
+}
+
+// Reversing a glyph with curves should keep them curves, and reversing it again should give back the glyph
+func TestGlyphReversedCurves(t *testing.T) {
+	glyph := Glyph{}.withSegments(Path{
+		LineTo(Coordinate{X: 0, Y: 0, PenUp: true}),
+		LineTo(Coordinate{X: 10, Y: 0}),
+		ArcAround(Coordinate{X: 10, Y: 0}, Coordinate{X: 10, Y: 5}, math.Pi/2, false),
+		CubicTo(Coordinate{X: 15, Y: 10}, Coordinate{X: 20, Y: 10}, Coordinate{X: 20, Y: 5}),
+	})
+
+	reversed := glyph.Reversed()
+	if !reversed.start().Same(glyph.end()) || !reversed.start().PenUp || !reversed.end().Same(glyph.start()) || reversed.end().PenUp {
+		t.Error("Expected the glyph drawn from its end to its start and got", reversed)
+	}
+	if reversed.Segments[1].Kind != CubicKind || reversed.Segments[2].Kind != ArcKind || reversed.Segments[2].Sweep != -math.Pi/2 {
+		t.Error("Expected the cubic then the arc turning back and got", reversed)
+	}
+	if math.Abs(reversed.Length()-glyph.Length()) > 0.000001 {
+		t.Error("Expected the same length", glyph.Length(), "and got", reversed.Length())
+	}
+	if !reversed.Reversed().Equals(glyph) {
+		t.Error("Expected reversing twice to give back", glyph, "and got", reversed.Reversed())
+	}
+
+	circle := Glyph{}.withSegments(Path{LineTo(Coordinate{X: 5, Y: 0, PenUp: true}), ArcAround(Coordinate{X: 5, Y: 0}, Coordinate{}, 2*math.Pi, false)})
+	if !circle.Closed {
+		t.Error("Expected a whole circle to be closed")
+	}
+}
+
+// Glyphs should have their own segments, so changing the input or merging them can't change other glyphs
+func TestMakeGlyphsCopies(t *testing.T) {
+	coords := []Coordinate{{X: 0, Y: 0, PenUp: true}, {X: 1, Y: 0}, {X: 1, Y: 0, PenUp: true}, {X: 2, Y: 0}}
+	glyphs := makeGlyphs(t, PathFromCoordinates(coords))
+	coords[1].X = 100
+
+	if glyphs[0].end().X != 1 {
+		t.Error("Changing the input changed the glyph", glyphs[0])
+	}
+
+	glyphs[0].MergeWith(glyphs[1])
+	glyphs[0].MergeWith(glyphs[1].Reversed())
+	if !glyphs[1].start().PenUp || len(glyphs[0].Segments) != 2 {
+		t.Error("Merging changed the glyphs", glyphs)
+	}
+}
+
+// Nothing drawn should give no glyphs, and optimize to nothing
+func TestMakeGlyphsEmpty(t *testing.T) {
+	if glyphs := makeGlyphs(t, nil); len(glyphs) != 0 {
+		t.Error("Expected no glyphs and got", glyphs)
+	}
+	if output := optimizeTravel(t, nil); len(output) != 0 {
+		t.Error("Expected nothing to optimize and got", output)
+	}
+}
+
+// Paths or glyphs that don't start with the pen up should be errors rather than panics
+func TestGlyphsWithoutPenUp(t *testing.T) {
+	if _, err := MakeGlyphs(PathFromCoordinates([]Coordinate{{X: 0, Y: 0}, {X: 1, Y: 0}})); err == nil {
+		t.Error("Expected an error for a path starting with the pen down")
+	}
+
+	penDown := []Glyph{
+		GlyphFromCoordinates([]Coordinate{{X: 0, Y: 0, PenUp: true}, {X: 1, Y: 0}}),
+		GlyphFromCoordinates([]Coordinate{{X: 2, Y: 0}, {X: 3, Y: 0}}),
+	}
+	if _, err := ReorderGlyphs(penDown, 0); err == nil {
+		t.Error("Expected an error reordering a glyph that starts with the pen down")
+	}
+	if _, err := MakePath(penDown); err == nil {
+		t.Error("Expected an error for a glyph that starts with the pen down")
+	}
+
+	liftedInside := []Glyph{GlyphFromCoordinates([]Coordinate{{X: 0, Y: 0, PenUp: true}, {X: 1, Y: 0, PenUp: true}})}
+	if _, err := MakePath(liftedInside); err == nil {
+		t.Error("Expected an error for a glyph that lifts the pen part way through")
+	}
+}
+
+// MakeGlyphs, failing the test on an error
+func makeGlyphs(t testing.TB, path Path) []Glyph {
+	glyphs, err := MakeGlyphs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return glyphs
+}
+
+// MakePath, failing the test on an error
+func makePath(t testing.TB, glyphs []Glyph) Path {
+	path, err := MakePath(glyphs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// ReorderGlyphs, failing the test on an error
+func reorderGlyphs(t testing.TB, glyphs []Glyph, mergeTolerance float64) []Glyph {
+	sorted, err := ReorderGlyphs(glyphs, mergeTolerance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sorted
+}
+
+// OptimizeTravel with the global Settings, failing the test on an error
+func optimizeTravel(t *testing.T, input Path) Path {
+	output, err := OptimizeTravel(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+// Whatever the strategy, optimizing, even twice, should draw every pen down line of the input and only add joins within MergeTolerance_MM
+func TestOptimizeTravelRoundTrip(t *testing.T) {
+	defer func(settings SettingsData) { Settings = settings }(Settings)
+
+	random := rand.New(rand.NewSource(3))
+	for trial := 0; trial < 40; trial++ {
+		Settings.OptimizeStrategy = []string{"greedy", "euler"}[trial%2]
+		Settings.OrderStrategy = []string{"nearest", "hilbert"}[trial/2%2]
+		Settings.MergeTolerance_MM = []float64{0, 0.5}[trial/4%2]
+		Settings.RegionSize_MM = 5
+
+		glyphs := randomGlyphs(random, 1+random.Intn(100), 1+random.Intn(20))
+		for dot := random.Intn(5); dot > 0; dot-- {
+			point := Coordinate{X: float64(random.Intn(20)), Y: float64(random.Intn(20)), PenUp: true}
+			glyphs = append(glyphs, GlyphFromCoordinates([]Coordinate{point, {X: point.X, Y: point.Y}}))
+		}
+		input := makePath(t, glyphs)
+		original := append(Path{}, input...)
+
+		once := optimizeTravel(t, input)
+		twice := optimizeTravel(t, once)
+
+		for index := range input {
+			if input[index] != original[index] {
+				t.Fatal("Trial", trial, "optimizing changed the input at", index)
+			}
+		}
+
+		for _, output := range []Path{once, twice} {
+			drawn := make(map[string]int)
+			for _, line := range strokeLines(makeGlyphs(t, output)) {
+				drawn[line]++
+			}
+			for _, line := range strokeLines(makeGlyphs(t, input)) {
+				if drawn[line] == 0 {
+					t.Fatal("Trial", trial, Settings.OptimizeStrategy, Settings.OrderStrategy, "lost the line", line)
+				}
+				drawn[line]--
+			}
+
+			for _, glyph := range makeGlyphs(t, output) {
+				for index := 1; index < len(glyph.Segments); index++ {
+					line := strokeLines([]Glyph{glyph.withSegments(glyph.Segments[index-1 : index+1])})[0]
+					if drawn[line] > 0 {
+						drawn[line]--
+						if length := glyph.Segments[index-1].End.DistanceTo(glyph.Segments[index].End); length > Settings.MergeTolerance_MM+0.000001 {
+							t.Fatal("Trial", trial, "added the line", line, "longer than the merge tolerance")
+						}
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package polargraph
+
+// Connects a source of segments through any number of transforms into a sink, each running as its own stage
+// Stages return errors instead of panicking, and the first failure or a cancelled context stops every stage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"sort"
+	"sync"
+)
+
+// Produces segments, must stop and return once ctx is done
+type Source func(ctx context.Context, out chan<- Segment) error
+
+// Reads segments until in is closed and sends the changed segments on, must stop and return once ctx is done
+type Transform func(ctx context.Context, in <-chan Segment, out chan<- Segment) error
+
+// Consumes segments until in is closed, must stop and return once ctx is done
+type Sink func(ctx context.Context, in <-chan Segment) error
+
+// Number of segments buffered between stages
+const pipelineBufferSize = 1024
+
+// Progress and warnings from the stages are written here, set it before running a pipeline
+var Log io.Writer = os.Stdout
+
+// A source, its transforms in order and the sink they end in
+type Pipeline struct {
+	Source     Source
+	Transforms []Transform
+	Sink       Sink
+}
+
+// Create a pipeline running the segments from source through the transforms into sink
+func NewPipeline(source Source, sink Sink, transforms ...Transform) *Pipeline {
+	return &Pipeline{Source: source, Transforms: transforms, Sink: sink}
+}
+
+// Run every stage until the sink finishes, returning the first error from any stage
+// When a stage fails the others are cancelled, and each stage's input is drained once it returns so no stage is left blocked
+func (pipeline *Pipeline) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var firstErr error
+	var errOnce sync.Once
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	var running sync.WaitGroup
+	start := func(stage func() error, in <-chan Segment, out chan<- Segment) {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			if err := runStage(stage); err != nil {
+				fail(err)
+			}
+			if out != nil {
+				close(out)
+			}
+			if in != nil {
+				for range in {
+				}
+			}
+		}()
+	}
+
+	out := make(chan Segment, pipelineBufferSize)
+	start(func() error { return pipeline.Source(ctx, out) }, nil, out)
+
+	in := out
+	for _, transform := range pipeline.Transforms {
+		transform, transformIn, transformOut := transform, in, make(chan Segment, pipelineBufferSize)
+		start(func() error { return transform(ctx, transformIn, transformOut) }, transformIn, transformOut)
+		in = transformOut
+	}
+
+	start(func() error { return pipeline.Sink(ctx, in) }, in, nil)
+	running.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// Run a stage, turning a panic into an error as a last resort so that a bug in one stage can't leave the others blocked
+// The error includes the stack of the panic, so the bug can still be found
+func runStage(stage func() error) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("stage panicked: %v\n%s", recovered, debug.Stack())
+		}
+	}()
+	return stage()
+}
+
+// Send a segment, giving up if ctx is done first
+func SendSegment(ctx context.Context, out chan<- Segment, segment Segment) error {
+	select {
+	case out <- segment:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Send every segment of the path
+func SendPath(ctx context.Context, out chan<- Segment, path Path) error {
+	for _, segment := range path {
+		if err := SendSegment(ctx, out, segment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A source that sends the whole of path then stops
+func PathSource(path Path) Source {
+	return func(ctx context.Context, out chan<- Segment) error {
+		return SendPath(ctx, out, path)
+	}
+}
+
+// Read every segment until in is closed, giving up if ctx is done first
+func CollectPath(ctx context.Context, in <-chan Segment) (path Path, err error) {
+	for {
+		select {
+		case segment, ok := <-in:
+			if !ok {
+				return path, nil
+			}
+			path = append(path, segment)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Create a stage from the command line arguments, args are the parameters after the command without any transform names
+type SourceFactory func(args []string) (Source, error)
+type TransformFactory func(args []string) (Transform, error)
+type SinkFactory func(args []string) (Sink, error)
+
+var (
+	sourceFactories    = map[string]SourceFactory{}
+	transformFactories = map[string]TransformFactory{}
+	sinkFactories      = map[string]SinkFactory{}
+)
+
+// Make a source available as a command
+func RegisterSource(name string, factory SourceFactory) {
+	sourceFactories[name] = factory
+}
+
+// Make a transform available to follow any source command
+func RegisterTransform(name string, factory TransformFactory) {
+	transformFactories[name] = factory
+}
+
+// Make a sink available as an output
+func RegisterSink(name string, factory SinkFactory) {
+	sinkFactories[name] = factory
+}
+
+// Look up the source registered with the given name
+func LookupSource(name string) (SourceFactory, bool) {
+	factory, ok := sourceFactories[name]
+	return factory, ok
+}
+
+// Look up the transform registered with the given name
+func LookupTransform(name string) (TransformFactory, bool) {
+	factory, ok := transformFactories[name]
+	return factory, ok
+}
+
+// Look up the sink registered with the given name
+func LookupSink(name string) (SinkFactory, bool) {
+	factory, ok := sinkFactories[name]
+	return factory, ok
+}
+
+// Sorted names of the registered transforms
+func TransformNames() []string {
+	names := make([]string, 0, len(transformFactories))
+	for name := range transformFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// the built in stages
+func init() {
+	RegisterSource("svg", func(args []string) (Source, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("Expected the path to an svg file")
+		}
+		return SvgSource(args[0]), nil
+	})
+	RegisterSource("mouse", mouseSourceFromArgs)
+	RegisterSource("plot", func(args []string) (Source, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("Expected the path to a plot file")
+		}
+		return PlotFileSource(args[0]), nil
+	})
+
+	RegisterTransform("simplify", func(args []string) (Transform, error) {
+		return SimplifyTransform(Settings.SimplifyTolerance()), nil
+	})
+	RegisterTransform("dedup", func(args []string) (Transform, error) {
+		return DedupTransform(Settings.DedupTolerance()), nil
+	})
+	RegisterTransform("optimize", func(args []string) (Transform, error) {
+		// the improvement pass stops after OptimizeTime_Seconds, so how good its result is depends on the machine and a cached one
+		// would stop a later run from doing better
+		if Settings.OptimizeTime_Seconds > 0 {
+			return OptimizeTransform(), nil
+		}
+		return CachedTransform(Settings.PathCache(), "optimize", OptimizeTransform(), optimizeCacheVersion, Settings.StepSize_MM, Settings.MergeTolerance_MM, Settings.OptimizeStrategy, Settings.Retrace_MM, Settings.OrderStrategy, Settings.RegionSize_MM), nil
+	})
+
+	RegisterSink("serial", func(args []string) (Sink, error) {
+		return StepsSink(WriteStepsToSerial), nil
+	})
+	RegisterSink("count", func(args []string) (Sink, error) {
+		return StepsSink(CountSteps), nil
+	})
+	RegisterSink("chart", func(args []string) (Sink, error) {
+		return StepsSink(WriteStepsToChart), nil
+	})
+	RegisterSink("image", imageSinkFromArgs)
+	RegisterSink("export", func(args []string) (Sink, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("Expected the path to export to")
+		}
+		return ExportSink(args[0]), nil
+	})
+}
+
+// A sink that generates steps for the segments using the global Settings and hands them to write
+// Once ctx is done the pen is stopped, lifted and returned to the starting position, and those steps are still written
+func StepsSink(write func(stepData <-chan int8) error) Sink {
+	return func(ctx context.Context, in <-chan Segment) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		stepData := make(chan int8, 1024)
+		generated := make(chan error, 1)
+		go func() {
+			generated <- runStage(func() error {
+				return GenerateSteps(ctx, in, stepData)
+			})
+		}()
+
+		err := runStage(func() error {
+			return write(stepData)
+		})
+		// if writing failed stop the generator, which still needs somewhere to put its last steps
+		if err != nil {
+			cancel()
+		}
+		for range stepData {
+		}
+
+		if generateErr := <-generated; err == nil {
+			err = generateErr
+		}
+		return err
+	}
+}
@@ -0,0 +1,176 @@
+package polargraph
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Sends segments until cancelled
+func endlessSource(ctx context.Context, out chan<- Segment) error {
+	for x := 0.0; ; x++ {
+		if err := SendSegment(ctx, out, LineTo(Coordinate{X: x})); err != nil {
+			return err
+		}
+	}
+}
+
+// Collects everything into path
+func collectSink(path *Path) Sink {
+	return func(ctx context.Context, in <-chan Segment) (err error) {
+		*path, err = CollectPath(ctx, in)
+		return err
+	}
+}
+
+// Run the pipeline, failing the test if it doesn't finish
+func runPipeline(t *testing.T, ctx context.Context, pipeline *Pipeline) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- pipeline.Run(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Pipeline did not finish")
+		return nil
+	}
+}
+
+// Segments should pass through every transform in order
+func TestPipelineTransforms(t *testing.T) {
+	input := Path{
+		LineTo(Coordinate{X: 1, Y: 2, PenUp: true}),
+		LineTo(Coordinate{X: 3, Y: 4}),
+		LineTo(Coordinate{X: 5, Y: 6}),
+	}
+	shift := func(ctx context.Context, in <-chan Segment, out chan<- Segment) error {
+		for segment := range in {
+			segment.End = segment.End.Add(Coordinate{X: 10, PenUp: segment.End.PenUp})
+			if err := SendSegment(ctx, out, segment); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var output Path
+	if err := runPipeline(t, context.Background(), NewPipeline(PathSource(input), collectSink(&output), shift, shift)); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	if len(output) != len(input) {
+		t.Fatal("Expected", len(input), "segments and got", len(output))
+	}
+	for index := range input {
+		if expected := input[index].End.Add(Coordinate{X: 20, PenUp: input[index].End.PenUp}); output[index].End != expected {
+			t.Error("Segment", index, "expected", expected, "and got", output[index].End)
+		}
+	}
+}
+
+// An error from one stage should stop the others and be returned
+func TestPipelineError(t *testing.T) {
+	failure := errors.New("transform failed")
+	failAfterOne := func(ctx context.Context, in <-chan Segment, out chan<- Segment) error {
+		<-in
+		return failure
+	}
+
+	var output Path
+	if err := runPipeline(t, context.Background(), NewPipeline(endlessSource, collectSink(&output), failAfterOne)); err != failure {
+		t.Error("Expected", failure, "and got", err)
+	}
+}
+
+// A panic in a stage should be returned as an error, with the stack showing where it happened
+func TestPipelinePanic(t *testing.T) {
+	panicking := func(ctx context.Context, in <-chan Segment) error {
+		panic("sink panicked")
+	}
+
+	err := runPipeline(t, context.Background(), NewPipeline(endlessSource, panicking))
+	if err == nil || !strings.HasPrefix(err.Error(), "stage panicked: sink panicked\n") || !strings.Contains(err.Error(), "TestPipelinePanic") {
+		t.Error("Expected the panic and its stack as an error and got", err)
+	}
+}
+
+// Cancelling the context should stop every stage
+func TestPipelineCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan bool)
+	slowSink := func(ctx context.Context, in <-chan Segment) error {
+		<-in
+		close(received)
+		<-ctx.Done()
+		return nil
+	}
+
+	go func() {
+		<-received
+		cancel()
+	}()
+
+	if err := runPipeline(t, ctx, NewPipeline(endlessSource, slowSink)); err != context.Canceled {
+		t.Error("Expected", context.Canceled, "and got", err)
+	}
+}
+
+// Optimizing should reorder glyphs, lifting the pen after the last one, but keep the start and the final return to the origin
+func TestOptimizeTransform(t *testing.T) {
+	input := Path{
+		LineTo(Coordinate{X: 0, Y: 0, PenUp: true}),
+		LineTo(Coordinate{X: 50, Y: 0, PenUp: true}),
+		LineTo(Coordinate{X: 60, Y: 0}),
+		LineTo(Coordinate{X: 10, Y: 0, PenUp: true}),
+		LineTo(Coordinate{X: 20, Y: 0}),
+		LineTo(Coordinate{X: 0, Y: 0, PenUp: true}),
+	}
+
+	var output Path
+	if err := runPipeline(t, context.Background(), NewPipeline(PathSource(input), collectSink(&output), OptimizeTransform())); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	expected := []Coordinate{{X: 0, Y: 0, PenUp: true}, {X: 10, Y: 0, PenUp: true}, {X: 20, Y: 0}, {X: 50, Y: 0, PenUp: true}, {X: 60, Y: 0}, {X: 60, Y: 0, PenUp: true}, {X: 0, Y: 0, PenUp: true}}
+	if len(output) != len(expected) {
+		t.Fatal("Expected", expected, "and got", output)
+	}
+	for index := range expected {
+		if output[index].End != expected[index] {
+			t.Error("Segment", index, "expected", expected[index], "and got", output[index].End)
+		}
+	}
+}
+
+// Curves should come out of the optimizer as curves, reversed along with their glyph where needed
+func TestOptimizeTransformKeepsCurves(t *testing.T) {
+	input := Path{
+		LineTo(Coordinate{X: 0, Y: 0, PenUp: true}),
+		LineTo(Coordinate{X: 50, Y: 0, PenUp: true}),
+		ArcAround(Coordinate{X: 50, Y: 0}, Coordinate{X: 45, Y: 0}, 2*math.Pi, false),
+		LineTo(Coordinate{X: 20, Y: 0, PenUp: true}),
+		CubicTo(Coordinate{X: 18, Y: 5}, Coordinate{X: 12, Y: 5}, Coordinate{X: 10, Y: 0}),
+		LineTo(Coordinate{X: 0, Y: 0, PenUp: true}),
+	}
+
+	var output Path
+	if err := runPipeline(t, context.Background(), NewPipeline(PathSource(input), collectSink(&output), OptimizeTransform())); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	if len(output) != 7 {
+		t.Fatal("Expected the 6 segments and a pen lift after the last glyph and got", output)
+	}
+	if cubic := output[2]; cubic.Kind != CubicKind || cubic.Control1 != (Coordinate{X: 12, Y: 5}) || !cubic.End.Same(Coordinate{X: 20, Y: 0}) {
+		t.Error("Expected the cubic drawn backwards from 10, 0 and got", output[1], cubic)
+	}
+	if arc := output[4]; arc.Kind != ArcKind || arc.Sweep != 2*math.Pi || !output[3].End.Same(Coordinate{X: 50, Y: 0}) {
+		t.Error("Expected the whole circle from 50, 0 and got", output[3], arc)
+	}
+}
@@ -0,0 +1,228 @@
+package polargraph
+
+// Plans the speed at each junction between moves by looking ahead over a window of upcoming segments
+
+import (
+	"math"
+)
+
+// Look ahead planner, keeps a window of upcoming targets and plans junction speeds over all of them with a backward and forward pass
+// so that the speed is only reduced where a later corner or stop actually requires it
+type LookAheadPlanner struct {
+	settings *SettingsData // motion limits and junction deviation
+
+	moves      []plannedMove // ring buffer of upcoming moves, measured when they are added
+	firstMove  int           // index in moves of the next move
+	movesCount int           // number of moves in the window
+	flushCount int           // number of moves at the start of the window that can be taken before it is full
+
+	origin     Coordinate // start of the next move
+	entrySpeed float64    // speed when leaving origin, the exit speed of the previous move
+
+	system PolarSystem // used to limit speed so that neither spool turns faster than it can be stepped
+	spool  WoundSpool
+
+	interp PositionInterpolater // decides how fast speed can change over a distance
+
+	speeds []float64 // junction speeds, index 0 is origin and index i is the end of the ith move in the window
+}
+
+// A move in the look ahead window, measured and speed limited once when it is added rather than every time the window is planned
+type plannedMove struct {
+	path      segmentPath // the segment measured along its length from where the move before it ends
+	moveSpeed float64     // max speed of the move
+}
+
+// Create a planner that looks ahead the given number of moves, starting stopped at origin
+func NewLookAheadPlanner(settings *SettingsData, window int, origin Coordinate, system PolarSystem, spool WoundSpool, interp PositionInterpolater) *LookAheadPlanner {
+	if window < 1 {
+		window = 1
+	}
+
+	return &LookAheadPlanner{
+		settings: settings,
+		moves:    make([]plannedMove, window),
+		origin:   origin,
+		system:   system,
+		spool:    spool,
+		interp:   interp,
+		speeds:   make([]float64, window+1),
+	}
+}
+
+// The move at index in the window, 0 is the next move
+func (planner *LookAheadPlanner) move(index int) *plannedMove {
+	if index < 0 || index >= planner.movesCount {
+		panic("Attempted to read outside of the planner window")
+	}
+	return &planner.moves[(planner.firstMove+index)%len(planner.moves)]
+}
+
+// Add a segment to the end of the window, measuring it and working out its max speed
+func (planner *LookAheadPlanner) Add(segment Segment) {
+	if planner.Full() {
+		panic("Attempted to overfill the planner window")
+	}
+
+	path := newSegmentPath(planner.point(planner.movesCount), segment)
+	planner.moves[(planner.firstMove+planner.movesCount)%len(planner.moves)] = plannedMove{path: path, moveSpeed: planner.moveSpeed(path)}
+	planner.movesCount++
+}
+
+// Number of segments waiting to be planned
+func (planner *LookAheadPlanner) Len() int {
+	return planner.movesCount
+}
+
+// True if the window is full and the next move can be planned
+func (planner *LookAheadPlanner) Full() bool {
+	return planner.movesCount == len(planner.moves)
+}
+
+// True if the next move can be planned, either the window is full or it has been flushed
+func (planner *LookAheadPlanner) Ready() bool {
+	return planner.Full() || planner.flushCount > 0
+}
+
+// Get the point at the given junction, 0 is the origin
+func (planner *LookAheadPlanner) point(junction int) Coordinate {
+	if junction == 0 {
+		return planner.origin
+	}
+	return planner.move(junction - 1).path.segment.End
+}
+
+// Max speed the pen can go through the junction between a move arriving in direction and leaving in nextDirection
+// Uses the junction deviation model, the corner is treated as an arc that stays within JunctionDeviation_MM of the sharp corner
+// and the speed is limited so that the centripetal acceleration around that arc is within the acceleration limit
+func (planner *LookAheadPlanner) junctionSpeed(direction, nextDirection Coordinate, acceleration float64) float64 {
+	settings := planner.settings
+
+	// cosine of the angle between the reversed arriving direction and the leaving direction, -1 is straight and 1 a full reversal
+	cosTheta := -direction.DotProduct(nextDirection)
+	if cosTheta < -0.999999 {
+		return settings.MaxSpeed_MM_S
+	}
+
+	sinHalfTheta := math.Sqrt(math.Max(0, (1-cosTheta)/2))
+	radius := settings.JunctionDeviation_MM * sinHalfTheta / (1 - sinHalfTheta)
+
+	return math.Min(settings.MaxSpeed_MM_S, math.Sqrt(acceleration*radius))
+}
+
+// Max speed for a move along path so that neither spool needs more than StepsMaxValue steps in a slice
+// Cord speed depends on where the pen is and which way it moves, so the Jacobian of the polar transform is sampled along the move
+// Moves with the pen down are also limited to the draw speed, and curves so the centripetal acceleration stays within the acceleration limit
+func (planner *LookAheadPlanner) moveSpeed(path segmentPath) float64 {
+	speed, acceleration, _ := planner.settings.MotionLimits(path.segment.End.PenUp)
+
+	if path.Length() == 0 {
+		return speed
+	}
+
+	// leave a step of margin for rounding up of the steps in each slice
+	maxSpoolSpeed := planner.settings.MaxSpeed_MM_S * (StepsMaxValue - 1) / StepsMaxValue
+
+	samples := 4
+	if path.segment.Kind != LineKind {
+		samples = 16
+	}
+	for sample := 0; sample <= samples; sample++ {
+		distance := path.Length() * float64(sample) / float64(samples)
+		position := path.PointAt(distance)
+		direction := path.DirectionAt(distance)
+
+		if curvature := path.CurvatureAt(distance); curvature > 0 {
+			speed = math.Min(speed, math.Sqrt(acceleration/curvature))
+		}
+
+		cordRate := position.PolarRate(planner.system, direction)
+		cordLength := Coordinate{X: position.X + planner.system.XOffset, Y: position.Y + planner.system.YOffset}.absoluteToPolar(planner.system)
+
+		leftRate := math.Abs(cordRate.LeftDist) * planner.spool.Rate(cordLength.LeftDist)
+		rightRate := math.Abs(cordRate.RightDist) * planner.spool.Rate(cordLength.RightDist)
+
+		if spoolRate := math.Max(leftRate, rightRate); spoolRate > 0 {
+			speed = math.Min(speed, maxSpoolSpeed/spoolRate)
+		}
+	}
+
+	return speed
+}
+
+// Plan speeds at every junction over the first count moves of the window, the end is assumed to be a stop since nothing is known beyond it
+func (planner *LookAheadPlanner) planSpeeds(count int) []float64 {
+	speeds := planner.speeds[:count+1]
+
+	speeds[0] = planner.entrySpeed
+	speeds[count] = 0
+
+	// limit each junction by the corner it makes and the moves on either side
+	for junction := 1; junction < count; junction++ {
+		move, nextMove := planner.move(junction-1), planner.move(junction)
+		path, nextPath := move.path, nextMove.path
+		current, next := planner.point(junction), planner.point(junction+1)
+
+		if path.Length() == 0 || nextPath.Length() == 0 || next.PenUp != current.PenUp {
+			// have to stop when not moving or for pen movement
+			speeds[junction] = 0
+		} else {
+			_, acceleration, _ := planner.settings.MotionLimits(next.PenUp)
+			speeds[junction] = planner.junctionSpeed(path.DirectionAt(path.Length()), nextPath.DirectionAt(0), acceleration)
+			speeds[junction] = math.Min(speeds[junction], math.Min(move.moveSpeed, nextMove.moveSpeed))
+		}
+	}
+
+	// backward pass, make sure there is room to decelerate into every later junction
+	for junction := count - 1; junction > 0; junction-- {
+		next := planner.move(junction).path
+		speeds[junction] = math.Min(speeds[junction], planner.interp.ReachableSpeed(speeds[junction+1], next.Length(), next.segment.End.PenUp))
+	}
+
+	// forward pass, make sure every junction can be reached by accelerating from the one before
+	for junction := 1; junction <= count; junction++ {
+		current := planner.move(junction - 1).path
+		speeds[junction] = math.Min(speeds[junction], planner.interp.ReachableSpeed(speeds[junction-1], current.Length(), current.segment.End.PenUp))
+	}
+
+	return speeds
+}
+
+// Remove the next move from the window, returning where it starts, the segment it follows, the speeds it should enter and exit with and the max speed in between
+func (planner *LookAheadPlanner) Next() (origin Coordinate, segment Segment, entrySpeed, maxSpeed, exitSpeed float64) {
+	speeds := planner.planSpeeds(planner.movesCount)
+
+	move := planner.move(0)
+	origin, entrySpeed = planner.origin, planner.entrySpeed
+	segment, maxSpeed, exitSpeed = move.path.segment, move.moveSpeed, speeds[1]
+
+	planner.firstMove = (planner.firstMove + 1) % len(planner.moves)
+	planner.movesCount--
+	if planner.flushCount > 0 {
+		planner.flushCount--
+	}
+	planner.origin, planner.entrySpeed = segment.End, exitSpeed
+	return
+}
+
+// Drop the moves at the end of the window that aren't needed to come to a stop from the current speed, for stopping as soon as possible
+// The window is always planned to stop at its end, so at worst every move is kept
+func (planner *LookAheadPlanner) Stop() {
+	for count := 1; count < planner.movesCount; count++ {
+		speeds := planner.planSpeeds(count)
+		first := planner.move(0).path
+		if planner.entrySpeed <= planner.interp.ReachableSpeed(speeds[1], first.Length(), first.segment.End.PenUp)+0.000001 {
+			planner.movesCount = count
+			if planner.flushCount > count {
+				planner.flushCount = count
+			}
+			return
+		}
+	}
+}
+
+// Let every move in the window be taken without waiting for it to fill, for when no more segments are coming for a while
+// The window is planned to stop at its end, moves added afterwards are still looked ahead at by the moves that haven't been taken
+func (planner *LookAheadPlanner) Flush() {
+	planner.flushCount = planner.movesCount
+}
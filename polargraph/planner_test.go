@@ -0,0 +1,279 @@
+package polargraph
+
+import (
+	"math"
+	"testing"
+)
+
+// Set the motion limits used by the planner for the duration of a test, the same limits are used with the pen up and down
+func withMotionSettings(t *testing.T, maxSpeed, acceleration float64) {
+	previous := Settings
+	t.Cleanup(func() { Settings = previous })
+
+	Settings.MaxSpeed_MM_S = maxSpeed
+	Settings.Acceleration_MM_S2 = acceleration
+	Settings.DrawMaxSpeed_MM_S = maxSpeed
+	Settings.DrawAcceleration_MM_S2 = acceleration
+}
+
+// PolarSystem with 0,0 in the middle of a large drawing surface
+var plannerTestSystem = PolarSystem{
+	XOffset:        500,
+	YOffset:        500,
+	XMin:           0,
+	XMax:           1000,
+	YMin:           0,
+	YMax:           1000,
+	RightMotorDist: 1000,
+}
+
+// Run all of the coordinates through a planner, returning the planned exit speed of each move
+func planAll(window int, coords []Coordinate) (exitSpeeds []float64) {
+	planner := NewLookAheadPlanner(&Settings, window, coords[0], plannerTestSystem, WoundSpool{}, &TrapezoidInterpolater{settings: &Settings})
+	remaining := coords[1:]
+
+	for len(remaining) > 0 || planner.Len() > 0 {
+		for len(remaining) > 0 && !planner.Full() {
+			planner.Add(LineTo(remaining[0]))
+			remaining = remaining[1:]
+		}
+		_, _, _, _, exitSpeed := planner.Next()
+		exitSpeeds = append(exitSpeeds, exitSpeed)
+	}
+	return
+}
+
+// Many short collinear segments should reach full speed instead of crawling
+func TestPlannerCollinearSegments(t *testing.T) {
+	withMotionSettings(t, 100, 1000)
+
+	coords := []Coordinate{{X: 0, Y: 0}}
+	for i := 1; i <= 100; i++ {
+		coords = append(coords, Coordinate{X: float64(i), Y: 0})
+	}
+
+	exitSpeeds := planAll(32, coords)
+
+	if exitSpeeds[50] != 100 {
+		t.Error("Expected full speed in the middle of the line and got", exitSpeeds[50])
+	}
+	if exitSpeeds[len(exitSpeeds)-1] != 0 {
+		t.Error("Expected to stop at the end and got", exitSpeeds[len(exitSpeeds)-1])
+	}
+}
+
+// Planned speeds should never need more than the acceleration allows between junctions
+func TestPlannerAccelerationLimits(t *testing.T) {
+	withMotionSettings(t, 100, 1000)
+
+	coords := []Coordinate{{X: 0, Y: 0}}
+	for i := 1; i <= 200; i++ {
+		angle := float64(i) * 0.05
+		coords = append(coords, Coordinate{X: 50 * math.Cos(angle), Y: 50 * math.Sin(angle), PenUp: i%40 == 0})
+	}
+
+	exitSpeeds := planAll(16, coords)
+
+	entrySpeed := 0.0
+	for index, exitSpeed := range exitSpeeds {
+		distance := coords[index+1].Minus(coords[index]).Len()
+		if math.Abs(exitSpeed*exitSpeed-entrySpeed*entrySpeed) > 2*1000*distance+0.000001 {
+			t.Error("Move", index, "goes from", entrySpeed, "to", exitSpeed, "over", distance)
+		}
+		if index+2 < len(coords) && coords[index+2].PenUp != coords[index+1].PenUp && exitSpeed != 0 {
+			t.Error("Move", index, "should stop for pen movement, exit speed was", exitSpeed)
+		}
+		entrySpeed = exitSpeed
+	}
+}
+
+// Right angle corners slow down to what the junction deviation allows, reversals have to stop
+func TestPlannerCorner(t *testing.T) {
+	withMotionSettings(t, 100, 1000)
+	Settings.JunctionDeviation_MM = 0.05
+
+	exitSpeeds := planAll(8, []Coordinate{{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 10}})
+	sinHalfTheta := math.Sqrt(0.5)
+	if expected := math.Sqrt(1000 * 0.05 * sinHalfTheta / (1 - sinHalfTheta)); math.Abs(exitSpeeds[0]-expected) > 0.000001 {
+		t.Error("Expected", expected, "at the corner and got", exitSpeeds[0])
+	}
+
+	exitSpeeds = planAll(8, []Coordinate{{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 0, Y: 0}})
+	if exitSpeeds[0] != 0 {
+		t.Error("Expected to stop when reversing and got", exitSpeeds[0])
+	}
+}
+
+// Around a polygon the centripetal acceleration through the corner arc must stay within the acceleration limit
+func TestPlannerJunctionAcceleration(t *testing.T) {
+	withMotionSettings(t, 100, 1000)
+	Settings.JunctionDeviation_MM = 0.05
+
+	for _, sides := range []int{3, 5, 12, 90} {
+		coords := []Coordinate{}
+		for i := 0; i <= sides; i++ {
+			angle := 2 * math.Pi * float64(i) / float64(sides)
+			coords = append(coords, Coordinate{X: 50 * math.Cos(angle), Y: 50 * math.Sin(angle)})
+		}
+
+		exitSpeeds := planAll(32, coords)
+
+		// corner angle of a regular polygon, measured between the reversed arriving and the leaving direction
+		theta := math.Pi - 2*math.Pi/float64(sides)
+		sinHalfTheta := math.Sin(theta / 2)
+		radius := 0.05 * sinHalfTheta / (1 - sinHalfTheta)
+		for index, speed := range exitSpeeds[:len(exitSpeeds)-1] {
+			if speed*speed/radius > 1000*(1+0.000001) {
+				t.Error(sides, "sided polygon corner", index, "at", speed, "needs acceleration", speed*speed/radius)
+			}
+		}
+
+		// gentle corners should not have to slow down much
+		if sides == 90 && exitSpeeds[45] < 50 {
+			t.Error("Expected gentle corners to be fast and got", exitSpeeds[45])
+		}
+	}
+}
+
+// Moves straight away from a spool turn it as fast as the pen moves, so have to be capped below the max step rate
+func TestPlannerMoveSpeedCordLimit(t *testing.T) {
+	withMotionSettings(t, 100, 1000)
+	planner := NewLookAheadPlanner(&Settings, 1, Coordinate{}, plannerTestSystem, WoundSpool{}, &TrapezoidInterpolater{settings: &Settings})
+	maxSpoolSpeed := 100 * (StepsMaxValue - 1) / StepsMaxValue
+
+	// diagonal from the left motor, only the left cord changes at the full pen speed
+	diagonal := Coordinate{X: 10, Y: 10}.Normalized()
+	if speed := planner.moveSpeed(newSegmentPath(Coordinate{}, LineTo(diagonal.Scaled(10)))); math.Abs(speed-maxSpoolSpeed) > 0.00001 {
+		t.Error("Expected speed to be limited to", maxSpoolSpeed, "and got", speed)
+	}
+
+	// horizontal at the center, both cords change slower than the pen
+	if speed := planner.moveSpeed(newSegmentPath(Coordinate{}, LineTo(Coordinate{X: 10}))); speed != 100 {
+		t.Error("Expected full speed and got", speed)
+	}
+
+	// a thin wound spool turns faster than the cord moves, so speed has to drop further
+	planner.spool = WoundSpool{CordThickness_MM: 0.5, CoreDiameter_MM: 10, WrapsPerLayer: 1000, CordLength_MM: 5000, Circumference_MM: 60}
+	expected := maxSpoolSpeed / planner.spool.Rate(Coordinate{X: 500, Y: 500}.Len())
+	if speed := planner.moveSpeed(newSegmentPath(Coordinate{}, LineTo(diagonal.Scaled(0.001)))); math.Abs(speed-expected) > 0.01 {
+		t.Error("Expected speed to be limited to", expected, "and got", speed)
+	}
+}
+
+// Pen down moves are limited to the draw speed while pen up travel can go at full speed
+func TestPlannerDrawSpeed(t *testing.T) {
+	withMotionSettings(t, 100, 1000)
+	Settings.DrawMaxSpeed_MM_S = 20
+	Settings.DrawAcceleration_MM_S2 = 200
+
+	coords := []Coordinate{{X: 0, Y: 0, PenUp: true}}
+	for i := 1; i <= 100; i++ {
+		coords = append(coords, Coordinate{X: float64(i), Y: 0, PenUp: i <= 50})
+	}
+
+	exitSpeeds := planAll(32, coords)
+
+	if exitSpeeds[25] != 100 {
+		t.Error("Expected full speed while travelling and got", exitSpeeds[25])
+	}
+	if exitSpeeds[75] != 20 {
+		t.Error("Expected draw speed while drawing and got", exitSpeeds[75])
+	}
+
+	// drawing accelerates from the stop for the pen movement at the lower draw acceleration
+	if expected := math.Sqrt(2 * 200 * 1.0); math.Abs(exitSpeeds[50]-expected) > 0.000001 {
+		t.Error("Expected first drawing move to reach", expected, "and got", exitSpeeds[50])
+	}
+}
+
+// Moving around a tight arc has to slow down so the centripetal acceleration stays within the limit
+func TestPlannerArcSpeed(t *testing.T) {
+	withMotionSettings(t, 100, 1000)
+	planner := NewLookAheadPlanner(&Settings, 1, Coordinate{}, plannerTestSystem, WoundSpool{}, &TrapezoidInterpolater{settings: &Settings})
+
+	arc := ArcAround(Coordinate{}, Coordinate{X: 2}, math.Pi, false)
+	if speed, expected := planner.moveSpeed(newSegmentPath(Coordinate{}, arc)), math.Sqrt(1000*2.0); math.Abs(speed-expected) > 0.000001 {
+		t.Error("Expected speed to be limited to", expected, "and got", speed)
+	}
+
+	// the interpolater should follow the arc without going faster than planned
+	interp := &TrapezoidInterpolater{settings: &Settings}
+	interp.Setup(Coordinate{}, arc, 0, math.Sqrt(2000), 0)
+	previous := Coordinate{}
+	for slice := 1.0; slice <= interp.Slices(); slice++ {
+		position := interp.Position(slice)
+		if radius := position.Minus(Coordinate{X: 2}).Len(); math.Abs(radius-2) > 0.000001 {
+			t.Error("Left the arc at slice", slice, "radius", radius)
+		}
+		if step := position.Minus(previous).Len(); step > math.Sqrt(2000)*TimeSlice_US/1000000 {
+			t.Error("Moved too far at slice", slice, step)
+		}
+		previous = position
+	}
+}
+
+// Stopping should keep just enough of the window to slow down from the current speed, and come to rest at its end
+func TestPlannerStop(t *testing.T) {
+	withMotionSettings(t, 100, 1000)
+	planner := NewLookAheadPlanner(&Settings, 32, Coordinate{}, plannerTestSystem, WoundSpool{}, &TrapezoidInterpolater{settings: &Settings})
+
+	x := 0.0
+	for !planner.Full() {
+		x++
+		planner.Add(LineTo(Coordinate{X: x}))
+	}
+	for i := 0; i < 10; i++ {
+		planner.Next()
+		x++
+		planner.Add(LineTo(Coordinate{X: x}))
+	}
+	entrySpeed := planner.entrySpeed
+	if entrySpeed == 0 {
+		t.Fatal("Expected to be moving before stopping")
+	}
+
+	planner.Stop()
+	// decelerating from entrySpeed at 1000 mm/s^2 takes entrySpeed^2/2000 mm, and each move is 1mm
+	if expected := int(math.Ceil(entrySpeed * entrySpeed / 2000)); planner.Len() != expected {
+		t.Error("Expected", expected, "moves to stop from", entrySpeed, "and got", planner.Len())
+	}
+
+	exitSpeed := entrySpeed
+	for planner.Len() > 0 {
+		_, _, _, _, exitSpeed = planner.Next()
+	}
+	if exitSpeed != 0 {
+		t.Error("Expected to stop at the end of the window and got", exitSpeed)
+	}
+}
+
+// Flushing should let the moves already in the window be taken, planned to stop at the last of them
+func TestPlannerFlush(t *testing.T) {
+	withMotionSettings(t, 100, 1000)
+	planner := NewLookAheadPlanner(&Settings, 32, Coordinate{}, plannerTestSystem, WoundSpool{}, &TrapezoidInterpolater{settings: &Settings})
+
+	for x := 1.0; x <= 3; x++ {
+		planner.Add(LineTo(Coordinate{X: x * 10}))
+	}
+	if planner.Ready() {
+		t.Fatal("Expected to wait for the window to fill before flushing")
+	}
+
+	planner.Flush()
+	planner.Add(LineTo(Coordinate{X: 40}))
+
+	exitSpeed := 0.0
+	for taken := 0; taken < 3; taken++ {
+		if !planner.Ready() {
+			t.Fatal("Expected the flushed moves to be ready and only", taken, "were")
+		}
+		_, _, _, _, exitSpeed = planner.Next()
+	}
+	if planner.Ready() {
+		t.Error("Expected the move added after flushing to wait for the window to fill")
+	}
+	// the move added after the flush is looked ahead at, so the last flushed move doesn't have to stop
+	if exitSpeed == 0 {
+		t.Error("Expected to keep moving into the move added after flushing")
+	}
+}
@@ -0,0 +1,199 @@
+package polargraph
+
+// Saves a processed path so it can be plotted again without parsing and optimizing it, or viewed as an svg
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Identifies plot files, and the version of their layout
+const (
+	plotFileFormat  = "gocupi-plot"
+	plotFileVersion = 1
+)
+
+// What is stored in a plot file, the path is already placed on the drawing surface
+type plotFile struct {
+	Format  string
+	Version int
+	Path    Path
+}
+
+// Whether the file is written as JSON rather than the more compact gob encoding
+func isJsonPlotFile(fileName string) bool {
+	return strings.ToLower(filepath.Ext(fileName)) == ".json"
+}
+
+// Write the path to a plot file, as JSON if the name ends in .json and gob otherwise
+func WritePlotFile(fileName string, path Path) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	contents := plotFile{Format: plotFileFormat, Version: plotFileVersion, Path: path}
+	if isJsonPlotFile(fileName) {
+		err = json.NewEncoder(file).Encode(contents)
+	} else {
+		err = gob.NewEncoder(file).Encode(contents)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Read the path from a plot file written by WritePlotFile
+func ReadPlotFile(fileName string) (Path, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var contents plotFile
+	if isJsonPlotFile(fileName) {
+		err = json.NewDecoder(file).Decode(&contents)
+	} else {
+		err = gob.NewDecoder(file).Decode(&contents)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read plot file %s: %v", fileName, err)
+	}
+	if contents.Format != plotFileFormat || contents.Version != plotFileVersion {
+		return nil, fmt.Errorf("%s is not a version %d plot file", fileName, plotFileVersion)
+	}
+	return contents.Path, nil
+}
+
+// Whether the glyph is drawn with straight lines only
+func (g Glyph) straight() bool {
+	for _, segment := range g.Segments {
+		if segment.Kind != LineKind {
+			return false
+		}
+	}
+	return true
+}
+
+// Write the glyph as an svg polyline, or as an svg path when it has curves so they stay curves
+func writeSvgGlyph(writer io.Writer, glyph Glyph) {
+	if glyph.straight() {
+		fmt.Fprint(writer, `<polyline points="`)
+		for index, segment := range glyph.Segments {
+			if index > 0 {
+				fmt.Fprint(writer, " ")
+			}
+			fmt.Fprintf(writer, "%.3f,%.3f", segment.End.X, segment.End.Y)
+		}
+		fmt.Fprintln(writer, `"/>`)
+		return
+	}
+
+	fmt.Fprintf(writer, `<path d="M %.3f,%.3f`, glyph.start().X, glyph.start().Y)
+	for index := 1; index < len(glyph.Segments); index++ {
+		origin, segment := glyph.Segments[index-1].End, glyph.Segments[index]
+		switch segment.Kind {
+		case ArcKind:
+			// an svg arc can't go all the way round, so it is drawn in two halves that each turn no more than half way
+			radius := origin.Minus(segment.Center).Len()
+			sweepFlag := 0
+			if segment.Sweep > 0 {
+				sweepFlag = 1
+			}
+			middle := segment.Point(origin, 0.5)
+			fmt.Fprintf(writer, " A %.3f,%.3f 0 0 %d %.3f,%.3f", radius, radius, sweepFlag, middle.X, middle.Y)
+			fmt.Fprintf(writer, " A %.3f,%.3f 0 0 %d %.3f,%.3f", radius, radius, sweepFlag, segment.End.X, segment.End.Y)
+		case CubicKind:
+			fmt.Fprintf(writer, " C %.3f,%.3f %.3f,%.3f %.3f,%.3f", segment.Control1.X, segment.Control1.Y, segment.Control2.X, segment.Control2.Y, segment.End.X, segment.End.Y)
+		default:
+			fmt.Fprintf(writer, " L %.3f,%.3f", segment.End.X, segment.End.Y)
+		}
+	}
+	fmt.Fprintln(writer, `"/>`)
+}
+
+// Write the pen down segments of the path in mm, one svg polyline or path for each glyph
+// The view box covers everywhere the pen goes so the drawing keeps its position relative to the origin
+func WriteSvgFile(fileName string, path Path) error {
+	glyphs, err := MakeGlyphs(path)
+	if err != nil {
+		return err
+	}
+
+	min, max := Coordinate{}, Coordinate{}
+	for _, glyph := range glyphs {
+		for _, segment := range glyph.Flattened(Settings.StepSize_MM).Segments {
+			min.X, min.Y = math.Min(min.X, segment.End.X), math.Min(min.Y, segment.End.Y)
+			max.X, max.Y = math.Max(max.X, segment.End.X), math.Max(max.Y, segment.End.Y)
+		}
+	}
+	size := max.Minus(min)
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+
+	fmt.Fprintln(writer, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintf(writer, `<svg xmlns="http://www.w3.org/2000/svg" width="%.3fmm" height="%.3fmm" viewBox="%.3f %.3f %.3f %.3f">`+"\n", size.X, size.Y, min.X, min.Y, size.X, size.Y)
+	fmt.Fprintln(writer, `<g fill="none" stroke="black" stroke-width="0.3" stroke-linecap="round" stroke-linejoin="round">`)
+	for _, glyph := range glyphs {
+		if len(glyph.Segments) < 2 {
+			continue
+		}
+		writeSvgGlyph(writer, glyph)
+	}
+	fmt.Fprintln(writer, "</g>")
+	fmt.Fprintln(writer, "</svg>")
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// A sink that saves the path to fileName, as an svg if it ends in .svg and as a plot file otherwise, nothing is saved for an empty path
+func ExportSink(fileName string) Sink {
+	return func(ctx context.Context, in <-chan Segment) error {
+		path, err := CollectPath(ctx, in)
+		if err != nil || len(path) == 0 {
+			return err
+		}
+
+		if strings.ToLower(filepath.Ext(fileName)) == ".svg" {
+			err = WriteSvgFile(fileName, path)
+		} else {
+			err = WritePlotFile(fileName, path)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(Log, "Exported", len(path), "segments to", fileName)
+		return nil
+	}
+}
+
+// A source that sends the path saved in a plot file, as it was placed when it was saved
+func PlotFileSource(fileName string) Source {
+	return func(ctx context.Context, out chan<- Segment) error {
+		path, err := ReadPlotFile(fileName)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(Log, "Loaded", len(path), "segments from", fileName)
+		return SendPath(ctx, out, path)
+	}
+}
@@ -0,0 +1,123 @@
+package polargraph
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A path with every kind of segment
+func plotFilePath() Path {
+	return Path{
+		LineTo(Coordinate{X: 10, Y: 10, PenUp: true}),
+		LineTo(Coordinate{X: 20, Y: 10}),
+		ArcAround(Coordinate{X: 20, Y: 10}, Coordinate{X: 20, Y: 15}, math.Pi, false),
+		CubicTo(Coordinate{X: 15, Y: 25}, Coordinate{X: 10, Y: 25}, Coordinate{X: 10, Y: 20}),
+		LineTo(Coordinate{X: 40, Y: 5, PenUp: true}),
+		LineTo(Coordinate{X: 45, Y: 5}),
+		LineTo(Coordinate{X: 0, Y: 0, PenUp: true}),
+	}
+}
+
+// Both encodings should give back exactly the path that was written
+func TestPlotFileRoundTrip(t *testing.T) {
+	for _, name := range []string{"drawing.plot", "drawing.json"} {
+		fileName := filepath.Join(t.TempDir(), name)
+		if err := WritePlotFile(fileName, plotFilePath()); err != nil {
+			t.Fatal(err)
+		}
+
+		path, err := ReadPlotFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(path, plotFilePath()) {
+			t.Error("Expected", name, "to load", plotFilePath(), "and got", path)
+		}
+	}
+}
+
+// Files that aren't plot files should be rejected
+func TestPlotFileWrongFormat(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "drawing.json")
+	if err := ioutil.WriteFile(fileName, []byte(`{"Format": "something else", "Version": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadPlotFile(fileName); err == nil {
+		t.Error("Expected an error reading a file of another format")
+	}
+}
+
+// Exporting then plotting the file should send the same segments through the pipeline
+func TestExportAndPlot(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "drawing.plot")
+	if err := NewPipeline(PathSource(plotFilePath()), ExportSink(fileName)).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var path Path
+	if err := NewPipeline(PlotFileSource(fileName), collectSink(&path)).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(path, plotFilePath()) {
+		t.Error("Expected", plotFilePath(), "and got", path)
+	}
+}
+
+// Each glyph should be a polyline, or a path when it has curves, in a view box around everywhere the pen goes
+func TestWriteSvgFile(t *testing.T) {
+	defer func(settings SettingsData) { Settings = settings }(Settings)
+	Settings = goldenSettings("")
+
+	fileName := filepath.Join(t.TempDir(), "drawing.svg")
+	if err := NewPipeline(PathSource(plotFilePath()), ExportSink(fileName)).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg := string(data)
+	if count := strings.Count(svg, "<polyline"); count != 1 {
+		t.Error("Expected 1 polyline and got", count, svg)
+	}
+	// the half circle is drawn as two quarters, and the cubic keeps its control points
+	if !strings.Contains(svg, `<path d="M 10.000,10.000 L 20.000,10.000 A 5.000,5.000 0 0 1 25.000,15.000 A 5.000,5.000 0 0 1 20.000,20.000 C 15.000,25.000 10.000,25.000 10.000,20.000"/>`) {
+		t.Error("Expected the glyph with curves as a path of lines, arcs and cubics and got", svg)
+	}
+	// the curve doesn't reach its control points, so the top is the highest point on it
+	if !strings.Contains(svg, `viewBox="0.000 0.000 45.000 23.733"`) || !strings.Contains(svg, `width="45.000mm"`) {
+		t.Error("Expected a 45 by 23.733mm view box from the origin and got", svg)
+	}
+	if !strings.Contains(svg, `<polyline points="40.000,5.000 45.000,5.000"/>`) {
+		t.Error("Expected the last line as its own polyline and got", svg)
+	}
+}
+
+// An empty path should export nothing without failing, and an empty svg can still be written
+func TestExportEmpty(t *testing.T) {
+	for _, name := range []string{"drawing.plot", "drawing.svg"} {
+		fileName := filepath.Join(t.TempDir(), name)
+		if err := NewPipeline(PathSource(nil), ExportSink(fileName)).Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+			t.Error("Expected nothing to be exported to", name, "and got", err)
+		}
+	}
+
+	fileName := filepath.Join(t.TempDir(), "empty.svg")
+	if err := WriteSvgFile(fileName, nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(fileName); err != nil || strings.Contains(string(data), "<polyline") {
+		t.Error("Expected an svg with no polylines and got", string(data), err)
+	}
+}
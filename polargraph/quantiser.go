@@ -0,0 +1,54 @@
+package polargraph
+
+// Turns the ideal spool movement in each slice into the whole number of fixed point steps that can be sent
+
+import (
+	"fmt"
+)
+
+// Quantises the movement of both spools one slice at a time
+type StepQuantiser struct {
+	mode string
+
+	// only used by sigmadelta, the target of the last slice and the correction added to it
+	previousTarget     PolarCoordinate
+	previousCorrection PolarCoordinate
+	started            bool
+}
+
+// Create the quantiser with the given name, as set in Settings.Quantiser
+// ceil always rounds up, round rounds to the nearest step and carries the error forward,
+// sigmadelta also feeds back the error from the slice before so the remaining error jitters at a higher frequency
+func NewStepQuantiser(mode string) (*StepQuantiser, error) {
+	switch mode {
+	case "", "ceil", "round", "sigmadelta":
+		return &StepQuantiser{mode: mode}, nil
+	default:
+		return nil, fmt.Errorf("Unknown quantiser: %s", mode)
+	}
+}
+
+// Number of steps to take this slice to move from position towards target, both in units of fixed point steps
+// Because the steps are measured from where the spool actually is, any error left by earlier slices is carried forward
+func (quantiser *StepQuantiser) Quantise(position, target PolarCoordinate) PolarCoordinate {
+	movement := target.Minus(position)
+
+	switch quantiser.mode {
+	case "round":
+		return movement.Round()
+	case "sigmadelta":
+		// the rounding of the last slice is subtracted from this one, so the position error is the difference of two rounding
+		// errors, which cancels out over pairs of slices instead of drifting at low frequencies
+		lastRounding := PolarCoordinate{}
+		if quantiser.started {
+			lastRounding = position.Minus(quantiser.previousTarget).Minus(quantiser.previousCorrection)
+		}
+		correction := lastRounding.Scaled(-1)
+		steps := movement.Add(correction).Round()
+
+		quantiser.previousTarget, quantiser.previousCorrection, quantiser.started = target, correction, true
+		return steps
+	default:
+		return movement.Ceil()
+	}
+}
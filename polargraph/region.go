@@ -0,0 +1,123 @@
+package polargraph
+
+// Orders glyphs one region of the canvas at a time, so the gondola isn't sent on long swinging jumps across the board
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Distance along a Hilbert curve filling a size by size grid of the cell at x, y, size has to be a power of two
+// Cells next to each other along the curve always share an edge, so following it never jumps across the grid
+func hilbertDistance(size, x, y int) (distance int) {
+	for scale := size / 2; scale > 0; scale /= 2 {
+		rx, ry := 0, 0
+		if x&scale > 0 {
+			rx = 1
+		}
+		if y&scale > 0 {
+			ry = 1
+		}
+		distance += scale * scale * ((3 * rx) ^ ry)
+
+		// rotate the quadrant so the curve inside it lines up with the rest
+		if ry == 0 {
+			if rx == 1 {
+				x, y = size-1-x, size-1-y
+			}
+			x, y = y, x
+		}
+	}
+	return
+}
+
+// Middle of the box around the ends of the glyph's segments, used to decide which region it belongs to
+func (g Glyph) center() Coordinate {
+	min, max := g.start(), g.start()
+	for _, segment := range g.Segments {
+		coord := segment.End
+		min.X, min.Y = math.Min(min.X, coord.X), math.Min(min.Y, coord.Y)
+		max.X, max.Y = math.Max(max.X, coord.X), math.Max(max.Y, coord.Y)
+	}
+	return Coordinate{X: (min.X + max.X) / 2, Y: (min.Y + max.Y) / 2}
+}
+
+// Longest pen up move between the glyphs
+func LongestPenUpMove(glyphs []Glyph) (longest float64) {
+	for i := 1; i < len(glyphs); i++ {
+		longest = math.Max(longest, glyphs[i-1].DistanceTo(glyphs[i]))
+	}
+	return
+}
+
+// Split the canvas into square regions of regionSize and draw them in the order of a Hilbert curve through them
+// Each region is finished before moving on to the next, with the nearest glyph drawn next inside it, the first glyph stays first
+// Glyphs that start within mergeTolerance of where the last one ends are merged
+func ReorderGlyphsByRegion(glyphs []Glyph, regionSize, mergeTolerance float64) (sorted []Glyph) {
+	if len(glyphs) == 0 {
+		return
+	}
+
+	penUpDistanceBefore := TotalPenUpTravelForGlyphs(glyphs)
+	fmt.Fprintln(Log, "Reordering by", regionSize, "mm regions, starting penUp distance:", penUpDistanceBefore, "longest move:", LongestPenUpMove(glyphs))
+
+	// region of every glyph but the first, counted from the corner of the box around them all
+	centers := make([]Coordinate, len(glyphs))
+	min := glyphs[len(glyphs)-1].center()
+	for index := 1; index < len(glyphs); index++ {
+		centers[index] = glyphs[index].center()
+		min.X, min.Y = math.Min(min.X, centers[index].X), math.Min(min.Y, centers[index].Y)
+	}
+
+	type region struct{ x, y int }
+	members := make(map[region][]int)
+	size := 1
+	for index := 1; index < len(glyphs); index++ {
+		cell := region{int((centers[index].X - min.X) / regionSize), int((centers[index].Y - min.Y) / regionSize)}
+		members[cell] = append(members[cell], index)
+		for cell.x >= size || cell.y >= size {
+			size *= 2
+		}
+	}
+
+	regions := make([]region, 0, len(members))
+	for cell := range members {
+		regions = append(regions, cell)
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		return hilbertDistance(size, regions[i].x, regions[i].y) < hilbertDistance(size, regions[j].x, regions[j].y)
+	})
+
+	sorted = append(sorted, glyphs[0])
+	for _, cell := range regions {
+		regionGlyphs := make([]Glyph, len(members[cell]))
+		for number, index := range members[cell] {
+			regionGlyphs[number] = glyphs[index]
+		}
+
+		// nearest glyph in the region to wherever the pen is, the same as ReorderGlyphs
+		index := newGlyphIndex(regionGlyphs)
+		for index.Len() > 0 {
+			last := sorted[len(sorted)-1]
+			closest, reversed, _ := index.Nearest(last.end())
+			index.Remove(closest)
+
+			next := regionGlyphs[closest]
+			if reversed {
+				next = next.Reversed()
+			}
+
+			if last.CanBeMergedWith(next, mergeTolerance) {
+				sorted[len(sorted)-1] = last.MergeWith(next)
+			} else {
+				sorted = append(sorted, next)
+			}
+		}
+	}
+
+	penUpDistanceAfter := TotalPenUpTravelForGlyphs(sorted)
+	fmt.Fprintln(Log, "Done,", len(regions), "regions, penUp distance:", penUpDistanceAfter, "longest move:", LongestPenUpMove(sorted))
+
+	return sorted
+}
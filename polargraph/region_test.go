@@ -0,0 +1,92 @@
+package polargraph
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Every cell should be visited once, each one next to the cell before
+func TestHilbertDistance(t *testing.T) {
+	const size = 16
+	cells := make([][2]int, size*size)
+	seen := make([]bool, size*size)
+	for x := 0; x < size; x++ {
+		for y := 0; y < size; y++ {
+			distance := hilbertDistance(size, x, y)
+			if seen[distance] {
+				t.Fatal("Distance", distance, "given to more than one cell")
+			}
+			seen[distance] = true
+			cells[distance] = [2]int{x, y}
+		}
+	}
+
+	for distance := 1; distance < len(cells); distance++ {
+		dx, dy := cells[distance][0]-cells[distance-1][0], cells[distance][1]-cells[distance-1][1]
+		if dx*dx+dy*dy != 1 {
+			t.Error("Cell", cells[distance], "at", distance, "isn't next to", cells[distance-1])
+		}
+	}
+}
+
+// Each region should be finished before the next is started
+func TestReorderGlyphsByRegion(t *testing.T) {
+	const regionSize = 10
+	random := rand.New(rand.NewSource(1))
+
+	glyphs := []Glyph{GlyphFromCoordinates([]Coordinate{{X: 20, Y: 0, PenUp: true}})}
+	for count := 0; count < 400; count++ {
+		x, y := 40*random.Float64(), 40*random.Float64()
+		glyphs = append(glyphs, GlyphFromCoordinates([]Coordinate{{X: x, Y: y, PenUp: true}, {X: x + 0.5, Y: y}}))
+	}
+
+	min := glyphs[1].center()
+	for _, glyph := range glyphs[1:] {
+		center := glyph.center()
+		min.X, min.Y = math.Min(min.X, center.X), math.Min(min.Y, center.Y)
+	}
+	regionOf := func(glyph Glyph) [2]int {
+		center := glyph.center()
+		return [2]int{int((center.X - min.X) / regionSize), int((center.Y - min.Y) / regionSize)}
+	}
+
+	sorted := ReorderGlyphsByRegion(copyGlyphs(glyphs), regionSize, 0)
+	if len(sorted) != len(glyphs) {
+		t.Fatal("Expected", len(glyphs), "glyphs and got", len(sorted))
+	}
+	if !sorted[0].Equals(glyphs[0]) {
+		t.Error("Expected the first glyph to stay first and got", sorted[0])
+	}
+
+	finished := make(map[[2]int]bool)
+	for index := 2; index < len(sorted); index++ {
+		previous, current := regionOf(sorted[index-1]), regionOf(sorted[index])
+		if previous != current {
+			if finished[current] {
+				t.Fatal("Region", current, "was returned to at glyph", index)
+			}
+			finished[previous] = true
+		}
+	}
+	if len(finished) != 15 {
+		t.Error("Expected 16 regions and finished", len(finished), "before the last")
+	}
+}
+
+// With a single region the order should be the same as ReorderGlyphs
+func TestReorderGlyphsByRegionMatchesNearest(t *testing.T) {
+	glyphs := randomGlyphs(rand.New(rand.NewSource(2)), 300, 30)
+
+	expected := reorderGlyphs(t, copyGlyphs(glyphs), 0)
+	sorted := ReorderGlyphsByRegion(copyGlyphs(glyphs), 1000, 0)
+
+	if len(sorted) != len(expected) {
+		t.Fatal("Expected", len(expected), "glyphs and got", len(sorted))
+	}
+	for index := range expected {
+		if !sorted[index].Equals(expected[index]) {
+			t.Fatal("Glyph", index, "expected", expected[index], "and got", sorted[index])
+		}
+	}
+}
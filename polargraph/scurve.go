@@ -0,0 +1,233 @@
+package polargraph
+
+// Manages the jerk limited S-curve interpolation
+
+import (
+	"fmt"
+	"math"
+)
+
+// A change of speed with limited acceleration and jerk, acceleration ramps up, holds and ramps back down to 0
+// The profile is symmetric so the distance covered is the average speed multiplied by the duration
+type sCurveTransition struct {
+	startSpeed float64
+	sign       float64 // 1 when accelerating, -1 when decelerating
+	peakAccel  float64 // highest acceleration reached, less than the max when the speed change is small
+	jerk       float64
+	jerkTime   float64 // time spent ramping acceleration up, and again ramping it down
+	constTime  float64 // time spent at peak acceleration
+}
+
+// Setup a transition from startSpeed to endSpeed
+func newSCurveTransition(startSpeed, endSpeed, acceleration, jerk float64) (transition sCurveTransition) {
+	transition.startSpeed = startSpeed
+	transition.jerk = jerk
+	transition.sign = 1
+	if endSpeed < startSpeed {
+		transition.sign = -1
+	}
+
+	speedChange := math.Abs(endSpeed - startSpeed)
+	if speedChange*jerk >= acceleration*acceleration {
+		// reaches full acceleration
+		transition.peakAccel = acceleration
+		transition.jerkTime = acceleration / jerk
+		transition.constTime = speedChange/acceleration - transition.jerkTime
+	} else {
+		transition.peakAccel = math.Sqrt(speedChange * jerk)
+		transition.jerkTime = transition.peakAccel / jerk
+		transition.constTime = 0
+	}
+
+	if math.IsNaN(transition.jerkTime) { // no speed change and infinite jerk
+		transition.jerkTime = 0
+	}
+
+	return
+}
+
+// Time taken by the transition
+func (transition sCurveTransition) duration() float64 {
+	return 2*transition.jerkTime + transition.constTime
+}
+
+// Distance covered by the transition
+func (transition sCurveTransition) distance() float64 {
+	return transition.distanceAt(transition.duration())
+}
+
+// Distance covered after the given time into the transition
+func (transition sCurveTransition) distanceAt(time float64) float64 {
+	sign, jerk, peakAccel := transition.sign, transition.jerk, transition.peakAccel
+
+	// ramping acceleration up
+	rampTime := math.Min(time, transition.jerkTime)
+	distance := transition.startSpeed*rampTime + sign*jerk*rampTime*rampTime*rampTime/6
+	if time <= transition.jerkTime {
+		return distance
+	}
+	speed := transition.startSpeed + sign*jerk*transition.jerkTime*transition.jerkTime/2
+
+	// constant acceleration
+	constTime := math.Min(time-transition.jerkTime, transition.constTime)
+	distance += speed*constTime + sign*peakAccel*constTime*constTime/2
+	if time <= transition.jerkTime+transition.constTime {
+		return distance
+	}
+	speed += sign * peakAccel * transition.constTime
+
+	// ramping acceleration down
+	rampTime = math.Min(time-transition.jerkTime-transition.constTime, transition.jerkTime)
+	return distance + speed*rampTime + sign*(peakAccel*rampTime*rampTime/2-jerk*rampTime*rampTime*rampTime/6)
+}
+
+// Distance needed to change from one speed to another
+func sCurveDistance(startSpeed, endSpeed, acceleration, jerk float64) float64 {
+	transition := newSCurveTransition(startSpeed, endSpeed, acceleration, jerk)
+	return (startSpeed + endSpeed) / 2 * transition.duration()
+}
+
+// Data needed by the S-curve interpolater
+type SCurveInterpolater struct {
+	settings *SettingsData // source of the motion limits
+
+	origin      Coordinate  // positions currently interpolating from
+	destination Coordinate  // position currently interpolating towards
+	path        segmentPath // segment being followed from origin to destination
+
+	entrySpeed  float64 // speed at beginning at origin
+	cruiseSpeed float64 // maximum speed reached
+	exitSpeed   float64 // speed when we reach destination, only differs from the planned speed if it can't be reached over the distance
+
+	accel sCurveTransition // change from entry to cruise speed
+	decel sCurveTransition // change from cruise to exit speed
+
+	distance   float64 // total distance travelled
+	cruiseTime float64 // time cruising at max speed
+	cruiseDist float64 // distance covered while cruising
+	time       float64 // total time to go from origin to destination
+	slices     float64 // number of TimeSlice_US slices
+}
+
+func (data *SCurveInterpolater) WriteData() {
+	fmt.Println("Origin:", data.origin, "Dest:", data.destination)
+	fmt.Println("Kind:", data.path.segment.Kind, "Slices:", data.slices)
+	fmt.Println()
+
+	fmt.Println("Entry", data.entrySpeed, "Cruise", data.cruiseSpeed, "Exit", data.exitSpeed)
+
+	fmt.Println("Taccel", data.accel.duration(), "Tcruise", data.cruiseTime, "Tdecel", data.decel.duration())
+	fmt.Println("Daccel", data.accel.distance(), "Dcruise", data.cruiseDist, "Ddecel", data.decel.distance())
+
+	fmt.Println("Total distance", data.distance)
+}
+
+// Max speed that can be reached from speed over distance, found by bisection since the S-curve distance has no simple inverse
+func (data *SCurveInterpolater) ReachableSpeed(speed, distance float64, penUp bool) float64 {
+	_, acceleration, jerk := data.settings.MotionLimits(penUp)
+
+	// a trapezoid profile always reaches at least as fast
+	low, high := speed, math.Sqrt(speed*speed+2*acceleration*distance)
+
+	for iteration := 0; iteration < 50; iteration++ {
+		middle := (low + high) / 2
+		if sCurveDistance(speed, middle, acceleration, jerk) <= distance {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+
+	return low
+}
+
+// Calculate all fields needed
+func (data *SCurveInterpolater) Setup(origin Coordinate, segment Segment, entrySpeed, maxSpeed, exitSpeed float64) {
+	_, acceleration, jerk := data.settings.MotionLimits(segment.End.PenUp)
+
+	data.origin = origin
+	data.destination = segment.End
+	data.entrySpeed = entrySpeed
+	data.path = newSegmentPath(origin, segment)
+	data.distance = data.path.Length()
+
+	// special case of not going anywhere
+	if data.distance == 0 {
+		data.exitSpeed = entrySpeed
+		data.cruiseSpeed = entrySpeed
+		data.accel = newSCurveTransition(entrySpeed, entrySpeed, acceleration, jerk)
+		data.decel = data.accel
+		data.cruiseDist = 0
+		data.cruiseTime = 0
+		data.time = 0
+		data.slices = 0
+		return
+	}
+	// not enough room to reach exit speed, go as close to it as possible
+	if sCurveDistance(entrySpeed, exitSpeed, acceleration, jerk) > data.distance {
+		if exitSpeed > entrySpeed {
+			exitSpeed = data.ReachableSpeed(entrySpeed, data.distance, segment.End.PenUp)
+		} else {
+			low, high := exitSpeed, entrySpeed
+			for iteration := 0; iteration < 50; iteration++ {
+				middle := (low + high) / 2
+				if sCurveDistance(entrySpeed, middle, acceleration, jerk) <= data.distance {
+					high = middle
+				} else {
+					low = middle
+				}
+			}
+			exitSpeed = high
+		}
+	}
+	data.exitSpeed = exitSpeed
+
+	// find the highest cruise speed that leaves enough room to get back down to exit speed
+	fits := func(cruiseSpeed float64) bool {
+		return sCurveDistance(entrySpeed, cruiseSpeed, acceleration, jerk)+sCurveDistance(cruiseSpeed, exitSpeed, acceleration, jerk) <= data.distance
+	}
+	data.cruiseSpeed = math.Max(maxSpeed, math.Max(entrySpeed, exitSpeed))
+	if !fits(data.cruiseSpeed) {
+		low, high := math.Max(entrySpeed, exitSpeed), data.cruiseSpeed
+		for iteration := 0; iteration < 50; iteration++ {
+			middle := (low + high) / 2
+			if fits(middle) {
+				low = middle
+			} else {
+				high = middle
+			}
+		}
+		data.cruiseSpeed = low
+	}
+
+	data.accel = newSCurveTransition(entrySpeed, data.cruiseSpeed, acceleration, jerk)
+	data.decel = newSCurveTransition(data.cruiseSpeed, exitSpeed, acceleration, jerk)
+
+	data.cruiseDist = math.Max(0, data.distance-data.accel.distance()-data.decel.distance())
+	data.cruiseTime = data.cruiseDist / data.cruiseSpeed
+
+	data.time = data.accel.duration() + data.cruiseTime + data.decel.duration()
+	data.slices = data.time / (TimeSlice_US / 1000000)
+}
+
+// Calculate current position at the given slice
+func (data *SCurveInterpolater) Position(slice float64) Coordinate {
+
+	time := (slice / data.slices) * data.time
+	var distanceAlongMovement float64
+
+	if time < data.accel.duration() { // in acceleration
+		distanceAlongMovement = data.accel.distanceAt(time)
+	} else if time < data.accel.duration()+data.cruiseTime { // in cruise
+		distanceAlongMovement = data.accel.distance() + (time-data.accel.duration())*data.cruiseSpeed
+	} else { // in deceleration
+		distanceAlongMovement = data.accel.distance() + data.cruiseDist + data.decel.distanceAt(time-data.accel.duration()-data.cruiseTime)
+	}
+
+	return data.path.PointAt(distanceAlongMovement)
+}
+
+// Get total time it takes to move
+func (data *SCurveInterpolater) Slices() float64 {
+	return data.slices
+}
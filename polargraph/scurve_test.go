@@ -0,0 +1,82 @@
+package polargraph
+
+import (
+	"math"
+	"testing"
+)
+
+// Sample the position along the move at every slice
+func sampleSCurve(interp *SCurveInterpolater) (distances []float64) {
+	for slice := 0.0; slice < interp.Slices(); slice++ {
+		distances = append(distances, interp.Position(slice).Minus(interp.origin).Len())
+	}
+	return append(distances, interp.Position(interp.Slices()).Minus(interp.origin).Len())
+}
+
+// Moves should end at the destination without going faster than the max speed, acceleration or jerk
+func TestSCurveLimits(t *testing.T) {
+	withMotionSettings(t, 100, 1000)
+	Settings.Jerk_MM_S3 = 20000
+	Settings.DrawJerk_MM_S3 = 20000
+
+	dt := TimeSlice_US / 1000000.0
+	moves := []struct{ entry, max, exit, distance float64 }{
+		{0, 100, 0, 50},
+		{0, 100, 0, 2},
+		{40, 100, 10, 20},
+		{0, 100, 100, 1}, // exit speed can't be reached
+	}
+
+	for _, move := range moves {
+		interp := &SCurveInterpolater{settings: &Settings}
+		interp.Setup(Coordinate{}, LineTo(Coordinate{X: move.distance}), move.entry, move.max, move.exit)
+
+		if end := interp.Position(interp.Slices()); math.Abs(end.X-move.distance) > 0.000001 {
+			t.Error("Expected to end at", move.distance, "and got", end)
+		}
+		if interp.exitSpeed > move.exit {
+			t.Error("Exit speed", interp.exitSpeed, "is faster than planned", move.exit)
+		}
+
+		distances := sampleSCurve(interp)
+		for index := 1; index+1 < len(distances); index++ {
+			// the last slice is usually partial, so only full slices are checked
+			speed := (distances[index] - distances[index-1]) / dt
+			if speed > move.max*1.000001 {
+				t.Error("Speed", speed, "over max", move.max, "at slice", index)
+			}
+			if index < 2 {
+				continue
+			}
+			accel := (distances[index] - 2*distances[index-1] + distances[index-2]) / (dt * dt)
+			if math.Abs(accel) > 1000*1.01 {
+				t.Error("Acceleration", accel, "over limit at slice", index)
+			}
+			if index < 3 || index+2 >= len(distances) {
+				continue
+			}
+			jerk := (distances[index] - 3*distances[index-1] + 3*distances[index-2] - distances[index-3]) / (dt * dt * dt)
+			if math.Abs(jerk) > 20000*1.01 {
+				t.Error("Jerk", jerk, "over limit at slice", index)
+			}
+		}
+	}
+}
+
+// ReachableSpeed should be the inverse of the distance needed to change speed, and slower than a trapezoid
+func TestSCurveReachableSpeed(t *testing.T) {
+	withMotionSettings(t, 100, 1000)
+	Settings.Jerk_MM_S3 = 20000
+	Settings.DrawJerk_MM_S3 = 20000
+
+	interp := &SCurveInterpolater{settings: &Settings}
+	for _, distance := range []float64{0.1, 1, 5, 20} {
+		speed := interp.ReachableSpeed(10, distance, false)
+		if needed := sCurveDistance(10, speed, 1000, 20000); math.Abs(needed-distance) > 0.00001 {
+			t.Error("Reaching", speed, "needs", needed, "expected", distance)
+		}
+		if trapezoid := (&TrapezoidInterpolater{settings: &Settings}).ReachableSpeed(10, distance, false); speed > trapezoid {
+			t.Error("S-curve reached", speed, "faster than trapezoid", trapezoid)
+		}
+	}
+}
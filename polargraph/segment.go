@@ -0,0 +1,316 @@
+package polargraph
+
+// Path segments made of lines, circular arcs and cubic bezier curves, so curves can be planned and interpolated without flattening them
+
+import (
+	"math"
+	"runtime"
+	"sync"
+)
+
+// The shape of a segment
+type SegmentKind int
+
+const (
+	LineKind SegmentKind = iota
+	ArcKind
+	CubicKind
+)
+
+// A piece of path that starts wherever the previous segment ended and finishes at End
+type Segment struct {
+	Kind SegmentKind
+
+	// Where the segment finishes, its PenUp applies to the whole segment
+	End Coordinate
+
+	// Center of an ArcKind segment
+	Center Coordinate
+
+	// Angle turned by an ArcKind segment in radians, positive turns from the X axis towards the Y axis
+	Sweep float64
+
+	// Control points of a CubicKind segment
+	Control1, Control2 Coordinate
+
+	// Layer and Color the segment is drawn with, segments drawn differently are never joined
+	Layer, Color string
+
+	// Id of the element of the source drawing the segment came from, blank if not known
+	SourceID string
+}
+
+// A series of segments, the first segment's End is where the path starts
+type Path []Segment
+
+// Number of samples used to measure the length along a cubic curve
+const cubicLengthSamples = 64
+
+// A straight line to end
+func LineTo(end Coordinate) Segment {
+	return Segment{Kind: LineKind, End: end}
+}
+
+// An arc around center starting at origin and turning through sweep radians
+func ArcAround(origin, center Coordinate, sweep float64, penUp bool) Segment {
+	radius := origin.Minus(center).Len()
+	angle := math.Atan2(origin.Y-center.Y, origin.X-center.X) + sweep
+	end := Coordinate{X: center.X + radius*math.Cos(angle), Y: center.Y + radius*math.Sin(angle), PenUp: penUp}
+
+	return Segment{Kind: ArcKind, End: end, Center: center, Sweep: sweep}
+}
+
+// A cubic bezier curve to end with the given control points
+func CubicTo(control1, control2, end Coordinate) Segment {
+	return Segment{Kind: CubicKind, End: end, Control1: control1, Control2: control2}
+}
+
+// The segment with the layer, colour and source id of from
+func (segment Segment) withSourceOf(from Segment) Segment {
+	segment.Layer, segment.Color, segment.SourceID = from.Layer, from.Color, from.SourceID
+	return segment
+}
+
+// Point at parameter t, from 0 at origin to 1 at End
+func (segment Segment) Point(origin Coordinate, t float64) Coordinate {
+	var point Coordinate
+
+	switch segment.Kind {
+	case ArcKind:
+		radius := origin.Minus(segment.Center).Len()
+		angle := math.Atan2(origin.Y-segment.Center.Y, origin.X-segment.Center.X) + segment.Sweep*t
+		point = Coordinate{X: segment.Center.X + radius*math.Cos(angle), Y: segment.Center.Y + radius*math.Sin(angle)}
+	case CubicKind:
+		u := 1 - t
+		point = origin.Scaled(u * u * u).
+			Add(segment.Control1.Scaled(3 * u * u * t)).
+			Add(segment.Control2.Scaled(3 * u * t * t)).
+			Add(segment.End.Scaled(t * t * t))
+	default:
+		point = origin.Add(segment.End.Minus(origin).Scaled(t))
+	}
+
+	point.PenUp = segment.End.PenUp
+	return point
+}
+
+// Derivative of the position with respect to t, points in the direction of travel
+func (segment Segment) Tangent(origin Coordinate, t float64) Coordinate {
+	switch segment.Kind {
+	case ArcKind:
+		radial := segment.Point(origin, t).Minus(segment.Center)
+		return Coordinate{X: -radial.Y * segment.Sweep, Y: radial.X * segment.Sweep}
+	case CubicKind:
+		u := 1 - t
+		return segment.Control1.Minus(origin).Scaled(3 * u * u).
+			Add(segment.Control2.Minus(segment.Control1).Scaled(6 * u * t)).
+			Add(segment.End.Minus(segment.Control2).Scaled(3 * t * t))
+	default:
+		return segment.End.Minus(origin)
+	}
+}
+
+// Curvature at parameter t, the inverse of the radius of the circle the path is following, 0 for straight lines
+func (segment Segment) Curvature(origin Coordinate, t float64) float64 {
+	switch segment.Kind {
+	case ArcKind:
+		return 1 / origin.Minus(segment.Center).Len()
+	case CubicKind:
+		first := segment.Tangent(origin, t)
+		second := segment.Control2.Minus(segment.Control1.Scaled(2)).Add(origin).Scaled(6 * (1 - t)).
+			Add(segment.End.Minus(segment.Control2.Scaled(2)).Add(segment.Control1).Scaled(6 * t))
+		speed := first.Len()
+		if speed == 0 {
+			return 0
+		}
+		return math.Abs(first.X*second.Y-first.Y*second.X) / (speed * speed * speed)
+	default:
+		return 0
+	}
+}
+
+// The same segment travelled in the opposite direction, from End back to origin
+func (segment Segment) Reversed(origin Coordinate) Segment {
+	end := origin
+	end.PenUp = segment.End.PenUp
+
+	reversed := LineTo(end)
+	switch segment.Kind {
+	case ArcKind:
+		reversed = Segment{Kind: ArcKind, End: end, Center: segment.Center, Sweep: -segment.Sweep}
+	case CubicKind:
+		reversed = CubicTo(segment.Control2, segment.Control1, end)
+	}
+	return reversed.withSourceOf(segment)
+}
+
+// Points along the segment after origin, spaced so that the path between them is never more than tolerance from a straight line
+func (segment Segment) Flatten(origin Coordinate, tolerance float64) []Coordinate {
+	if segment.Kind == LineKind {
+		return []Coordinate{segment.End}
+	}
+
+	// the chord of a circle of radius r deviates by r*(1-cos(angle/2)) which is about r*angle^2/8
+	path := newSegmentPath(origin, segment)
+	maxCurvature := 0.0
+	for sample := 0; sample <= 8; sample++ {
+		maxCurvature = math.Max(maxCurvature, segment.Curvature(origin, float64(sample)/8))
+	}
+	steps := 1
+	if maxCurvature > 0 && tolerance > 0 {
+		steps = int(math.Ceil(path.Length() * math.Sqrt(maxCurvature/(8*tolerance))))
+		if steps < 1 {
+			steps = 1
+		}
+	}
+
+	points := make([]Coordinate, 0, steps)
+	for step := 1; step < steps; step++ {
+		points = append(points, path.PointAt(path.Length()*float64(step)/float64(steps)))
+	}
+	return append(points, segment.End)
+}
+
+// Convert a series of coordinates into a path of straight lines
+func PathFromCoordinates(coords []Coordinate) Path {
+	path := make(Path, len(coords))
+	for index, coord := range coords {
+		path[index] = LineTo(coord)
+	}
+	return path
+}
+
+// Paths with more segments than this are flattened in parallel
+const parallelFlattenSegments = 4096
+
+// Convert the path into coordinates, flattening curves to within tolerance
+// Every segment's origin is known up front, so long paths are split into a chunk per core and flattened at the same time
+func (path Path) Coordinates(tolerance float64) Coordinates {
+	if len(path) == 0 {
+		return Coordinates{}
+	}
+
+	chunks := 1
+	if len(path) > parallelFlattenSegments {
+		chunks = runtime.GOMAXPROCS(0)
+	}
+	chunkSize := (len(path) + chunks - 1) / chunks
+
+	flattened := make([]Coordinates, chunks)
+	var running sync.WaitGroup
+	for chunk := range flattened {
+		start, end := chunk*chunkSize, (chunk+1)*chunkSize
+		if end > len(path) {
+			end = len(path)
+		}
+		if start >= end {
+			continue
+		}
+
+		running.Add(1)
+		go func(chunk, start, end int) {
+			defer running.Done()
+			coords := make(Coordinates, 0, end-start)
+			for index := start; index < end; index++ {
+				if index == 0 {
+					coords = append(coords, path[index].End)
+					continue
+				}
+				coords = append(coords, path[index].Flatten(path[index-1].End, tolerance)...)
+			}
+			flattened[chunk] = coords
+		}(chunk, start, end)
+	}
+	running.Wait()
+
+	coords := make(Coordinates, 0, len(path))
+	for _, chunk := range flattened {
+		coords = append(coords, chunk...)
+	}
+	return coords
+}
+
+// A segment measured by the distance travelled along it
+type segmentPath struct {
+	origin  Coordinate
+	segment Segment
+	length  float64
+
+	// cumulative length at evenly spaced values of t, only used by cubic curves which have no closed form length
+	lengths []float64
+}
+
+// Measure the segment starting at origin
+func newSegmentPath(origin Coordinate, segment Segment) segmentPath {
+	path := segmentPath{origin: origin, segment: segment}
+
+	switch segment.Kind {
+	case ArcKind:
+		path.length = origin.Minus(segment.Center).Len() * math.Abs(segment.Sweep)
+	case CubicKind:
+		path.lengths = make([]float64, cubicLengthSamples+1)
+		previous := origin
+		for sample := 1; sample <= cubicLengthSamples; sample++ {
+			point := segment.Point(origin, float64(sample)/cubicLengthSamples)
+			path.lengths[sample] = path.lengths[sample-1] + point.Minus(previous).Len()
+			previous = point
+		}
+		path.length = path.lengths[cubicLengthSamples]
+	default:
+		path.length = segment.End.Minus(origin).Len()
+	}
+
+	return path
+}
+
+// Total distance along the segment
+func (path segmentPath) Length() float64 {
+	return path.length
+}
+
+// Parameter t of the segment reached after travelling distance along it
+func (path segmentPath) parameterAt(distance float64) float64 {
+	if path.length == 0 {
+		return 1
+	}
+	if path.segment.Kind != CubicKind {
+		return distance / path.length
+	}
+
+	// find the sample interval containing distance and interpolate within it
+	low, high := 0, cubicLengthSamples
+	for high-low > 1 {
+		middle := (low + high) / 2
+		if path.lengths[middle] <= distance {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+	interval := path.lengths[high] - path.lengths[low]
+	fraction := 0.0
+	if interval > 0 {
+		fraction = (distance - path.lengths[low]) / interval
+	}
+	return (float64(low) + math.Max(0, math.Min(1, fraction))) / cubicLengthSamples
+}
+
+// Position after travelling distance along the segment
+func (path segmentPath) PointAt(distance float64) Coordinate {
+	return path.segment.Point(path.origin, path.parameterAt(distance))
+}
+
+// Unit direction of travel after travelling distance along the segment
+func (path segmentPath) DirectionAt(distance float64) Coordinate {
+	tangent := path.segment.Tangent(path.origin, path.parameterAt(distance))
+	if tangent.Len() == 0 {
+		// cubic curves with a control point on an end point have no tangent there, use the chord instead
+		tangent = path.segment.End.Minus(path.origin)
+	}
+	return tangent.Normalized()
+}
+
+// Curvature after travelling distance along the segment
+func (path segmentPath) CurvatureAt(distance float64) float64 {
+	return path.segment.Curvature(path.origin, path.parameterAt(distance))
+}
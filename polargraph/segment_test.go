@@ -0,0 +1,146 @@
+package polargraph
+
+import (
+	"math"
+	"testing"
+)
+
+// Arcs should be measured and followed exactly around their center
+func TestSegmentArc(t *testing.T) {
+	origin := Coordinate{X: 10, Y: 0}
+	arc := ArcAround(origin, Coordinate{}, math.Pi/2, false)
+
+	if !arc.End.Equals(Coordinate{X: 0, Y: 10}) {
+		t.Error("Expected quarter circle to end at [ 0, 10 ] and got", arc.End)
+	}
+
+	path := newSegmentPath(origin, arc)
+	if math.Abs(path.Length()-5*math.Pi) > 0.000001 {
+		t.Error("Expected length", 5*math.Pi, "and got", path.Length())
+	}
+
+	halfway := path.PointAt(path.Length() / 2)
+	if expected := (Coordinate{X: 10 * math.Sqrt(0.5), Y: 10 * math.Sqrt(0.5)}); !halfway.Equals(expected) {
+		t.Error("Expected", expected, "halfway and got", halfway)
+	}
+	if direction := path.DirectionAt(0); !direction.Equals(Coordinate{X: 0, Y: 1}) {
+		t.Error("Expected to start heading along Y and got", direction)
+	}
+	if curvature := path.CurvatureAt(1); math.Abs(curvature-0.1) > 0.000001 {
+		t.Error("Expected curvature 0.1 and got", curvature)
+	}
+}
+
+// Cubic lengths should match a finely flattened curve and be followed at an even rate
+func TestSegmentCubic(t *testing.T) {
+	origin := Coordinate{X: 0, Y: 0}
+	cubic := CubicTo(Coordinate{X: 0, Y: 10}, Coordinate{X: 20, Y: 10}, Coordinate{X: 20, Y: 0})
+	path := newSegmentPath(origin, cubic)
+
+	flattened := 0.0
+	previous := origin
+	for _, point := range cubic.Flatten(origin, 0.0001) {
+		flattened += point.Minus(previous).Len()
+		previous = point
+	}
+	if math.Abs(path.Length()-flattened) > 0.01 {
+		t.Error("Expected length", flattened, "and got", path.Length())
+	}
+
+	// equal distances along the path should give nearly equal straight line steps
+	const steps = 100
+	previous = origin
+	for step := 1; step <= steps; step++ {
+		point := path.PointAt(path.Length() * float64(step) / steps)
+		if distance := point.Minus(previous).Len(); math.Abs(distance-path.Length()/steps) > 0.01 {
+			t.Error("Step", step, "moved", distance, "expected", path.Length()/steps)
+		}
+		previous = point
+	}
+	if !previous.Equals(cubic.End) {
+		t.Error("Expected to finish at", cubic.End, "and got", previous)
+	}
+
+	// symmetric curve is straight across at the middle and curves the same amount at both ends
+	if direction := path.DirectionAt(path.Length() / 2); !direction.Equals(Coordinate{X: 1, Y: 0}) {
+		t.Error("Expected to head along X at the middle and got", direction)
+	}
+	if start, end := cubic.Curvature(origin, 0), cubic.Curvature(origin, 1); math.Abs(start-end) > 0.000001 {
+		t.Error("Expected the same curvature at both ends and got", start, end)
+	}
+}
+
+// Reversed segments should trace the same points backwards
+func TestSegmentReversed(t *testing.T) {
+	origin := Coordinate{X: 1, Y: 2}
+	segments := []Segment{
+		LineTo(Coordinate{X: 5, Y: 7}),
+		ArcAround(origin, Coordinate{X: 4, Y: 2}, -2, false),
+		CubicTo(Coordinate{X: 3, Y: 9}, Coordinate{X: 8, Y: -4}, Coordinate{X: 10, Y: 3}),
+	}
+
+	for _, segment := range segments {
+		reversed := segment.Reversed(origin)
+		if !reversed.End.Same(origin) {
+			t.Error("Expected reversed", segment.Kind, "to end at", origin, "and got", reversed.End)
+		}
+		for sample := 0.0; sample <= 1; sample += 0.125 {
+			forward, backward := segment.Point(origin, sample), reversed.Point(segment.End, 1-sample)
+			if !forward.Same(backward) {
+				t.Error("Reversed", segment.Kind, "at", sample, "was", backward, "expected", forward)
+			}
+		}
+	}
+}
+
+// Flattened curves should stay within the tolerance of the curve
+func TestSegmentFlatten(t *testing.T) {
+	origin := Coordinate{X: 50, Y: 0}
+	circle := ArcAround(origin, Coordinate{}, 2*math.Pi, false)
+
+	points := circle.Flatten(origin, 0.1)
+	if !points[len(points)-1].Equals(circle.End) {
+		t.Error("Expected to finish at", circle.End, "and got", points[len(points)-1])
+	}
+
+	previous := origin
+	for _, point := range points {
+		// the middle of each chord is the furthest point from the circle
+		middle := previous.Add(point).Scaled(0.5)
+		if deviation := 50 - middle.Len(); deviation > 0.1 {
+			t.Error("Chord from", previous, "to", point, "deviates", deviation)
+		}
+		previous = point
+	}
+}
+
+// Long paths are flattened in parallel chunks, which should give the same points in the same order
+func TestPathCoordinatesParallel(t *testing.T) {
+	path := Path{LineTo(Coordinate{X: 0, Y: 0, PenUp: true})}
+	for index := 0; len(path) <= 3*parallelFlattenSegments; index++ {
+		origin := path[len(path)-1].End
+		switch index % 3 {
+		case 0:
+			path = append(path, LineTo(Coordinate{X: origin.X + 1, Y: float64(index % 7)}))
+		case 1:
+			path = append(path, ArcAround(origin, origin.Add(Coordinate{X: 2}), 1, false))
+		default:
+			path = append(path, CubicTo(origin.Add(Coordinate{Y: 3}), origin.Add(Coordinate{X: 2, Y: -3}), origin.Add(Coordinate{X: 3})))
+		}
+	}
+
+	expected := Coordinates{path[0].End}
+	for index := 1; index < len(path); index++ {
+		expected = append(expected, path[index].Flatten(path[index-1].End, 0.01)...)
+	}
+
+	coords := path.Coordinates(0.01)
+	if len(coords) != len(expected) {
+		t.Fatal("Expected", len(expected), "coordinates and got", len(coords))
+	}
+	for index := range expected {
+		if coords[index] != expected[index] {
+			t.Fatal("Coordinate", index, "expected", expected[index], "and got", coords[index])
+		}
+	}
+}
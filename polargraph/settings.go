@@ -2,10 +2,12 @@ package polargraph
 
 import (
 	"encoding/xml"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 )
 
 // These constants are also set in StepperDriver.ino, must be changed in both places
@@ -16,11 +18,14 @@ const (
 	// when running on a raspberry pi 2048 us (2 milliseconds) seems like a good number
 	TimeSlice_US float64 = 2048
 
+	// Time the arduino waits for the pen servo to move up or down before carrying on
+	PenTransitionCooldown_US float64 = 650000
+
 	// The factor the steps are multiplied by, needs to be the same as set in the arduino code
 	StepsFixedPointFactor float64 = 32.0
 
-	// Determined because 1 byte is sent per value, so have range -128 to 127, and -128, -127, 127 are reserved values with special meanings
-	StepsMaxValue float64 = 126.0
+	// Determined because 1 byte is sent per value, so have range -128 to 127, and -128, -127, -126, 126, 127 are reserved values with special meanings
+	StepsMaxValue float64 = 125.0
 
 	// Special Steps value that when received causes the arduino to flush its buffers and reset its internal state
 	ResetCommand byte = 0x80 // -128
@@ -30,6 +35,15 @@ const (
 
 	// Special Steps value that lowers the pen
 	PenDownCommand int8 = 127
+
+	// Special Steps value that retracts both spools until their limit switches trigger
+	HomeCommand byte = 0x82 // -126
+
+	// Sent back by the arduino in place of a data request once homing has finished
+	HomedResponse byte = 0xFE
+
+	// Sent back by the arduino when a limit switch trips during a move, or when homing gives up, it stops until it receives a ResetCommand
+	LimitSwitchResponse byte = 0xFF
 )
 
 // User configurable settings
@@ -43,6 +57,18 @@ type SettingsData struct {
 	// Number of seconds to accelerate from 0 to MaxSpeed_MM_S
 	Acceleration_Seconds float64
 
+	// Max speed while the pen is down, 0 uses MaxSpeed_MM_S, pen up travel always uses MaxSpeed_MM_S
+	DrawSpeed_MM_S float64
+
+	// Number of seconds to accelerate from 0 to the draw speed while the pen is down, 0 uses Acceleration_Seconds
+	DrawAcceleration_Seconds float64
+
+	// Number of seconds to go from no acceleration to full acceleration, only used by the scurve interpolater
+	JerkTime_Seconds float64
+
+	// Motion profile used for each move, trapezoid or scurve
+	Interpolater string
+
 	// Distance between the two motor spools
 	SpoolHorizontalDistance_MM float64
 
@@ -64,12 +90,72 @@ type SettingsData struct {
 	// Initial distance from head to right motor
 	StartingRightDist_MM float64
 
+	// Distance from head to left motor once homed, when the left limit switch triggers
+	HomeLeftDist_MM float64
+
+	// Distance from head to right motor once homed, when the right limit switch triggers
+	HomeRightDist_MM float64
+
+	// Thickness of the cord, used to model the spool diameter growing as cord winds on, 0 disables the model
+	CordThickness_MM float64
+
+	// Diameter of the bare spool core with no cord wound on it
+	SpoolCoreDiameter_MM float64
+
+	// Number of wraps that fit side by side on the spool before the cord starts a new layer
+	SpoolWrapsPerLayer float64
+
+	// Total length of cord attached to each spool, measured from the spool core to the pen
+	CordLength_MM float64
+
+	// Positions saved from jog mode, recalled with their slot number
+	JogPresets []SavedPosition
+
+	// How far the path through a corner may deviate from the sharp corner, larger values take corners faster
+	JunctionDeviation_MM float64
+
+	// How the movement in each slice is rounded to whole steps, ceil, round or sigmadelta
+	Quantiser string
+
+	// Points closer than this to the simplified line are dropped by simplify, 0 uses half of StepSize_MM
+	SimplifyTolerance_MM float64
+
+	// Lines within this of a line already drawn are removed by dedup, 0 uses StepSize_MM
+	DedupTolerance_MM float64
+
+	// Glyphs whose ends are closer than this are joined without lifting the pen when optimizing
+	MergeTolerance_MM float64
+
+	// Time spent improving the glyph order after the greedy ordering when optimizing, 0 skips it
+	OptimizeTime_Seconds float64
+
+	// How optimize finds the glyphs to draw, greedy keeps the glyphs from the drawing, euler redraws connected lines as the fewest trails
+	OptimizeStrategy string
+
+	// Lines up to this long may be drawn twice by the euler strategy if that saves a pen lift, 0 never draws a line twice
+	Retrace_MM float64
+
+	// How optimize orders the glyphs, nearest always draws the closest glyph next, hilbert finishes each region of the canvas first
+	OrderStrategy string
+
+	// Size of the square regions the hilbert order splits the canvas into
+	RegionSize_MM float64
+
+	// Number of upcoming moves the planner looks at when deciding how fast it can go
+	LookAheadSegments int
+
 	// path to mouse event file, use evtest to find
 	MousePath string
 
 	// path to serial port
 	SerialPortPath string
 
+	// Directory processed svg paths are cached in, so that the next plot or dry run of the same file starts right away
+	CacheDir string
+
+	// Largest size the cache directory may grow to, the least recently used entries are removed beyond it, 0 doesn't limit it
+	CacheMaxSize_MB float64
+
 	// MM traveled by a single step
 	StepSize_MM float64 `xml:"-"`
 
@@ -78,6 +164,36 @@ type SettingsData struct {
 
 	// Acceleration in mm / s^2, derived from Acceleration_Seconds and MaxSpeed_MM_S
 	Acceleration_MM_S2 float64 `xml:"-"`
+
+	// Jerk in mm / s^3, derived from JerkTime_Seconds and Acceleration_MM_S2
+	Jerk_MM_S3 float64 `xml:"-"`
+
+	// Max speed while the pen is down, derived from DrawSpeed_MM_S and MaxSpeed_MM_S
+	DrawMaxSpeed_MM_S float64 `xml:"-"`
+
+	// Acceleration while the pen is down, derived from DrawAcceleration_Seconds and DrawMaxSpeed_MM_S
+	DrawAcceleration_MM_S2 float64 `xml:"-"`
+
+	// Jerk while the pen is down, derived from JerkTime_Seconds and DrawAcceleration_MM_S2
+	DrawJerk_MM_S3 float64 `xml:"-"`
+
+	// The settings as they were before the first Override and after the last one, Write saves what they changed from before
+	beforeOverride, afterOverride *SettingsData
+}
+
+// Settings replaced by command line flags for a single run, zero values leave the setting as it is
+type SettingsOverrides struct {
+	DrawSpeed_MM_S       float64
+	OptimizeTime_Seconds float64
+	OptimizeStrategy     string
+	OrderStrategy        string
+	NoCache              bool
+}
+
+// A named pen position, stored as cord lengths so it doesn't depend on where the origin is
+type SavedPosition struct {
+	Slot                int
+	LeftDist, RightDist float64
 }
 
 // Global settings variable
@@ -87,23 +203,23 @@ var Settings SettingsData
 var settingsFile string = "gocupi_config.xml"
 
 // Read settings from file, setting the global variable
-func (settings *SettingsData) Read() {
+func (settings *SettingsData) Read() error {
 
 	fileData, err := ioutil.ReadFile(settingsFile)
 	if err != nil {
 		// attempt to copy the default settings file from the gopath directory to the current working directory
 		repoSettingsFile := filepath.Join(os.Getenv("GOPATH"), "src/github.com/vytis/gocupi", settingsFile)
 		if copyErr := copyFile(repoSettingsFile, settingsFile); copyErr != nil {
-			panic(copyErr)
+			return copyErr
 		}
 
 		fileData, err = ioutil.ReadFile(settingsFile)
 		if err != nil {
-			panic(err)
+			return err
 		}
 	}
 	if err := xml.Unmarshal(fileData, settings); err != nil {
-		panic(err)
+		return fmt.Errorf("Could not read %s: %s", settingsFile, err)
 	}
 
 	// setup default values
@@ -113,8 +229,35 @@ func (settings *SettingsData) Read() {
 	if settings.Acceleration_Seconds == 0 {
 		settings.Acceleration_Seconds = 1
 	}
+	if settings.JerkTime_Seconds == 0 {
+		settings.JerkTime_Seconds = 0.1
+	}
+	if settings.JunctionDeviation_MM == 0 {
+		settings.JunctionDeviation_MM = 0.05
+	}
+	if settings.LookAheadSegments == 0 {
+		settings.LookAheadSegments = 32
+	}
+	if settings.RegionSize_MM == 0 {
+		settings.RegionSize_MM = 100
+	}
+	if settings.CacheDir == "" {
+		settings.CacheDir = ".gocupi_cache"
+	}
 
 	settings.CalculateDerivedFields()
+	return settings.Validate()
+}
+
+// Check that the settings naming how to plan, step and optimize name something that exists
+func (settings *SettingsData) Validate() error {
+	if _, err := NewInterpolater(settings); err != nil {
+		return err
+	}
+	if _, err := NewStepQuantiser(settings.Quantiser); err != nil {
+		return err
+	}
+	return checkOptimizeStrategies(settings)
 }
 
 // setup derived fields
@@ -126,6 +269,71 @@ func (settings *SettingsData) CalculateDerivedFields() {
 	stepsPerValue := StepsMaxValue / StepsFixedPointFactor
 	settings.MaxSpeed_MM_S = ((stepsPerValue / (TimeSlice_US / 1000000.0)) / stepsPerRevolution) * settings.SpoolCircumference_MM
 	settings.Acceleration_MM_S2 = settings.MaxSpeed_MM_S / settings.Acceleration_Seconds
+	settings.Jerk_MM_S3 = settings.Acceleration_MM_S2 / settings.JerkTime_Seconds
+
+	settings.DrawMaxSpeed_MM_S = settings.MaxSpeed_MM_S
+	if settings.DrawSpeed_MM_S > 0 && settings.DrawSpeed_MM_S < settings.MaxSpeed_MM_S {
+		settings.DrawMaxSpeed_MM_S = settings.DrawSpeed_MM_S
+	}
+	drawAccelerationSeconds := settings.Acceleration_Seconds
+	if settings.DrawAcceleration_Seconds > 0 {
+		drawAccelerationSeconds = settings.DrawAcceleration_Seconds
+	}
+	settings.DrawAcceleration_MM_S2 = settings.DrawMaxSpeed_MM_S / drawAccelerationSeconds
+	settings.DrawJerk_MM_S3 = settings.DrawAcceleration_MM_S2 / settings.JerkTime_Seconds
+}
+
+// Replace settings for this run only, Write still saves the values they had before
+func (settings *SettingsData) Override(overrides SettingsOverrides) error {
+	if settings.beforeOverride == nil {
+		before := *settings
+		settings.beforeOverride = &before
+	}
+
+	if overrides.DrawSpeed_MM_S > 0 {
+		settings.DrawSpeed_MM_S = overrides.DrawSpeed_MM_S
+	}
+	if overrides.OptimizeTime_Seconds > 0 {
+		settings.OptimizeTime_Seconds = overrides.OptimizeTime_Seconds
+	}
+	if overrides.OptimizeStrategy != "" {
+		settings.OptimizeStrategy = overrides.OptimizeStrategy
+	}
+	if overrides.OrderStrategy != "" {
+		settings.OrderStrategy = overrides.OrderStrategy
+	}
+	if overrides.NoCache {
+		settings.CacheDir = ""
+	}
+
+	settings.CalculateDerivedFields()
+	after := *settings
+	settings.afterOverride = &after
+	return settings.Validate()
+}
+
+// Put back the value from before of every setting Override changed, unless it has been changed again since
+// Every field is compared, so a new override can't be saved by accident
+func restoreOverridden(saved, before, after *SettingsData) {
+	savedFields, beforeFields, afterFields := reflect.ValueOf(saved).Elem(), reflect.ValueOf(before).Elem(), reflect.ValueOf(after).Elem()
+	for index := 0; index < savedFields.NumField(); index++ {
+		field := savedFields.Field(index)
+		if !field.CanSet() {
+			continue
+		}
+		overridden := !reflect.DeepEqual(beforeFields.Field(index).Interface(), afterFields.Field(index).Interface())
+		if overridden && reflect.DeepEqual(field.Interface(), afterFields.Field(index).Interface()) {
+			field.Set(beforeFields.Field(index))
+		}
+	}
+}
+
+// Max speed, acceleration and jerk for a move made with the pen up or down
+func (settings *SettingsData) MotionLimits(penUp bool) (speed, acceleration, jerk float64) {
+	if penUp {
+		return settings.MaxSpeed_MM_S, settings.Acceleration_MM_S2, settings.Jerk_MM_S3
+	}
+	return settings.DrawMaxSpeed_MM_S, settings.DrawAcceleration_MM_S2, settings.DrawJerk_MM_S3
 }
 
 // from https://gist.github.com/elazarl/5507969
@@ -149,12 +357,15 @@ func copyFile(src, dst string) error {
 }
 
 // Write settings to file
-func (settings *SettingsData) Write() {
-	fileData, err := xml.MarshalIndent(settings, "", "\t")
-	if err != nil {
-		panic(err)
+func (settings *SettingsData) Write() error {
+	saved := *settings
+	if settings.beforeOverride != nil {
+		restoreOverridden(&saved, settings.beforeOverride, settings.afterOverride)
 	}
-	if err := ioutil.WriteFile(settingsFile, fileData, 0777); err != nil {
-		panic(err)
+
+	fileData, err := xml.MarshalIndent(saved, "", "\t")
+	if err != nil {
+		return err
 	}
+	return ioutil.WriteFile(settingsFile, fileData, 0777)
 }
@@ -0,0 +1,125 @@
+package polargraph
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Settings overridden for a single run shouldn't be saved, while other changes should
+func TestSettingsOverrideNotWritten(t *testing.T) {
+	directory, err := ioutil.TempDir("", "settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	previousFile := settingsFile
+	settingsFile = filepath.Join(directory, "gocupi_config.xml")
+	defer func() { settingsFile = previousFile }()
+
+	settings := SettingsData{SpoolSingleStep_Degrees: 1.8, DrawSpeed_MM_S: 50, OptimizeStrategy: "greedy", CacheDir: "cache"}
+	if err := settings.Override(SettingsOverrides{DrawSpeed_MM_S: 10, OptimizeStrategy: "euler", NoCache: true}); err != nil {
+		t.Fatal(err)
+	}
+	if settings.DrawSpeed_MM_S != 10 || settings.OptimizeStrategy != "euler" || settings.CacheDir != "" {
+		t.Fatal("Expected the overrides to be applied and got", settings.DrawSpeed_MM_S, settings.OptimizeStrategy, settings.CacheDir)
+	}
+
+	settings.StartingLeftDist_MM = 123
+	if err := settings.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	var saved SettingsData
+	if err := saved.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if saved.DrawSpeed_MM_S != 50 || saved.OptimizeStrategy != "greedy" || saved.CacheDir != "cache" {
+		t.Error("Expected the values from before the overrides to be saved and got", saved.DrawSpeed_MM_S, saved.OptimizeStrategy, saved.CacheDir)
+	}
+	if saved.StartingLeftDist_MM != 123 {
+		t.Error("Expected the new starting position to be saved and got", saved.StartingLeftDist_MM)
+	}
+}
+
+// Every override should be undone when the settings are written, whichever of them are set
+func TestSettingsOverridesRoundTrip(t *testing.T) {
+	previousFile := settingsFile
+	settingsFile = filepath.Join(t.TempDir(), "gocupi_config.xml")
+	defer func() { settingsFile = previousFile }()
+
+	// a value for each override that differs from the settings below, a new override needs one added here
+	values := map[string]interface{}{
+		"DrawSpeed_MM_S":       10.0,
+		"OptimizeTime_Seconds": 5.0,
+		"OptimizeStrategy":     "euler",
+		"OrderStrategy":        "hilbert",
+		"NoCache":              true,
+	}
+
+	original := SettingsData{SpoolSingleStep_Degrees: 1.8, DrawSpeed_MM_S: 50, OptimizeTime_Seconds: 1, OptimizeStrategy: "greedy", OrderStrategy: "nearest", CacheDir: "cache"}
+	if err := original.Write(); err != nil {
+		t.Fatal(err)
+	}
+	var expected SettingsData
+	if err := expected.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	overrideType := reflect.TypeOf(SettingsOverrides{})
+	for index := 0; index < overrideType.NumField(); index++ {
+		name := overrideType.Field(index).Name
+		value, ok := values[name]
+		if !ok {
+			t.Error("No test value for the override", name)
+			continue
+		}
+
+		var overrides SettingsOverrides
+		reflect.ValueOf(&overrides).Elem().Field(index).Set(reflect.ValueOf(value))
+		settings := original
+		if err := settings.Override(overrides); err != nil {
+			t.Fatal(name, err)
+		}
+		if reflect.DeepEqual(settings, original) {
+			t.Error("Expected overriding", name, "to change the settings")
+		}
+		if err := settings.Write(); err != nil {
+			t.Fatal(err)
+		}
+
+		var saved SettingsData
+		if err := saved.Read(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(saved, expected) {
+			t.Error("Expected overriding", name, "to save the settings unchanged and got", saved, "instead of", expected)
+		}
+	}
+}
+
+// Names that don't match a strategy should be an error rather than failing part way through a drawing
+func TestSettingsValidate(t *testing.T) {
+	if err := (&SettingsData{}).Validate(); err != nil {
+		t.Error("Expected the defaults to be valid and got", err)
+	}
+
+	for _, settings := range []SettingsData{
+		{Interpolater: "cubic"},
+		{Quantiser: "floor"},
+		{OptimizeStrategy: "random"},
+		{OrderStrategy: "zigzag"},
+	} {
+		if err := settings.Validate(); err == nil {
+			t.Error("Expected an error for", settings.Interpolater, settings.Quantiser, settings.OptimizeStrategy, settings.OrderStrategy)
+		}
+	}
+
+	var settings SettingsData
+	if err := settings.Override(SettingsOverrides{OrderStrategy: "zigzag"}); err == nil {
+		t.Error("Expected an error overriding with an unknown order strategy")
+	}
+}
@@ -0,0 +1,143 @@
+package polargraph
+
+// Ramer-Douglas-Peucker simplification, drops points from over sampled polylines that don't change the drawing by more than a tolerance
+
+import (
+	"context"
+	"fmt"
+)
+
+// Distance from point to the line segment from start to end
+func distanceToSegment(point, start, end Coordinate) float64 {
+	line := end.Minus(start)
+	lengthSquared := line.DotProduct(line)
+	if lengthSquared == 0 {
+		return point.DistanceTo(start)
+	}
+
+	along := point.Minus(start).DotProduct(line) / lengthSquared
+	if along < 0 {
+		along = 0
+	} else if along > 1 {
+		along = 1
+	}
+	return point.DistanceTo(start.Add(line.Scaled(along)))
+}
+
+// Keep only the points needed for the polyline to stay within tolerance of the original, the first and last points are always kept
+func SimplifyPolyline(coords []Coordinate, tolerance float64) []Coordinate {
+	if len(coords) < 3 {
+		return coords
+	}
+
+	keep := keptPoints(coords, tolerance)
+	simplified := make([]Coordinate, 0, len(coords))
+	for index, coord := range coords {
+		if keep[index] {
+			simplified = append(simplified, coord)
+		}
+	}
+	return simplified
+}
+
+// Which points of the polyline SimplifyPolyline keeps
+func keptPoints(coords []Coordinate, tolerance float64) []bool {
+	keep := make([]bool, len(coords))
+	keep[0], keep[len(coords)-1] = true, true
+
+	// ranges still to be checked, kept on a stack instead of recursing so long traces can't overflow
+	type span struct{ first, last int }
+	spans := []span{{0, len(coords) - 1}}
+	for len(spans) > 0 {
+		current := spans[len(spans)-1]
+		spans = spans[:len(spans)-1]
+
+		furthest, furthestDistance := -1, tolerance
+		for index := current.first + 1; index < current.last; index++ {
+			if distance := distanceToSegment(coords[index], coords[current.first], coords[current.last]); distance > furthestDistance {
+				furthest, furthestDistance = index, distance
+			}
+		}
+		if furthest < 0 {
+			continue
+		}
+
+		keep[furthest] = true
+		spans = append(spans, span{current.first, furthest}, span{furthest, current.last})
+	}
+	return keep
+}
+
+// The glyph with the points of its straight lines that don't change it by more than tolerance removed
+func (g Glyph) Simplified(tolerance float64) Glyph {
+	return g.withSegments(SimplifyPath(g.Segments, tolerance))
+}
+
+// Tolerance used to simplify paths, SimplifyTolerance_MM or half a step when it isn't set, so the removed points are smaller than the motors can draw
+func (settings *SettingsData) SimplifyTolerance() float64 {
+	if settings.SimplifyTolerance_MM > 0 {
+		return settings.SimplifyTolerance_MM
+	}
+	return settings.StepSize_MM / 2
+}
+
+// Simplify every run of pen down straight lines in the path, curves and pen up moves are kept as they are
+func SimplifyPath(path Path, tolerance float64) Path {
+	simplified := make(Path, 0, len(path))
+
+	// points of the current run of pen down lines, starting where the segment before it finished
+	// the lines that are kept are the ones ending at the kept points, so they keep their metadata
+	var run []Coordinate
+	runStart := 0
+	flush := func() {
+		if len(run) > 1 {
+			keep := keptPoints(run, tolerance)
+			for index, line := range path[runStart : runStart+len(run)-1] {
+				if keep[index+1] {
+					simplified = append(simplified, line)
+				}
+			}
+		}
+		run = run[:0]
+	}
+
+	for index, segment := range path {
+		if index == 0 || segment.Kind != LineKind || segment.End.PenUp {
+			flush()
+			simplified = append(simplified, segment)
+			continue
+		}
+		if len(run) == 0 {
+			runStart = index
+			run = append(run, path[index-1].End)
+		}
+		run = append(run, segment.End)
+	}
+	flush()
+
+	return simplified
+}
+
+// Number of points drawn to with the pen down, the end of every pen down segment
+func PenDownPoints(path Path) (points int) {
+	for _, segment := range path {
+		if !segment.End.PenUp {
+			points++
+		}
+	}
+	return
+}
+
+// A pipeline transform that simplifies the runs of pen down straight lines in the whole path
+func SimplifyTransform(tolerance float64) Transform {
+	return func(ctx context.Context, in <-chan Segment, out chan<- Segment) error {
+		path, err := CollectPath(ctx, in)
+		if err != nil {
+			return err
+		}
+
+		simplified := SimplifyPath(path, tolerance)
+		fmt.Fprintln(Log, "Simplified", PenDownPoints(path), "pen down points to", PenDownPoints(simplified), "within", tolerance, "mm")
+		return SendPath(ctx, out, simplified)
+	}
+}
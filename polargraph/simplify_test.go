@@ -0,0 +1,88 @@
+package polargraph
+
+import (
+	"math"
+	"testing"
+)
+
+// Points along a straight line should all be dropped
+func TestSimplifyStraightLine(t *testing.T) {
+	coords := []Coordinate{{X: 0, Y: 0, PenUp: true}}
+	for x := 0.01; x <= 10; x += 0.01 {
+		coords = append(coords, Coordinate{X: x, Y: 0.001 * math.Sin(x)})
+	}
+	glyph := GlyphFromCoordinates(coords)
+
+	simplified := glyph.Simplified(0.01)
+	if len(simplified.Segments) != 2 {
+		t.Fatal("Expected only the ends to be kept and got", len(simplified.Segments), "points")
+	}
+	if simplified.start() != glyph.start() || simplified.end() != glyph.end() {
+		t.Error("Expected the ends", glyph.start(), glyph.end(), "and got", simplified.start(), simplified.end())
+	}
+}
+
+// Every dropped point should be within the tolerance of the simplified polyline
+func TestSimplifyWithinTolerance(t *testing.T) {
+	var coords []Coordinate
+	for angle := 0.0; angle < 2*math.Pi; angle += 0.001 {
+		coords = append(coords, Coordinate{X: 50 * math.Cos(angle), Y: 50 * math.Sin(angle)})
+	}
+
+	const tolerance = 0.05
+	simplified := SimplifyPolyline(coords, tolerance)
+	if len(simplified) >= len(coords)/10 {
+		t.Error("Expected far fewer points than", len(coords), "and got", len(simplified))
+	}
+
+	for _, coord := range coords {
+		closest := math.MaxFloat64
+		for index := 1; index < len(simplified); index++ {
+			closest = math.Min(closest, distanceToSegment(coord, simplified[index-1], simplified[index]))
+		}
+		if closest > tolerance+0.000001 {
+			t.Error("Point", coord, "is", closest, "from the simplified polyline")
+		}
+	}
+}
+
+// Only runs of pen down lines should be simplified, curves and pen up moves are kept
+func TestSimplifyPath(t *testing.T) {
+	path := Path{
+		LineTo(Coordinate{X: 0, Y: 0, PenUp: true}),
+		LineTo(Coordinate{X: 1, Y: 0}),
+		LineTo(Coordinate{X: 2, Y: 0}),
+		LineTo(Coordinate{X: 3, Y: 0}),
+		ArcAround(Coordinate{X: 3, Y: 0}, Coordinate{X: 3, Y: 1}, math.Pi, false),
+		LineTo(Coordinate{X: 2, Y: 2}),
+		LineTo(Coordinate{X: 1, Y: 2}),
+		LineTo(Coordinate{X: 10, Y: 10, PenUp: true}),
+		LineTo(Coordinate{X: 11, Y: 10}),
+		LineTo(Coordinate{X: 12, Y: 10}),
+	}
+
+	simplified := SimplifyPath(path, 0.01)
+	expected := Path{path[0], path[3], path[4], path[6], path[7], path[9]}
+	if len(simplified) != len(expected) {
+		t.Fatal("Expected", expected, "and got", simplified)
+	}
+	for index := range expected {
+		if simplified[index] != expected[index] {
+			t.Error("Segment", index, "expected", expected[index], "and got", simplified[index])
+		}
+	}
+}
+
+// Only the points drawn to with the pen down should be counted, not the pen up moves between lines
+func TestPenDownPoints(t *testing.T) {
+	path := Path{
+		LineTo(Coordinate{X: 0, Y: 0, PenUp: true}),
+		LineTo(Coordinate{X: 1, Y: 0}),
+		LineTo(Coordinate{X: 2, Y: 0}),
+		LineTo(Coordinate{X: 5, Y: 5, PenUp: true}),
+		CubicTo(Coordinate{X: 6, Y: 6}, Coordinate{X: 7, Y: 4}, Coordinate{X: 8, Y: 5}),
+	}
+	if points := PenDownPoints(path); points != 3 {
+		t.Error("Expected 3 pen down points and got", points)
+	}
+}
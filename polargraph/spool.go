@@ -0,0 +1,100 @@
+package polargraph
+
+// Models the spool diameter changing as cord winds on and off
+
+import (
+	"math"
+)
+
+// Describes the cord wound onto a spool
+type WoundSpool struct {
+	// Thickness of the cord, 0 disables the model
+	CordThickness_MM float64
+
+	// Diameter of the bare spool core
+	CoreDiameter_MM float64
+
+	// Number of wraps in a single layer of cord
+	WrapsPerLayer float64
+
+	// Total length of cord attached to the spool
+	CordLength_MM float64
+
+	// Circumference that the step size is calculated from
+	Circumference_MM float64
+}
+
+// Create a WoundSpool from the settings object
+func WoundSpoolFromSettings() WoundSpool {
+	return Settings.WoundSpool()
+}
+
+// Create a WoundSpool from the given settings
+func (settings *SettingsData) WoundSpool() WoundSpool {
+	return WoundSpool{
+		CordThickness_MM: settings.CordThickness_MM,
+		CoreDiameter_MM:  settings.SpoolCoreDiameter_MM,
+		WrapsPerLayer:    settings.SpoolWrapsPerLayer,
+		CordLength_MM:    settings.CordLength_MM,
+		Circumference_MM: settings.SpoolCircumference_MM,
+	}
+}
+
+// Returns true if enough values are set to use the wound cord model
+func (spool WoundSpool) Enabled() bool {
+	return spool.CordThickness_MM > 0 && spool.CoreDiameter_MM > 0 && spool.WrapsPerLayer > 0 && spool.CordLength_MM > 0
+}
+
+// Number of rotations needed to wind the given length of cord onto the empty spool
+func (spool WoundSpool) rotations(woundLength float64) float64 {
+
+	// more cord has been let out than is attached, treat the remainder as being wound directly on the core
+	if woundLength < 0 {
+		return woundLength / (math.Pi * (spool.CoreDiameter_MM + spool.CordThickness_MM))
+	}
+
+	rotations := 0.0
+	for layer := 0.0; woundLength > 0; layer++ {
+		// cord in this layer sits on top of all of the layers below it
+		wrapCircumference := math.Pi * (spool.CoreDiameter_MM + (2*layer+1)*spool.CordThickness_MM)
+		layerLength := wrapCircumference * spool.WrapsPerLayer
+
+		if woundLength <= layerLength {
+			return rotations + woundLength/wrapCircumference
+		}
+
+		rotations += spool.WrapsPerLayer
+		woundLength -= layerLength
+	}
+
+	return rotations
+}
+
+// Convert a length of cord let out from the spool into how far the spool has turned, in mm of Circumference_MM
+// Without the wound cord model this is the cord length itself
+func (spool WoundSpool) SpoolDist(cordDist float64) float64 {
+	if !spool.Enabled() {
+		return cordDist
+	}
+
+	return (spool.rotations(spool.CordLength_MM) - spool.rotations(spool.CordLength_MM-cordDist)) * spool.Circumference_MM
+}
+
+// How far the spool turns per mm of cord let out at the given cord length, in mm of Circumference_MM
+func (spool WoundSpool) Rate(cordDist float64) float64 {
+	if !spool.Enabled() {
+		return 1
+	}
+
+	const delta = 0.01
+	return (spool.SpoolDist(cordDist+delta) - spool.SpoolDist(cordDist-delta)) / (2 * delta)
+}
+
+// Convert both cord lengths of a polar coordinate into spool distances
+func (spool WoundSpool) ToSpool(polarCoord PolarCoordinate) PolarCoordinate {
+	return PolarCoordinate{
+		LeftDist:  spool.SpoolDist(polarCoord.LeftDist),
+		RightDist: spool.SpoolDist(polarCoord.RightDist),
+		PenUp:     polarCoord.PenUp,
+	}
+}
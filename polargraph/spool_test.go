@@ -0,0 +1,63 @@
+package polargraph
+
+import (
+	"math"
+	"testing"
+)
+
+// Without the wound cord model the spool distance is the cord distance
+func TestSpoolDistDisabled(t *testing.T) {
+	spool := WoundSpool{Circumference_MM: 60}
+
+	if spool.Enabled() {
+		t.Error("Spool model should be disabled")
+	}
+
+	for _, dist := range []float64{-10, 0, 123.4, 1000} {
+		if spool.SpoolDist(dist) != dist {
+			t.Error("Expected", dist, "and got", spool.SpoolDist(dist))
+		}
+	}
+}
+
+// With a single layer the spool turns linearly, scaled by the wrap circumference
+func TestSpoolDistSingleLayer(t *testing.T) {
+	spool := WoundSpool{
+		CordThickness_MM: 1,
+		CoreDiameter_MM:  19,
+		WrapsPerLayer:    1000,
+		CordLength_MM:    2000,
+		Circumference_MM: 60,
+	}
+
+	wrapCircumference := math.Pi * 20
+	expected := 100.0 * 60 / wrapCircumference
+	if math.Abs(spool.SpoolDist(100)-expected) > 0.00001 {
+		t.Error("Expected", expected, "and got", spool.SpoolDist(100))
+	}
+}
+
+// Cord let out from the outer layers turns the spool less than cord from the inner layers
+func TestSpoolDistLayers(t *testing.T) {
+	spool := WoundSpool{
+		CordThickness_MM: 1,
+		CoreDiameter_MM:  10,
+		WrapsPerLayer:    10,
+		CordLength_MM:    3000,
+		Circumference_MM: 60,
+	}
+
+	previous := spool.SpoolDist(0)
+	previousDelta := 0.0
+	for dist := 100.0; dist <= 3000; dist += 100 {
+		current := spool.SpoolDist(dist)
+		delta := current - previous
+		if delta <= 0 {
+			t.Fatal("Spool distance should always increase, at", dist, "delta was", delta)
+		}
+		if delta < previousDelta-0.00001 {
+			t.Fatal("Spool should turn more per mm as the cord unwinds, at", dist, "delta was", delta, "previously", previousDelta)
+		}
+		previous, previousDelta = current, delta
+	}
+}
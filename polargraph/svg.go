@@ -1,97 +1,327 @@
 package polargraph
 
-// Reads an SVG file with path data and converts that to a series of Coordinates
+// Reads an SVG file with path data and converts that to a Path of lines, curves and arcs
 // PathParser is based on the canvg javascript code from http://code.google.com/p/canvg/
 
 import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"strings"
 
 	"github.com/rustyoz/svg"
 )
 
 // read a file
-func ParseSvgFile(fileName string) (data []Coordinate, svgWidth float64, svgHeight float64) {
-	file, err := os.Open(fileName)
+// Every segment records the layer, stroke colour and id of the element it was drawn by
+func ParseSvgFile(fileName string) (data Path, svgWidth float64, svgHeight float64, err error) {
+	contents, err := ioutil.ReadFile(fileName)
 	if err != nil {
-		panic(fmt.Errorf("Could not open SVG at %s", fileName))
+		return nil, 0, 0, fmt.Errorf("Could not open SVG at %s", fileName)
 	}
 
-	data = make([]Coordinate, 0)
+	data = make(Path, 0)
 
-	s, err := svg.ParseSvgFromReader(file, "Some", 1)
+	s, err := svg.ParseSvgFromReader(bytes.NewReader(contents), "Some", 1)
 
 	if err != nil {
-		panic(fmt.Errorf("Could not parse SVG, err: %s", err))
+		return nil, 0, 0, fmt.Errorf("Could not parse SVG, err: %s", err)
 	}
 
-	c, _ := s.ParseDrawingInstructions()
-	viewBox, _ := s.ViewBoxValues()
-	size, err := SVGSizeFromValues(s.Width, s.Height, viewBox)
+	groups, err := svgGroups(contents)
 	if err != nil {
-		panic(fmt.Errorf("Could not decode SVGSize, err: %s", err))
+		return nil, 0, 0, fmt.Errorf("Could not parse SVG, err: %s", err)
+	}
+
+	size, err := svgSize(s)
+	if err != nil {
+		return nil, 0, 0, err
 	}
 
 	svgWidth = size.width.ValueIn(Mm)
 	svgHeight = size.height.ValueIn(Mm)
 
-	fmt.Println("W:", size.width.ValueIn(Mm), "mm", " H:", size.height.ValueIn(Mm), "mm")
-
-	for msg := range c {
-		switch msg.Kind {
-		case svg.MoveInstruction:
-			values := [2]float64{msg.M[0], msg.M[1]}
-			coordinate := size.CoordinateFromM(values, true)
-			data = append(data, coordinate)
-		case svg.CircleInstruction:
-			fmt.Println("SVG: Circle not supported")
-		case svg.CurveInstruction:
-			fmt.Println("SVG: Curve not supported")
-		case svg.LineInstruction:
-			values := [2]float64{msg.M[0], msg.M[1]}
-			coordinate := size.CoordinateFromM(values, false)
-			data = append(data, coordinate)
-		case svg.CloseInstruction:
-			fmt.Println("SVG: Close not supported")
-		case svg.PaintInstruction:
-			// fmt.Println("Paint: ignoring")
-
-		default:
-			fmt.Println("Other:", msg.Kind)
-			panic("A")
-		}
+	fmt.Fprintln(Log, "W:", size.width.ValueIn(Mm), "mm", " H:", size.height.ValueIn(Mm), "mm")
+
+	for _, element := range svgElements(s, groups) {
+		c, errs := element.parser.ParseDrawingInstructions()
+		go func() {
+			// element errors were never reported, but the parser has to be able to send them
+			for range errs {
+			}
+		}()
+
+		for msg := range c {
+			var segments Path
+			switch msg.Kind {
+			case svg.MoveInstruction:
+				values := [2]float64{msg.M[0], msg.M[1]}
+				coordinate := size.CoordinateFromM(values, true)
+				segments = Path{LineTo(coordinate)}
+			case svg.CircleInstruction:
+				// move to the rightmost point of the circle then go all the way around it
+				values := [2]float64{msg.M[0] + *msg.Radius, msg.M[1]}
+				start := size.CoordinateFromM(values, true)
+				center := size.CoordinateFromM([2]float64{msg.M[0], msg.M[1]}, false)
+				segments = Path{LineTo(start), ArcAround(start, center, 2*math.Pi, false)}
+			case svg.CurveInstruction:
+				control1 := size.CoordinateFromM([2]float64{msg.CurvePoints.C1[0], msg.CurvePoints.C1[1]}, false)
+				control2 := size.CoordinateFromM([2]float64{msg.CurvePoints.C2[0], msg.CurvePoints.C2[1]}, false)
+				end := size.CoordinateFromM([2]float64{msg.CurvePoints.T[0], msg.CurvePoints.T[1]}, false)
+				segments = Path{CubicTo(control1, control2, end)}
+			case svg.LineInstruction:
+				values := [2]float64{msg.M[0], msg.M[1]}
+				coordinate := size.CoordinateFromM(values, false)
+				segments = Path{LineTo(coordinate)}
+			case svg.CloseInstruction:
+				fmt.Fprintln(Log, "SVG: Close not supported")
+			case svg.PaintInstruction:
+				// fmt.Println("Paint: ignoring")
+
+			default:
+				// keep reading so the parser isn't left blocked
+				if err == nil {
+					err = fmt.Errorf("Unsupported SVG instruction: %v", msg.Kind)
+				}
+			}
 
+			for _, segment := range segments {
+				data = append(data, segment.withSourceOf(element.source))
+			}
+		}
 	}
 
+	if err != nil {
+		return nil, 0, 0, err
+	}
 	return
 }
 
-func GenerateSvgPath(data Coordinates, plotCoords chan<- Coordinate) {
-	defer close(plotCoords)
+// An element of the svg, and the layer, colour and id its segments are drawn with
+type svgElement struct {
+	parser svg.DrawingInstructionParser
+	source Segment
+}
+
+// What the svg library doesn't read from a group's attributes
+type svgGroupAttributes struct {
+	// inkscape:label of a group that is an inkscape layer, blank for other groups
+	layer string
+
+	// stroke set in the group's style
+	stroke string
+}
+
+// The attributes of every group, in the order the groups start in the file, which is the order the svg library reads them
+func svgGroups(contents []byte) (groups []svgGroupAttributes, err error) {
+	decoder := xml.NewDecoder(bytes.NewReader(contents))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return groups, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "g" {
+			continue
+		}
+		var group svgGroupAttributes
+		var label string
+		isLayer := false
+		for _, attr := range start.Attr {
+			switch attr.Name.Local {
+			case "label":
+				label = attr.Value
+			case "groupmode":
+				isLayer = attr.Value == "layer"
+			case "style":
+				group.stroke = svgStyleStroke(attr.Value, "")
+			}
+		}
+		if isLayer {
+			group.layer = label
+		}
+		groups = append(groups, group)
+	}
+}
+
+// The stroke set in a style attribute, or stroke if it doesn't set one
+func svgStyleStroke(style string, stroke string) string {
+	for _, property := range strings.Split(style, ";") {
+		if keyValue := strings.SplitN(property, ":", 2); len(keyValue) == 2 && strings.TrimSpace(keyValue[0]) == "stroke" {
+			stroke = strings.TrimSpace(keyValue[1])
+		}
+	}
+	return stroke
+}
 
-	minPoint, maxPoint := data.Extents()
+// Walks the elements of an svg in the order they are drawn, keeping track of the groups they are in
+type svgWalk struct {
+	groups   []svgGroupAttributes
+	walked   int
+	elements []svgElement
+}
+
+// The drawable elements of the svg in the order they are drawn, elements outside any group first
+// An element's layer is the label of the inkscape layer it is in, or the id of its outermost group when it isn't in a layer
+func svgElements(s *svg.Svg, groups []svgGroupAttributes) []svgElement {
+	walk := &svgWalk{groups: groups}
+	walk.add(s.Elements, Segment{}, false)
+	for index := range s.Groups {
+		walk.group(&s.Groups[index], Segment{}, false)
+	}
+	return walk.elements
+}
+
+// Walk the elements of the group, which is the next group to start in the file
+func (walk *svgWalk) group(g *svg.Group, inherited Segment, inLayer bool) {
+	var attributes svgGroupAttributes
+	if walk.walked < len(walk.groups) {
+		attributes = walk.groups[walk.walked]
+	}
+	walk.walked++
+
+	if attributes.layer != "" {
+		inherited.Layer, inLayer = attributes.layer, true
+	} else if !inLayer && inherited.Layer == "" {
+		inherited.Layer = g.ID
+	}
+	if g.Stroke != "" {
+		inherited.Color = g.Stroke
+	}
+	if attributes.stroke != "" {
+		inherited.Color = attributes.stroke
+	}
+	walk.add(g.Elements, inherited, inLayer)
+}
+
+// Add the elements, drawn with the layer and colour inherited from the groups they are in unless they set their own
+func (walk *svgWalk) add(parsers []svg.DrawingInstructionParser, inherited Segment, inLayer bool) {
+	for _, parser := range parsers {
+		source := inherited
+		switch element := parser.(type) {
+		case *svg.Group:
+			walk.group(element, inherited, inLayer)
+			continue
+		case *svg.Path:
+			source.SourceID = element.ID
+			if element.Stroke != nil && *element.Stroke != "" {
+				source.Color = *element.Stroke
+			}
+			source.Color = svgStyleStroke(element.Style, source.Color)
+		case *svg.Circle:
+			source.SourceID = element.ID
+			source.Color = svgStyleStroke(element.Style, source.Color)
+		case *svg.Rect:
+			source.SourceID = element.ID
+			source.Color = svgStyleStroke(element.Style, source.Color)
+		}
+		source.Color = strings.ToLower(source.Color)
+		walk.elements = append(walk.elements, svgElement{parser: parser, source: source})
+	}
+}
+
+// A pipeline source that reads the svg file and sends its path, starting and finishing at the origin with the pen up
+func SvgSource(fileName string) Source {
+	return func(ctx context.Context, out chan<- Segment) error {
+		fmt.Fprintln(Log, "Generating svg path")
+		data, err := cachedSvgPath(Settings.PathCache(), fileName)
+		if err != nil {
+			return err
+		}
+
+		framed, err := frameSvgPath(data)
+		if err != nil {
+			return err
+		}
+		return SendPath(ctx, out, framed)
+	}
+}
+
+// Size of the svg's width and height
+func svgSize(s *svg.Svg) (SVGSize, error) {
+	viewBox, _ := s.ViewBoxValues()
+	size, err := SVGSizeFromValues(s.Width, s.Height, viewBox)
+	if err != nil {
+		return size, fmt.Errorf("Could not decode SVGSize, err: %s", err)
+	}
+	return size, nil
+}
+
+// Width and height in mm of an svg file, without parsing its path data
+func SvgFileSize(fileName string) (svgWidth float64, svgHeight float64, err error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Could not open SVG at %s", fileName)
+	}
+	defer file.Close()
+
+	s, err := svg.ParseSvgFromReader(file, "Some", 1)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Could not parse SVG, err: %s", err)
+	}
+	size, err := svgSize(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	return size.width.ValueIn(Mm), size.height.ValueIn(Mm), nil
+}
+
+// Parse the svg file, or load the path parsed from a file with the same contents last time
+func cachedSvgPath(cache PathCache, fileName string) (Path, error) {
+	fileHash, err := HashFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	key := CacheKey("svg", svgCacheVersion, fileHash)
+	if data, ok := cache.Load(key); ok {
+		fmt.Fprintln(Log, "Using cached svg path")
+		return data, nil
+	}
+
+	data, _, _, err := ParseSvgFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	cache.storeOrWarn(key, data)
+	return data, nil
+}
+
+// Check the path fits on the drawing surface and add the moves from and back to the origin
+func frameSvgPath(data Path) (Path, error) {
+	if len(data) == 0 {
+		return nil, errors.New("SVG has no path data")
+	}
+
+	minPoint, maxPoint := data.Coordinates(Settings.StepSize_MM).Extents()
 
 	imageSize := maxPoint.Minus(minPoint)
 
-	fmt.Println("SVG Min:", minPoint, "Max:", maxPoint)
+	fmt.Fprintln(Log, "SVG Min:", minPoint, "Max:", maxPoint)
 
 	if imageSize.X > (Settings.DrawingSurfaceMaxX_MM-Settings.DrawingSurfaceMinX_MM) || imageSize.Y > (Settings.DrawingSurfaceMaxY_MM-Settings.DrawingSurfaceMinY_MM) {
-		panic(fmt.Sprint(
+		return nil, errors.New(fmt.Sprint(
 			"SVG coordinates extend past drawable surface, as defined in setup. Svg size was: ",
 			imageSize,
 			" And settings bounds are, X: ", Settings.DrawingSurfaceMaxX_MM, " - ", Settings.DrawingSurfaceMinX_MM,
 			" Y: ", Settings.DrawingSurfaceMaxY_MM, " - ", Settings.DrawingSurfaceMinY_MM))
 	}
 
-	plotCoords <- Coordinate{X: 0, Y: 0, PenUp: true}
-	firstPoint := data[0]
-	plotCoords <- Coordinate{X: firstPoint.X, Y: firstPoint.Y, PenUp: true}
-
-	for index := 0; index < len(data); index++ {
-		curTarget := data[index]
-		plotCoords <- curTarget
-	}
-
-	plotCoords <- Coordinate{X: 0, Y: 0, PenUp: true}
+	firstPoint := data[0].End
+	framed := make(Path, 0, len(data)+3)
+	framed = append(framed, LineTo(Coordinate{X: 0, Y: 0, PenUp: true}))
+	framed = append(framed, LineTo(Coordinate{X: firstPoint.X, Y: firstPoint.Y, PenUp: true}))
+	framed = append(framed, data...)
+	framed = append(framed, LineTo(Coordinate{X: 0, Y: 0, PenUp: true}))
 
+	return framed, nil
 }
@@ -0,0 +1,130 @@
+package polargraph
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Two layers and a plain group, the second layer retraces lines of the first in another colour
+const layeredSvg = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape" width="100mm" height="100mm" viewBox="0 0 100 100">
+  <g id="layer1" inkscape:groupmode="layer" inkscape:label="Outline" style="stroke:#FF0000">
+    <path id="edge" d="M 10 10 L 20 10"/>
+    <g id="detail">
+      <path id="side" d="M 20 10 L 20 20"/>
+      <path id="diagonal" style="fill:none;stroke:#0000ff" d="M 20 20 L 10 10"/>
+      <path id="again" d="M 20 10 L 10 10"/>
+    </g>
+  </g>
+  <g id="layer2" inkscape:groupmode="layer" inkscape:label="Shading">
+    <path id="over" stroke="#ff0000" d="M 10 10 L 20 10"/>
+  </g>
+  <g id="plain" stroke="#00ff00">
+    <circle id="dot" cx="50" cy="50" r="5"/>
+  </g>
+</svg>`
+
+// Write the layered svg to a file
+func writeLayeredSvg(t testing.TB) string {
+	fileName := filepath.Join(t.TempDir(), "layered.svg")
+	if err := ioutil.WriteFile(fileName, []byte(layeredSvg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+// Parse the svg from a file and split it into glyphs
+func parseLayeredSvg(t testing.TB) (Path, []Glyph) {
+	data, _, _, err := ParseSvgFile(writeLayeredSvg(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, makeGlyphs(t, data)
+}
+
+// Every segment should know the layer, colour and element it was drawn by
+func TestParseSvgMetadata(t *testing.T) {
+	data, glyphs := parseLayeredSvg(t)
+
+	expected := []Glyph{
+		{Layer: "Outline", Color: "#ff0000", SourceID: "edge"},
+		{Layer: "Outline", Color: "#ff0000", SourceID: "side"},
+		{Layer: "Outline", Color: "#0000ff", SourceID: "diagonal"},
+		{Layer: "Outline", Color: "#ff0000", SourceID: "again"},
+		{Layer: "Shading", Color: "#ff0000", SourceID: "over"},
+		{Layer: "plain", Color: "#00ff00", SourceID: "dot"},
+	}
+	if len(glyphs) != len(expected) {
+		t.Fatal("Expected", len(expected), "glyphs and got", len(glyphs), data)
+	}
+	for index, glyph := range glyphs {
+		if glyph.Layer != expected[index].Layer || glyph.Color != expected[index].Color || glyph.SourceID != expected[index].SourceID {
+			t.Error("Expected", expected[index].Layer, expected[index].Color, expected[index].SourceID, "and got", glyph.Layer, glyph.Color, glyph.SourceID)
+		}
+		for _, segment := range glyph.Segments {
+			if segment.style() != glyph.style() || segment.SourceID != glyph.SourceID {
+				t.Error("Expected every segment of", glyph.SourceID, "to be drawn like it and got", segment)
+			}
+		}
+	}
+	if glyphs[0].Closed || !glyphs[5].Closed {
+		t.Error("Expected only the circle to be closed")
+	}
+}
+
+// Glyphs of a parsed svg should only be joined, merged and deduped with glyphs of the same layer and colour
+func TestSvgGlyphsKeepStyles(t *testing.T) {
+	_, glyphs := parseLayeredSvg(t)
+	edge, side, diagonal, again, over := glyphs[0], glyphs[1], glyphs[2], glyphs[3], glyphs[4]
+
+	if !edge.CanBeMergedWith(side, 0) || side.CanBeMergedWith(diagonal, 0) || again.Reversed().CanBeMergedWith(over.Reversed(), 0) {
+		t.Error("Expected only glyphs of the same layer and colour to be mergeable")
+	}
+
+	joined := JoinGlyphs([]Glyph{edge, side, diagonal}, 0.01)
+	if len(joined) != 2 || joined[0].SourceID != "edge" || joined[0].Segments[2].SourceID != "side" || joined[1].SourceID != "diagonal" {
+		t.Error("Expected the edge joined to the side, and the blue diagonal on its own, got", joined)
+	}
+
+	reversed := diagonal.Reversed()
+	if reversed.SourceID != "diagonal" || reversed.Segments[1].Color != "#0000ff" {
+		t.Error("Expected reversing to keep the metadata and got", reversed)
+	}
+
+	// again retraces edge in the same layer, over retraces it in another layer
+	deduped, removed := DedupGlyphs([]Glyph{edge, again, over}, 0.01)
+	if len(deduped) != 2 || deduped[0].SourceID != "edge" || deduped[1].SourceID != "over" || removed == 0 {
+		t.Error("Expected only the retrace in the same layer removed and got", deduped, removed)
+	}
+
+	trails, _ := EulerianGlyphs([]Glyph{edge, side, diagonal}, 0)
+	if len(trails) != 2 || trails[0].Color != "#ff0000" || trails[1].Color != "#0000ff" {
+		t.Error("Expected a red trail and a blue one and got", trails)
+	}
+}
+
+// A path cached by a parser without a version in its key shouldn't be loaded instead of parsing the svg again
+func TestCachedSvgPathVersion(t *testing.T) {
+	fileName := writeLayeredSvg(t)
+	fileHash, err := HashFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := PathCache{Dir: t.TempDir()}
+	stale := Path{LineTo(Coordinate{X: 1, Y: 1, PenUp: true})}
+	if err := cache.Store(CacheKey("svg", fileHash), stale); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := cachedSvgPath(cache, fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == len(stale) || data[0].SourceID != "edge" {
+		t.Error("Expected the svg parsed again and got", data)
+	}
+	if cached, ok := cache.Load(CacheKey("svg", svgCacheVersion, fileHash)); !ok || len(cached) != len(data) {
+		t.Error("Expected the parsed path cached under the current version and got", cached)
+	}
+}